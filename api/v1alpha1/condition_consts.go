@@ -0,0 +1,64 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+const (
+	// GatewayDiscoveredCondition reports whether the gateways of a pool with DiscoverGateway set were found in Netbox.
+	GatewayDiscoveredCondition clusterv1.ConditionType = "GatewayDiscovered"
+
+	// GatewayNotFoundReason is used when Netbox has no gateway candidate for a prefix or ip-range of the pool.
+	GatewayNotFoundReason = "GatewayNotFound"
+
+	// MultipleGatewaysReason is used when Netbox has more than one gateway candidate for a prefix or ip-range of the
+	// pool.
+	MultipleGatewaysReason = "MultipleGateways"
+
+	// CapacityLowCondition reports whether the free IPs of a pool with Thresholds reached one of the thresholds.
+	CapacityLowCondition clusterv1.ConditionType = "CapacityLow"
+
+	// CapacityWarningReason is used when the free IPs of the pool reached the Warning threshold.
+	CapacityWarningReason = "WarningThresholdReached"
+
+	// CapacityCriticalReason is used when the free IPs of the pool reached the Critical threshold.
+	CapacityCriticalReason = "CriticalThresholdReached"
+
+	// CapacitySufficientReason is used when the free IPs of the pool are above the thresholds.
+	CapacitySufficientReason = "CapacitySufficient"
+
+	// NetboxAccessCondition reports whether Netbox can be reached with the credentials of the pool, and the token has
+	// the permissions the provider needs. It is checked until it succeeds once.
+	NetboxAccessCondition clusterv1.ConditionType = "NetboxAccess"
+
+	// NetboxUnreachableReason is used when Netbox can not be reached with the credentials of the pool.
+	NetboxUnreachableReason = "NetboxUnreachable"
+
+	// MissingPermissionsReason is used when the token of the pool is missing permissions the provider needs.
+	MissingPermissionsReason = "MissingPermissions"
+
+	// NetboxVersionSupportedCondition reports whether the version of Netbox of the pool is supported by the provider.
+	// Addresses are not allocated from a pool with an unsupported version.
+	NetboxVersionSupportedCondition clusterv1.ConditionType = "NetboxVersionSupported"
+
+	// UnsupportedVersionReason is used when the version of Netbox of the pool is not supported by the provider.
+	UnsupportedVersionReason = "UnsupportedVersion"
+
+	// VersionUnknownReason is used when the version of Netbox of the pool could not be determined, for example because
+	// Netbox can not be reached.
+	VersionUnknownReason = "VersionUnknown"
+)
@@ -19,12 +19,61 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
 type NetboxPoolType string
 
+type AllocationMode string
+
+type AllocationStrategy string
+
+type VMMatchStrategy string
+
+type AddressStatus string
+
 const (
 	NetboxIPPoolKind = "NetboxIPPool"
+
+	// AdoptAddressAnnotation can be set on an IPAddressClaim to adopt an address that already exists in Netbox,
+	// instead of allocating a new one. The address must be part of the pool and not be used by another claim.
+	AdoptAddressAnnotation = "netbox.ipam.cluster.x-k8s.io/adopt-address"
+
+	// AllowMigrationAnnotation can be set on a NetboxIPPool to allow changing its CIDR, Vrf or Type while addresses are
+	// allocated from it. The allocated addresses keep their address, and are reported as out of range until they are
+	// released.
+	AllowMigrationAnnotation = "netbox.ipam.cluster.x-k8s.io/allow-migration"
+
+	// FQDNAnnotation is set on an IPAddress to the DNS name of the address in Netbox, rendered from the
+	// DNSNamePattern of the pool.
+	FQDNAnnotation = "netbox.ipam.cluster.x-k8s.io/fqdn"
+
+	// InventoryPoolAnnotation is set on a Cluster to the name of the NetboxIPPool whose credentials are used to sync
+	// the Cluster and its Machines into Netbox. If not set, the pool of the first address of the Cluster is recorded.
+	InventoryPoolAnnotation = "netbox.ipam.cluster.x-k8s.io/inventory-pool"
+
+	// IPFamilyAnnotation can be set on an IPAddressClaim to select the address family (IPv4 or IPv6) that is allocated
+	// from a dual-stack pool. Without it, the family of the requested or adopted address is used, otherwise the family
+	// of the CIDR of the pool.
+	IPFamilyAnnotation = "netbox.ipam.cluster.x-k8s.io/ip-family"
+
+	// PrimaryAddressAnnotation can be set to "true" on an IPAddressClaim to mark the allocated address as the primary
+	// address of its family on the Netbox virtual machine or device it is assigned to.
+	PrimaryAddressAnnotation = "netbox.ipam.cluster.x-k8s.io/primary-address"
+
+	// PreviousPrimaryAnnotation is set on an IPAddress that was marked as primary address, to the Netbox id of the
+	// primary address it replaced, or 0 if there was none. The previous primary address is restored on release.
+	PreviousPrimaryAnnotation = "netbox.ipam.cluster.x-k8s.io/previous-primary"
+
+	// OutOfRangeAnnotation is set on an IPAddressClaim to its address, when the address is not part of the prefixes
+	// and ip-ranges of its pool in Netbox anymore, for example after the prefix was shrunk or the CIDR of the pool was
+	// changed. The annotation is removed when the address is part of the pool again.
+	OutOfRangeAnnotation = "netbox.ipam.cluster.x-k8s.io/out-of-range"
+
+	// RequestedAddressAnnotation can be set on an IPAddressClaim to allocate a specific address of the pool. The
+	// address is created in Netbox, allocation fails if the address is already taken.
+	RequestedAddressAnnotation = "netbox.ipam.cluster.x-k8s.io/requested-address"
 )
 
 var (
@@ -32,6 +81,32 @@ var (
 	IPRangeType = NetboxPoolType("IPRange")
 )
 
+var (
+	AddressAllocationMode = AllocationMode("Address")
+	PrefixAllocationMode  = AllocationMode("Prefix")
+)
+
+var (
+	FirstFreeAllocationStrategy = AllocationStrategy("FirstFree")
+	RandomAllocationStrategy    = AllocationStrategy("Random")
+	StickyAllocationStrategy    = AllocationStrategy("Sticky")
+)
+
+var (
+	NameMatchStrategy        = VMMatchStrategy("Name")
+	CustomFieldMatchStrategy = VMMatchStrategy("CustomField")
+)
+
+var (
+	ActiveAddressStatus     = AddressStatus("active")
+	ReservedAddressStatus   = AddressStatus("reserved")
+	DHCPAddressStatus       = AddressStatus("dhcp")
+	DeprecatedAddressStatus = AddressStatus("deprecated")
+)
+
+// DefaultVMInterface is the name of the virtual machine interface addresses are assigned to, if not configured.
+const DefaultVMInterface = "eth0"
+
 // NetboxIPPoolSpec defines the desired state of NetboxIPPool
 type NetboxIPPoolSpec struct {
 	// Type of the pool. Can either be Prefix or IPRange
@@ -39,6 +114,8 @@ type NetboxIPPoolSpec struct {
 	Type NetboxPoolType `json:"type"`
 
 	// Depending on the type, an CIDR is either the prefix or the start address of an ip-range, in CIDR notation.
+	// Type, CIDR and Vrf can not be changed while addresses are allocated from the pool, unless the pool has the
+	// netbox.ipam.cluster.x-k8s.io/allow-migration annotation.
 	CIDR string `json:"cidr"`
 
 	// Vrf where the CIDR is part of. If not provided, the "Global" Vrf is used.
@@ -49,17 +126,242 @@ type NetboxIPPoolSpec struct {
 	// +optional
 	Gateway string `json:"gateway,omitempty"`
 
+	// AllocationMode defines what is allocated for a claim. In Address mode (the default) a single address of the pool
+	// is allocated. In Prefix mode a child prefix of PrefixLength is carved out of the prefix of the pool, and the
+	// IPAddress describes the allocated subnet by its network address and prefix length. Prefix mode requires a pool
+	// of type Prefix.
+	// +kubebuilder:validation:Enum=Address;Prefix
+	// +optional
+	AllocationMode AllocationMode `json:"allocationMode,omitempty"`
+
+	// AllocationStrategy defines which free address of the pool is allocated for a claim. FirstFree (the default)
+	// takes the lowest free address. Random takes a random free address, out of the lowest 1000 free addresses of a
+	// prefix or ip-range. Sticky takes the address that was last released by a Machine with the name of the Machine
+	// owning the claim, if it is still free, and the lowest free address otherwise, so that replaced Machines keep
+	// their addresses. AllocationStrategy can not be used in Prefix allocation mode.
+	// +kubebuilder:validation:Enum=FirstFree;Random;Sticky
+	// +optional
+	AllocationStrategy AllocationStrategy `json:"allocationStrategy,omitempty"`
+
+	// PrefixLength is the length of the child prefixes allocated in Prefix allocation mode.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=128
+	// +optional
+	PrefixLength int `json:"prefixLength,omitempty"`
+
+	// DiscoverGateway enables looking up the gateway in Netbox, for the prefixes and ip-ranges of the pool that have
+	// no Gateway configured. The gateway is the address in the prefix or ip-range with the "gateway" role, or the
+	// address in the "gateway" custom field of the prefix or ip-range. There must be exactly one candidate.
+	// +optional
+	DiscoverGateway bool `json:"discoverGateway,omitempty"`
+
+	// Exclude lists addresses of the pool that are never allocated, for example addresses used by network equipment.
+	// Entries can be single addresses, CIDRs or ranges in the form start-end, and must be part of the CIDR (or of the
+	// CIDR of DualStack).
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+
+	// AdoptExisting enables importing addresses that already exist in Netbox. When set, a claim is linked to the
+	// address in the pool whose description equals the name of the claim, before a new address is allocated.
+	// +optional
+	AdoptExisting bool `json:"adoptExisting,omitempty"`
+
+	// DNSNamePattern is a Go template for the DNS name of allocated addresses in Netbox, for example
+	// "{{.Machine}}.{{.Cluster}}.example.com". The fields Claim, Namespace, Machine, Cluster and Pool can be used.
+	// The DNS name is kept up to date when the Machine owning the claim changes, and is exposed on the IPAddress with
+	// the FQDNAnnotation. DNSNamePattern can not be used in Prefix allocation mode.
+	// +optional
+	DNSNamePattern string `json:"dnsNamePattern,omitempty"`
+
+	// PrimaryAddress marks allocated addresses as the primary address of their family on the Netbox virtual machine
+	// or device they are assigned to. Claims can also request this with the PrimaryAddressAnnotation. PrimaryAddress
+	// can not be used in Prefix allocation mode.
+	// +optional
+	PrimaryAddress bool `json:"primaryAddress,omitempty"`
+
+	// Status is the Netbox status of addresses created for claims. If not set, Netbox assigns its default status
+	// (active). Status can not be used in Prefix allocation mode.
+	// +kubebuilder:validation:Enum=active;reserved;dhcp;deprecated
+	// +optional
+	Status AddressStatus `json:"status,omitempty"`
+
+	// Quarantine keeps released addresses in Netbox for a period, with the quarantine status, instead of deleting
+	// them right away. This avoids reusing an address that is still in ARP caches or DNS. Quarantined addresses are
+	// not allocated, requested or adopted by claims, and are deleted once the period expires. Quarantine can not be
+	// used in Prefix allocation mode.
+	// +optional
+	Quarantine *AddressQuarantine `json:"quarantine,omitempty"`
+
+	// Quota limits the number of addresses allocated from the pool, so that a single Cluster or namespace can not
+	// drain a prefix that is shared with others. Claims beyond the quota fail to allocate; addresses that are already
+	// allocated are kept when the quota is lowered.
+	// +optional
+	Quota *AddressQuota `json:"quota,omitempty"`
+
+	// Thresholds defines when the capacity of the pool is low. The free IPs reported in the status are checked
+	// against the thresholds, and the CapacityLow condition is set when a threshold is reached.
+	// +optional
+	Thresholds *CapacityThresholds `json:"thresholds,omitempty"`
+
+	// ResyncInterval is how often the usage of the pool is refreshed from Netbox, to pick up addresses that are
+	// created or deleted in Netbox by other tools. It overrides the resync interval of the controller, and 0 disables
+	// the resync of the pool. Up to 10% of the interval is added at random, so pools are not refreshed all at once.
+	// +optional
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+
+	// VMInterface enables assigning allocated addresses to an interface of the Netbox virtual machine of the Machine
+	// owning the claim. VMInterface can not be used in Prefix allocation mode.
+	// +optional
+	VMInterface *VMInterfaceAssignment `json:"vmInterface,omitempty"`
+
+	// DualStack references the prefix or ip-range of the second address family of a dual-stack pool. Claims select
+	// the family to allocate from with the IPFamilyAnnotation. DualStack can not be used in Prefix allocation mode.
+	// +optional
+	DualStack *NetboxPoolReference `json:"dualStack,omitempty"`
+
+	// Fallback lists additional prefixes or ip-ranges of the pool. Addresses are allocated from the CIDR (or the
+	// DualStack CIDR) of the family first, and fall through to the members of Fallback of that family, in order, when
+	// it is exhausted.
+	// +optional
+	Fallback []NetboxPoolReference `json:"fallback,omitempty"`
+
 	// CredentialsRef is a reference to a Secret that contains the credentials to use for accessing th Netbox instance.
 	// if no namespace is provided, the namespace of the NetboxIPPool will be used.
 	CredentialsRef *corev1.SecretReference `json:"credentialsRef,omitempty"`
 }
 
+// AddressQuarantine describes how released addresses are kept in Netbox before they are deleted. Quarantined
+// addresses are marked with the time they were released in the quarantined_since custom field, which must exist as a
+// text custom field of ip-addresses in Netbox. The description of the addresses is left alone.
+type AddressQuarantine struct {
+	// Status is the Netbox status of quarantined addresses.
+	// +kubebuilder:validation:Enum=active;reserved;dhcp;deprecated
+	// +kubebuilder:default=deprecated
+	// +optional
+	Status AddressStatus `json:"status,omitempty"`
+
+	// Period is how long released addresses are kept in Netbox.
+	Period metav1.Duration `json:"period"`
+}
+
+// AddressQuota limits the number of addresses allocated from a pool. The addresses are counted in the prefixes and
+// ip-ranges of the pool, including the addresses allocated from the other pools of the namespace that share a prefix or
+// ip-range with the pool.
+type AddressQuota struct {
+	// PerCluster is the maximum number of addresses allocated to the claims of a single Cluster. The Cluster of a
+	// claim is taken from its cluster.x-k8s.io/cluster-name label, claims without the label are not limited.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PerCluster *int `json:"perCluster,omitempty"`
+
+	// PerNamespace is the maximum number of addresses allocated to the claims of the namespace of the pool. Claims
+	// reference pools in their own namespace, so when a prefix is shared by the pools of several namespaces, this
+	// limits the share of the namespace, whichever of its pools the claims reference.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PerNamespace *int `json:"perNamespace,omitempty"`
+}
+
+// CapacityThresholds defines the number of free IPs at which the capacity of a pool is low. A threshold is either an
+// absolute count of free IPs, or a percentage of the total IPs of the pool, like "10%". With DualStack, the thresholds
+// apply to each address family.
+type CapacityThresholds struct {
+	// Warning is the count of free IPs at or below which the capacity is low.
+	// +kubebuilder:validation:XIntOrString
+	// +optional
+	Warning *intstr.IntOrString `json:"warning,omitempty"`
+
+	// Critical is the count of free IPs at or below which the capacity is critically low.
+	// +kubebuilder:validation:XIntOrString
+	// +optional
+	Critical *intstr.IntOrString `json:"critical,omitempty"`
+}
+
+// VMInterfaceAssignment describes how addresses are assigned to the interface of a Netbox virtual machine.
+type VMInterfaceAssignment struct {
+	// MatchBy selects how the virtual machine of a Machine is found in Netbox. With Name, the virtual machine has the
+	// name of the Machine. With CustomField, the CustomField of the virtual machine holds the UID of the Machine.
+	// +kubebuilder:validation:Enum=Name;CustomField
+	// +kubebuilder:default=Name
+	// +optional
+	MatchBy VMMatchStrategy `json:"matchBy,omitempty"`
+
+	// CustomField is the custom field of the virtual machine holding the UID of the Machine. It is required when
+	// matching by CustomField.
+	// +optional
+	CustomField string `json:"customField,omitempty"`
+
+	// Interface is the name of the interface of the virtual machine the addresses are assigned to.
+	// +kubebuilder:default=eth0
+	// +optional
+	Interface string `json:"interface,omitempty"`
+
+	// Create enables creating the virtual machine and its interface in Netbox when they do not exist.
+	// +optional
+	Create bool `json:"create,omitempty"`
+
+	// Cluster is the name of the Netbox cluster virtual machines are created in. If not set, the name of the Cluster
+	// of the claim is used.
+	// +optional
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// NetboxPoolReference references a prefix or ip-range in Netbox.
+type NetboxPoolReference struct {
+	// Type of the pool. Can either be Prefix or IPRange
+	// +kubebuilder:validation:Enum=Prefix;IPRange
+	Type NetboxPoolType `json:"type"`
+
+	// Depending on the type, an CIDR is either the prefix or the start address of an ip-range, in CIDR notation.
+	CIDR string `json:"cidr"`
+
+	// Vrf where the CIDR is part of. If not provided, the "Global" Vrf is used.
+	// +optional
+	Vrf string `json:"vrf,omitempty"`
+
+	// Gateway
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+}
+
 // NetboxIPPoolStatus defines the observed state of NetboxIPPool
 type NetboxIPPoolStatus struct {
 	// Addresses reports the count of total, free, and used IPs in the pool.
 	// +optional
 	Addresses *NetboxPoolStatusIPAddresses `json:"ipAddresses,omitempty"`
 
+	// DualStackAddresses reports the count of total, free, and used IPs of the DualStack prefix or ip-range.
+	// +optional
+	DualStackAddresses *NetboxPoolStatusIPAddresses `json:"dualStackIPAddresses,omitempty"`
+
+	// Members reports the count of total, free, and used IPs per prefix or ip-range of the pool. Addresses and
+	// DualStackAddresses report the sum over the members of their family.
+	// +optional
+	Members []NetboxPoolStatusMember `json:"members,omitempty"`
+
+	// Gateway is the gateway of the pool, either configured in the spec or discovered in Netbox.
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+
+	// Conditions defines current service state of the NetboxIPPool.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+
+	// OutOfRangeAddresses lists the IPAddresses of the pool whose address is not part of the prefixes and ip-ranges
+	// of the pool in Netbox. Their claims have the OutOfRangeAnnotation.
+	// +optional
+	OutOfRangeAddresses []NetboxPoolStatusOutOfRangeAddress `json:"outOfRangeAddresses,omitempty"`
+
+	// Clusters reports the count of used IPs per Cluster, for the claims with the cluster.x-k8s.io/cluster-name
+	// label.
+	// +optional
+	Clusters []NetboxPoolStatusCluster `json:"clusters,omitempty"`
+
+	// StickyAddresses records the addresses last released by Machines, for pools with the Sticky allocation
+	// strategy. Only the most recently released addresses are kept.
+	// +optional
+	StickyAddresses []StickyAddress `json:"stickyAddresses,omitempty"`
+
 	// NetboxId is the Id in Netbox.
 	// +optional
 	NetboxId int `json:"netboxId,omitempty"`
@@ -67,11 +369,43 @@ type NetboxIPPoolStatus struct {
 	// NetboxType is the Type in Netbox.
 	// +optional
 	NetboxType string `json:"netboxType,omitempty"`
+
+	// NetboxVersion is the version of Netbox the pool is in.
+	// +optional
+	NetboxVersion string `json:"netboxVersion,omitempty"`
+
+	// Migration records the prefix or ip-range the pool referenced before its CIDR, Vrf or Type was changed, while
+	// addresses allocated from it are still in use. It is removed once these addresses are released.
+	// +optional
+	Migration *NetboxPoolStatusMigration `json:"migration,omitempty"`
 }
 
-// NetboxPoolStatusIPAddresses contains the count of total, free, and used IPs in a pool.
+// NetboxPoolStatusMigration is the prefix or ip-range a pool referenced before its CIDR, Vrf or Type was changed.
+type NetboxPoolStatusMigration struct {
+	// NetboxId is the previous Id in Netbox.
+	NetboxId int `json:"netboxId"`
+
+	// NetboxType is the previous Type in Netbox.
+	// +optional
+	NetboxType string `json:"netboxType,omitempty"`
+
+	// CIDR is the previous CIDR of the pool.
+	// +optional
+	CIDR string `json:"cidr,omitempty"`
+
+	// Vrf is the previous vrf of the pool. Addresses allocated before the migration are released from this vrf.
+	// +optional
+	Vrf string `json:"vrf,omitempty"`
+
+	// Addresses are the addresses allocated from the previous prefix or ip-range that are still in use.
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// NetboxPoolStatusIPAddresses contains the count of total, free, and used IPs in a pool. In Prefix allocation mode
+// the counts are in child prefixes of the configured PrefixLength instead of IPs.
 type NetboxPoolStatusIPAddresses struct {
-	// Total is the total number of IPs configured for the pool.
+	// Total is the total number of IPs configured for the pool, without the gateway and the excluded IPs.
 	// Counts greater than int can contain will report as math.MaxInt.
 	Total int `json:"total"`
 
@@ -79,13 +413,65 @@ type NetboxPoolStatusIPAddresses struct {
 	// Counts greater than int can contain will report as math.MaxInt.
 	Free int `json:"free"`
 
-	// Used is the count of allocated IPs in the pool.
+	// Used is the count of allocated IPs in the pool, not counting the gateway and the excluded IPs.
 	// Counts greater than int can contain will report as math.MaxInt.
 	Used int `json:"used"`
 
-	// Extra is the count of allocated IPs in the pool.
-	// Counts greater than int can contain will report as math.MaxInt.
+	// Extra is the count of IPAddresses of the pool whose address is not part of the prefixes and ip-ranges of the
+	// pool in Netbox. It is only reported for the pool, not per member.
 	Extra int `json:"extra"`
+
+	// Quarantined is the count of released IPs in the pool that are quarantined, and are not allocated again until
+	// the quarantine period expires. Quarantined IPs are counted as used.
+	// +optional
+	Quarantined int `json:"quarantined,omitempty"`
+}
+
+// NetboxPoolStatusOutOfRangeAddress is an IPAddress whose address is not part of its pool.
+type NetboxPoolStatusOutOfRangeAddress struct {
+	// Name of the IPAddress.
+	Name string `json:"name"`
+
+	// Claim is the name of the IPAddressClaim of the IPAddress.
+	Claim string `json:"claim"`
+
+	// Address of the IPAddress.
+	Address string `json:"address"`
+}
+
+// NetboxPoolStatusCluster contains the count of IPs of a pool that are used by the claims of a Cluster.
+type NetboxPoolStatusCluster struct {
+	// Name of the Cluster.
+	Name string `json:"name"`
+
+	// Used is the count of IPs allocated to the claims of the Cluster.
+	Used int `json:"used"`
+}
+
+// StickyAddress is an address that was released by a Machine.
+type StickyAddress struct {
+	// Machine is the name of the Machine.
+	Machine string `json:"machine"`
+
+	// Address is the address the Machine held.
+	Address string `json:"address"`
+}
+
+// NetboxPoolStatusMember contains the count of total, free, and used IPs of a single prefix or ip-range of a pool.
+type NetboxPoolStatusMember struct {
+	// CIDR of the prefix or ip-range, as configured in the spec.
+	CIDR string `json:"cidr"`
+
+	// Vrf of the prefix or ip-range, as configured in the spec.
+	// +optional
+	Vrf string `json:"vrf,omitempty"`
+
+	// Gateway of the prefix or ip-range, either configured in the spec or discovered in Netbox.
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+
+	// Addresses reports the count of total, free, and used IPs of the prefix or ip-range.
+	Addresses NetboxPoolStatusIPAddresses `json:"ipAddresses"`
 }
 
 // +kubebuilder:object:root=true
@@ -107,6 +493,16 @@ type NetboxIPPool struct {
 	Status NetboxIPPoolStatus `json:"status,omitempty"`
 }
 
+// GetConditions returns the set of conditions for this object.
+func (p *NetboxIPPool) GetConditions() clusterv1.Conditions {
+	return p.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (p *NetboxIPPool) SetConditions(conditions clusterv1.Conditions) {
+	p.Status.Conditions = conditions
+}
+
 // +kubebuilder:object:root=true
 
 // NetboxIPPoolList contains a list of NetboxIPPool
@@ -21,10 +21,79 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddressQuarantine) DeepCopyInto(out *AddressQuarantine) {
+	*out = *in
+	out.Period = in.Period
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddressQuarantine.
+func (in *AddressQuarantine) DeepCopy() *AddressQuarantine {
+	if in == nil {
+		return nil
+	}
+	out := new(AddressQuarantine)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddressQuota) DeepCopyInto(out *AddressQuota) {
+	*out = *in
+	if in.PerCluster != nil {
+		in, out := &in.PerCluster, &out.PerCluster
+		*out = new(int)
+		**out = **in
+	}
+	if in.PerNamespace != nil {
+		in, out := &in.PerNamespace, &out.PerNamespace
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddressQuota.
+func (in *AddressQuota) DeepCopy() *AddressQuota {
+	if in == nil {
+		return nil
+	}
+	out := new(AddressQuota)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CapacityThresholds) DeepCopyInto(out *CapacityThresholds) {
+	*out = *in
+	if in.Warning != nil {
+		in, out := &in.Warning, &out.Warning
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.Critical != nil {
+		in, out := &in.Critical, &out.Critical
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CapacityThresholds.
+func (in *CapacityThresholds) DeepCopy() *CapacityThresholds {
+	if in == nil {
+		return nil
+	}
+	out := new(CapacityThresholds)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetboxIPPool) DeepCopyInto(out *NetboxIPPool) {
 	*out = *in
@@ -87,9 +156,49 @@ func (in *NetboxIPPoolList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetboxIPPoolSpec) DeepCopyInto(out *NetboxIPPoolSpec) {
 	*out = *in
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Quarantine != nil {
+		in, out := &in.Quarantine, &out.Quarantine
+		*out = new(AddressQuarantine)
+		**out = **in
+	}
+	if in.Quota != nil {
+		in, out := &in.Quota, &out.Quota
+		*out = new(AddressQuota)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Thresholds != nil {
+		in, out := &in.Thresholds, &out.Thresholds
+		*out = new(CapacityThresholds)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ResyncInterval != nil {
+		in, out := &in.ResyncInterval, &out.ResyncInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.VMInterface != nil {
+		in, out := &in.VMInterface, &out.VMInterface
+		*out = new(VMInterfaceAssignment)
+		**out = **in
+	}
+	if in.DualStack != nil {
+		in, out := &in.DualStack, &out.DualStack
+		*out = new(NetboxPoolReference)
+		**out = **in
+	}
+	if in.Fallback != nil {
+		in, out := &in.Fallback, &out.Fallback
+		*out = make([]NetboxPoolReference, len(*in))
+		copy(*out, *in)
+	}
 	if in.CredentialsRef != nil {
 		in, out := &in.CredentialsRef, &out.CredentialsRef
-		*out = new(v1.SecretReference)
+		*out = new(corev1.SecretReference)
 		**out = **in
 	}
 }
@@ -112,6 +221,43 @@ func (in *NetboxIPPoolStatus) DeepCopyInto(out *NetboxIPPoolStatus) {
 		*out = new(NetboxPoolStatusIPAddresses)
 		**out = **in
 	}
+	if in.DualStackAddresses != nil {
+		in, out := &in.DualStackAddresses, &out.DualStackAddresses
+		*out = new(NetboxPoolStatusIPAddresses)
+		**out = **in
+	}
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]NetboxPoolStatusMember, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(v1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.OutOfRangeAddresses != nil {
+		in, out := &in.OutOfRangeAddresses, &out.OutOfRangeAddresses
+		*out = make([]NetboxPoolStatusOutOfRangeAddress, len(*in))
+		copy(*out, *in)
+	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]NetboxPoolStatusCluster, len(*in))
+		copy(*out, *in)
+	}
+	if in.StickyAddresses != nil {
+		in, out := &in.StickyAddresses, &out.StickyAddresses
+		*out = make([]StickyAddress, len(*in))
+		copy(*out, *in)
+	}
+	if in.Migration != nil {
+		in, out := &in.Migration, &out.Migration
+		*out = new(NetboxPoolStatusMigration)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetboxIPPoolStatus.
@@ -124,6 +270,36 @@ func (in *NetboxIPPoolStatus) DeepCopy() *NetboxIPPoolStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetboxPoolReference) DeepCopyInto(out *NetboxPoolReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetboxPoolReference.
+func (in *NetboxPoolReference) DeepCopy() *NetboxPoolReference {
+	if in == nil {
+		return nil
+	}
+	out := new(NetboxPoolReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetboxPoolStatusCluster) DeepCopyInto(out *NetboxPoolStatusCluster) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetboxPoolStatusCluster.
+func (in *NetboxPoolStatusCluster) DeepCopy() *NetboxPoolStatusCluster {
+	if in == nil {
+		return nil
+	}
+	out := new(NetboxPoolStatusCluster)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetboxPoolStatusIPAddresses) DeepCopyInto(out *NetboxPoolStatusIPAddresses) {
 	*out = *in
@@ -138,3 +314,84 @@ func (in *NetboxPoolStatusIPAddresses) DeepCopy() *NetboxPoolStatusIPAddresses {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetboxPoolStatusMember) DeepCopyInto(out *NetboxPoolStatusMember) {
+	*out = *in
+	out.Addresses = in.Addresses
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetboxPoolStatusMember.
+func (in *NetboxPoolStatusMember) DeepCopy() *NetboxPoolStatusMember {
+	if in == nil {
+		return nil
+	}
+	out := new(NetboxPoolStatusMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetboxPoolStatusMigration) DeepCopyInto(out *NetboxPoolStatusMigration) {
+	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetboxPoolStatusMigration.
+func (in *NetboxPoolStatusMigration) DeepCopy() *NetboxPoolStatusMigration {
+	if in == nil {
+		return nil
+	}
+	out := new(NetboxPoolStatusMigration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetboxPoolStatusOutOfRangeAddress) DeepCopyInto(out *NetboxPoolStatusOutOfRangeAddress) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetboxPoolStatusOutOfRangeAddress.
+func (in *NetboxPoolStatusOutOfRangeAddress) DeepCopy() *NetboxPoolStatusOutOfRangeAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(NetboxPoolStatusOutOfRangeAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StickyAddress) DeepCopyInto(out *StickyAddress) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StickyAddress.
+func (in *StickyAddress) DeepCopy() *StickyAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(StickyAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VMInterfaceAssignment) DeepCopyInto(out *VMInterfaceAssignment) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VMInterfaceAssignment.
+func (in *VMInterfaceAssignment) DeepCopy() *VMInterfaceAssignment {
+	if in == nil {
+		return nil
+	}
+	out := new(VMInterfaceAssignment)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/logger"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+// reconcileAccess checks whether Netbox can be reached with the credentials of the pool, and the token has the
+// permissions the provider needs, and reports the result in the NetboxAccess condition. Once the check succeeded it is
+// not repeated, so a read-only token is reported when the pool is created instead of on the first failed claim.
+func reconcileAccess(ctx context.Context, nb netbox.Client, pool *ipamv1alpha1.NetboxIPPool) {
+	log := logger.FromContext(ctx)
+
+	if conditions.IsTrue(pool, ipamv1alpha1.NetboxAccessCondition) {
+		return
+	}
+
+	missing, err := nb.CheckPermissions(ctx)
+	if err != nil {
+		log.Warn("could not reach Netbox", "error", err.Error())
+		conditions.MarkFalse(pool, ipamv1alpha1.NetboxAccessCondition, ipamv1alpha1.NetboxUnreachableReason,
+			clusterv1.ConditionSeverityError, "%s", err.Error())
+		return
+	}
+	if len(missing) > 0 {
+		log.Warn("token is missing permissions", "missing", missing)
+		conditions.MarkFalse(pool, ipamv1alpha1.NetboxAccessCondition, ipamv1alpha1.MissingPermissionsReason,
+			clusterv1.ConditionSeverityError, "token is missing permissions: %s", strings.Join(missing, ", "))
+		return
+	}
+	conditions.MarkTrue(pool, ipamv1alpha1.NetboxAccessCondition)
+}
+
+// reconcileVersion records the version of Netbox in the status of the pool, and reports whether the provider supports
+// it in the NetboxVersionSupported condition. The returned error wraps netbox.ErrUnsupportedVersion if the version is
+// not supported. If the version could not be determined the condition is Unknown, so that claims are not failed
+// because Netbox was briefly unreachable.
+func reconcileVersion(ctx context.Context, nb netbox.Client, pool *ipamv1alpha1.NetboxIPPool) error {
+	log := logger.FromContext(ctx)
+
+	version, err := nb.Version(ctx)
+	if err != nil && !errors.Is(err, netbox.ErrUnsupportedVersion) {
+		log.Warn("could not get Netbox version", "error", err.Error())
+		conditions.MarkUnknown(pool, ipamv1alpha1.NetboxVersionSupportedCondition, ipamv1alpha1.VersionUnknownReason,
+			"could not get Netbox version: %s", err.Error())
+		return errors.Wrap(err, "could not get Netbox version")
+	}
+	pool.Status.NetboxVersion = ""
+	if version != (netbox.Version{}) {
+		pool.Status.NetboxVersion = version.String()
+	}
+	if err != nil {
+		log.Warn("Netbox version is not supported", "version", pool.Status.NetboxVersion, "error", err.Error())
+		conditions.MarkFalse(pool, ipamv1alpha1.NetboxVersionSupportedCondition, ipamv1alpha1.UnsupportedVersionReason,
+			clusterv1.ConditionSeverityError, "%s", err.Error())
+		return err
+	}
+	conditions.MarkTrue(pool, ipamv1alpha1.NetboxVersionSupportedCondition)
+	return nil
+}
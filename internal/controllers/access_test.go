@@ -0,0 +1,188 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+	nbmock "github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox/mock"
+)
+
+var _ = Describe("reconcileAccess", func() {
+	var (
+		mockCtrl   *gomock.Controller
+		netboxMock *nbmock.MockClient
+		pool       *ipamv1alpha1.NetboxIPPool
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		netboxMock = nbmock.NewMockClient(mockCtrl)
+		pool = &ipamv1alpha1.NetboxIPPool{}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("lists the missing permissions in the condition", func() {
+		netboxMock.EXPECT().CheckPermissions(gomock.Any()).Return([]string{"ipam.add_ipaddress", "write enabled token"}, nil)
+
+		reconcileAccess(ctx, netboxMock, pool)
+		Expect(conditions.IsFalse(pool, ipamv1alpha1.NetboxAccessCondition)).To(BeTrue())
+		Expect(conditions.GetReason(pool, ipamv1alpha1.NetboxAccessCondition)).To(Equal(ipamv1alpha1.MissingPermissionsReason))
+		Expect(conditions.GetMessage(pool, ipamv1alpha1.NetboxAccessCondition)).
+			To(Equal("token is missing permissions: ipam.add_ipaddress, write enabled token"))
+	})
+
+	It("reports an unreachable Netbox", func() {
+		netboxMock.EXPECT().CheckPermissions(gomock.Any()).Return(nil, errors.New("connection refused"))
+
+		reconcileAccess(ctx, netboxMock, pool)
+		Expect(conditions.GetReason(pool, ipamv1alpha1.NetboxAccessCondition)).To(Equal(ipamv1alpha1.NetboxUnreachableReason))
+	})
+
+	It("checks until the check succeeded once", func() {
+		gomock.InOrder(
+			netboxMock.EXPECT().CheckPermissions(gomock.Any()).Return([]string{"ipam.view_vrf"}, nil),
+			netboxMock.EXPECT().CheckPermissions(gomock.Any()).Return(nil, nil),
+		)
+
+		reconcileAccess(ctx, netboxMock, pool)
+		reconcileAccess(ctx, netboxMock, pool)
+		Expect(conditions.IsTrue(pool, ipamv1alpha1.NetboxAccessCondition)).To(BeTrue())
+
+		reconcileAccess(ctx, netboxMock, pool)
+		Expect(conditions.IsTrue(pool, ipamv1alpha1.NetboxAccessCondition)).To(BeTrue())
+	})
+})
+
+var _ = Describe("reconcileVersion", func() {
+	var (
+		mockCtrl   *gomock.Controller
+		netboxMock *nbmock.MockClient
+		pool       *ipamv1alpha1.NetboxIPPool
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		netboxMock = nbmock.NewMockClient(mockCtrl)
+		pool = &ipamv1alpha1.NetboxIPPool{}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("records a supported version", func() {
+		netboxMock.EXPECT().Version(gomock.Any()).Return(netbox.Version{Major: 4, Minor: 1, Patch: 3}, nil)
+
+		Expect(reconcileVersion(ctx, netboxMock, pool)).To(Succeed())
+		Expect(pool.Status.NetboxVersion).To(Equal("4.1.3"))
+		Expect(conditions.IsTrue(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).To(BeTrue())
+	})
+
+	It("reports an unsupported version in the condition", func() {
+		netboxMock.EXPECT().Version(gomock.Any()).Return(netbox.Version{Major: 2, Minor: 11},
+			fmt.Errorf("%w 2.11.0", netbox.ErrUnsupportedVersion))
+
+		err := reconcileVersion(ctx, netboxMock, pool)
+		Expect(errors.Is(err, netbox.ErrUnsupportedVersion)).To(BeTrue())
+		Expect(pool.Status.NetboxVersion).To(Equal("2.11.0"))
+		Expect(conditions.GetReason(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).
+			To(Equal(ipamv1alpha1.UnsupportedVersionReason))
+		Expect(conditions.GetMessage(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).
+			To(Equal("unsupported Netbox version 2.11.0"))
+	})
+
+	It("reports a version that can not be parsed in the condition", func() {
+		pool.Status.NetboxVersion = "4.1.3"
+		netboxMock.EXPECT().Version(gomock.Any()).Return(netbox.Version{},
+			fmt.Errorf("%w 'latest', it can not be parsed", netbox.ErrUnsupportedVersion))
+
+		err := reconcileVersion(ctx, netboxMock, pool)
+		Expect(errors.Is(err, netbox.ErrUnsupportedVersion)).To(BeTrue())
+		Expect(pool.Status.NetboxVersion).To(BeEmpty())
+		Expect(conditions.IsFalse(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).To(BeTrue())
+		Expect(conditions.GetReason(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).
+			To(Equal(ipamv1alpha1.UnsupportedVersionReason))
+	})
+
+	It("marks the condition unknown when the version can not be determined", func() {
+		netboxMock.EXPECT().Version(gomock.Any()).Return(netbox.Version{}, errors.New("connection refused"))
+
+		Expect(reconcileVersion(ctx, netboxMock, pool)).To(MatchError(ContainSubstring("connection refused")))
+		Expect(conditions.IsUnknown(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).To(BeTrue())
+		Expect(conditions.GetReason(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).
+			To(Equal(ipamv1alpha1.VersionUnknownReason))
+		Expect(conditions.GetMessage(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).
+			To(Equal("could not get Netbox version: connection refused"))
+	})
+})
+
+var _ = Describe("reconciling a pool of an unreachable Netbox", func() {
+	It("reports the unreachable Netbox in the conditions", func() {
+		mockCtrl := gomock.NewController(GinkgoT())
+		netboxMock := nbmock.NewMockClient(mockCtrl)
+		netboxMock.EXPECT().CheckPermissions(gomock.Any()).Return(nil, errors.New("connection refused"))
+		netboxMock.EXPECT().Version(gomock.Any()).Return(netbox.Version{}, errors.New("connection refused"))
+
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1alpha1.AddToScheme(scheme)).To(Succeed())
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "netbox-credentials", Namespace: "default"},
+			Data: map[string][]byte{
+				UrlKey:      []byte("http://netbox.local"),
+				ApiTokenKey: []byte("token"),
+			},
+		}
+		pool := &ipamv1alpha1.NetboxIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: "default"},
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{
+				Type:           ipamv1alpha1.PrefixType,
+				CIDR:           "10.0.0.0/24",
+				CredentialsRef: &corev1.SecretReference{Name: secret.Name},
+			},
+		}
+		reconciler := &NetboxIPPoolReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret, pool).Build(),
+			NetboxServiceFactory: func(_, _, _ string) (netbox.Client, error) {
+				return netboxMock, nil
+			},
+		}
+
+		_, err := reconciler.reconcileNormal(ctx, pool, nil)
+		Expect(err).To(MatchError(ContainSubstring("connection refused")))
+		Expect(conditions.IsFalse(pool, ipamv1alpha1.NetboxAccessCondition)).To(BeTrue())
+		Expect(conditions.GetReason(pool, ipamv1alpha1.NetboxAccessCondition)).To(Equal(ipamv1alpha1.NetboxUnreachableReason))
+		Expect(conditions.IsUnknown(pool, ipamv1alpha1.NetboxVersionSupportedCondition)).To(BeTrue())
+	})
+})
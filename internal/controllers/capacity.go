@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+)
+
+// capacityLevel is how low the capacity of a pool is, compared to its thresholds.
+type capacityLevel string
+
+const (
+	capacityOk       capacityLevel = "ok"
+	capacityWarning  capacityLevel = "warning"
+	capacityCritical capacityLevel = "critical"
+)
+
+var capacityLevels = []capacityLevel{capacityOk, capacityWarning, capacityCritical}
+
+// poolCapacity reports the capacity level of the pools with thresholds. Only the series of the current level of a
+// pool is 1, so an alert can select pools by level, like netbox_ipam_pool_capacity_level{level="critical"} == 1.
+var poolCapacity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "netbox_ipam_pool_capacity_level",
+	Help: "Capacity level of a NetboxIPPool with thresholds: 1 for the current level (ok, warning or critical), " +
+		"0 for the other levels.",
+}, []string{"namespace", "name", "level"})
+
+func init() {
+	metrics.Registry.MustRegister(poolCapacity)
+}
+
+// reconcileCapacity checks the free IPs in the status of the pool against its thresholds. It sets the CapacityLow
+// condition and the capacity metric of the pool, and emits a Warning event when the capacity gets lower.
+func (r *NetboxIPPoolReconciler) reconcileCapacity(pool *ipamv1alpha1.NetboxIPPool) error {
+	if pool.Spec.Thresholds == nil {
+		conditions.Delete(pool, ipamv1alpha1.CapacityLowCondition)
+		deleteCapacityMetric(pool)
+		return nil
+	}
+
+	level, message, err := evaluateCapacity(pool)
+	if err != nil {
+		return err
+	}
+
+	reason := ipamv1alpha1.CapacityWarningReason
+	if level == capacityCritical {
+		reason = ipamv1alpha1.CapacityCriticalReason
+	}
+	if level == capacityOk {
+		conditions.MarkFalse(pool, ipamv1alpha1.CapacityLowCondition, ipamv1alpha1.CapacitySufficientReason,
+			clusterv1.ConditionSeverityNone, "%s", message)
+	} else {
+		if conditions.GetReason(pool, ipamv1alpha1.CapacityLowCondition) != reason {
+			r.Recorder.Event(pool, corev1.EventTypeWarning, reason, message)
+		}
+		conditions.Set(pool, &clusterv1.Condition{
+			Type:    ipamv1alpha1.CapacityLowCondition,
+			Status:  corev1.ConditionTrue,
+			Reason:  reason,
+			Message: message,
+		})
+	}
+
+	for _, l := range capacityLevels {
+		value := 0.0
+		if l == level {
+			value = 1
+		}
+		poolCapacity.WithLabelValues(pool.Namespace, pool.Name, string(l)).Set(value)
+	}
+	return nil
+}
+
+// evaluateCapacity returns the capacity level of the pool, together with a message describing it. With DualStack,
+// the lowest level of both address families is returned.
+func evaluateCapacity(pool *ipamv1alpha1.NetboxIPPool) (capacityLevel, string, error) {
+	level, message := capacityOk, ""
+	for _, addresses := range []*ipamv1alpha1.NetboxPoolStatusIPAddresses{pool.Status.Addresses, pool.Status.DualStackAddresses} {
+		if addresses == nil {
+			continue
+		}
+		critical, err := thresholdReached(pool.Spec.Thresholds.Critical, addresses)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid critical threshold: %w", err)
+		}
+		warning, err := thresholdReached(pool.Spec.Thresholds.Warning, addresses)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid warning threshold: %w", err)
+		}
+		switch {
+		case critical:
+			return capacityCritical, fmt.Sprintf("%d of %d IPs are free, the critical threshold is %s",
+				addresses.Free, addresses.Total, pool.Spec.Thresholds.Critical), nil
+		case warning:
+			level, message = capacityWarning, fmt.Sprintf("%d of %d IPs are free, the warning threshold is %s",
+				addresses.Free, addresses.Total, pool.Spec.Thresholds.Warning)
+		case level == capacityOk && message == "":
+			message = fmt.Sprintf("%d of %d IPs are free", addresses.Free, addresses.Total)
+		}
+	}
+	return level, message, nil
+}
+
+// thresholdReached returns whether the free IPs are at or below the threshold. A percentage is relative to the total
+// IPs, rounded up.
+func thresholdReached(threshold *intstr.IntOrString, addresses *ipamv1alpha1.NetboxPoolStatusIPAddresses) (bool, error) {
+	if threshold == nil {
+		return false, nil
+	}
+	limit, err := intstr.GetScaledValueFromIntOrPercent(threshold, addresses.Total, true)
+	if err != nil {
+		return false, err
+	}
+	return addresses.Free <= limit, nil
+}
+
+// deleteCapacityMetric removes the capacity metric of the pool.
+func deleteCapacityMetric(pool *ipamv1alpha1.NetboxIPPool) {
+	poolCapacity.DeletePartialMatch(prometheus.Labels{"namespace": pool.Namespace, "name": pool.Name})
+}
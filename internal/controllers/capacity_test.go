@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+)
+
+var _ = Describe("pool capacity", func() {
+	var (
+		pool       *ipamv1alpha1.NetboxIPPool
+		recorder   *record.FakeRecorder
+		reconciler *NetboxIPPoolReconciler
+	)
+
+	BeforeEach(func() {
+		pool = &ipamv1alpha1.NetboxIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "capacity-pool", Namespace: "test-namespace"},
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{
+				Thresholds: &ipamv1alpha1.CapacityThresholds{
+					Warning:  ptr.To(intstr.FromString("20%")),
+					Critical: ptr.To(intstr.FromInt32(5)),
+				},
+			},
+			Status: ipamv1alpha1.NetboxIPPoolStatus{
+				Addresses: &ipamv1alpha1.NetboxPoolStatusIPAddresses{Total: 100, Free: 50},
+			},
+		}
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &NetboxIPPoolReconciler{Recorder: recorder}
+	})
+
+	AfterEach(func() {
+		deleteCapacityMetric(pool)
+	})
+
+	level := func(l capacityLevel) float64 {
+		return testutil.ToFloat64(poolCapacity.WithLabelValues(pool.Namespace, pool.Name, string(l)))
+	}
+
+	DescribeTable("evaluates the free IPs against the thresholds",
+		func(free int, dualStackFree int, expected capacityLevel) {
+			pool.Status.Addresses.Free = free
+			if dualStackFree >= 0 {
+				pool.Status.DualStackAddresses = &ipamv1alpha1.NetboxPoolStatusIPAddresses{Total: 100, Free: dualStackFree}
+			}
+			capacity, _, err := evaluateCapacity(pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(capacity).To(Equal(expected))
+		},
+		Entry("above the thresholds", 21, -1, capacityOk),
+		Entry("at the warning percentage", 20, -1, capacityWarning),
+		Entry("at the critical count", 5, -1, capacityCritical),
+		Entry("dual-stack family at the warning percentage", 50, 10, capacityWarning),
+		Entry("dual-stack family at the critical count", 10, 0, capacityCritical),
+	)
+
+	It("marks sufficient capacity", func() {
+		Expect(reconciler.reconcileCapacity(pool)).To(Succeed())
+		Expect(conditions.IsFalse(pool, ipamv1alpha1.CapacityLowCondition)).To(BeTrue())
+		Expect(conditions.GetReason(pool, ipamv1alpha1.CapacityLowCondition)).To(Equal(ipamv1alpha1.CapacitySufficientReason))
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(level(capacityOk)).To(Equal(1.0))
+		Expect(level(capacityCritical)).To(Equal(0.0))
+	})
+
+	It("marks low capacity and emits a warning event once", func() {
+		pool.Status.Addresses.Free = 3
+		Expect(reconciler.reconcileCapacity(pool)).To(Succeed())
+		Expect(reconciler.reconcileCapacity(pool)).To(Succeed())
+
+		Expect(conditions.IsTrue(pool, ipamv1alpha1.CapacityLowCondition)).To(BeTrue())
+		Expect(conditions.GetReason(pool, ipamv1alpha1.CapacityLowCondition)).To(Equal(ipamv1alpha1.CapacityCriticalReason))
+		Expect(conditions.GetMessage(pool, ipamv1alpha1.CapacityLowCondition)).
+			To(Equal("3 of 100 IPs are free, the critical threshold is 5"))
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(Equal(corev1.EventTypeWarning + " " + ipamv1alpha1.CapacityCriticalReason +
+			" 3 of 100 IPs are free, the critical threshold is 5"))
+		Expect(level(capacityCritical)).To(Equal(1.0))
+		Expect(level(capacityOk)).To(Equal(0.0))
+	})
+
+	It("removes the condition without thresholds", func() {
+		conditions.MarkFalse(pool, ipamv1alpha1.CapacityLowCondition, ipamv1alpha1.CapacitySufficientReason,
+			clusterv1.ConditionSeverityNone, "")
+		pool.Spec.Thresholds = nil
+		Expect(reconciler.reconcileCapacity(pool)).To(Succeed())
+		Expect(conditions.Has(pool, ipamv1alpha1.CapacityLowCondition)).To(BeFalse())
+	})
+})
@@ -0,0 +1,491 @@
+package controller
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/logger"
+	poolutil "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/pool"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+const (
+	InventoryFinalizer = "netbox.ipam.cluster.x-k8s.io/inventory"
+
+	// DefaultInventoryClusterType is the slug of the Netbox cluster type of the clusters created by the inventory sync.
+	DefaultInventoryClusterType = "cluster-api"
+
+	// DefaultInventoryTag is the slug of the Netbox tag of the virtual machines created by the inventory sync.
+	DefaultInventoryTag = "cluster-api"
+)
+
+// InventoryReconciler syncs a Cluster and its Machines into Netbox, as a virtualization cluster with its virtual
+// machines. The Netbox cluster is named <namespace>/<name> after the Cluster, so that Clusters of the same name in
+// different namespaces do not share it. The addresses allocated to the Machines by NetboxIPPools are assigned to the
+// interfaces of the virtual machines, and the first address of each family becomes the primary address. Netbox is
+// accessed with the credentials of the NetboxIPPool recorded in the InventoryPoolAnnotation of the Cluster.
+//
+// The virtual machines created by the sync are tagged, and only those are decommissioned when their Machine is gone.
+// Virtual machines that were added to the Netbox cluster otherwise are left alone.
+type InventoryReconciler struct {
+	client.Client
+	Scheme               *runtime.Scheme
+	NetboxServiceFactory func(url, apiToken, apiVersion string) (netbox.Client, error)
+	// ClusterType is the slug of the Netbox cluster type of created clusters. It is created if it does not exist.
+	ClusterType string
+	// Tag is the slug of the Netbox tag of created virtual machines. It is created if it does not exist.
+	Tag string
+}
+
+func (r *InventoryReconciler) SetupWithManager(mgr manager.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.Cluster{}).
+		Watches(&clusterv1.Machine{}, handler.EnqueueRequestsFromMapFunc(objectToCluster)).
+		Watches(&ipamv1.IPAddress{}, handler.EnqueueRequestsFromMapFunc(objectToCluster)).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=get;list;watch
+
+// Reconcile syncs the Cluster into Netbox.
+func (r *InventoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, reterr error) {
+	log := logger.FromContext(ctx)
+	log.Info("Reconciling Cluster inventory")
+
+	cluster := &clusterv1.Cluster{}
+	if err := r.Client.Get(ctx, req.NamespacedName, cluster); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, errors.Wrap(err, "could not fetch Cluster")
+		}
+		return ctrl.Result{}, nil
+	}
+
+	patchHelper, err := patch.NewHelper(cluster, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	defer func() {
+		if err := patchHelper.Patch(ctx, cluster); err != nil {
+			reterr = kerrors.NewAggregate([]error{reterr, err})
+		}
+	}()
+
+	pool, err := r.inventoryPool(ctx, cluster)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pool == nil {
+		// The Cluster has no addresses of a NetboxIPPool (yet), so there is no Netbox to sync it to.
+		ctrlutil.RemoveFinalizer(cluster, InventoryFinalizer)
+		return ctrl.Result{}, nil
+	}
+
+	secret, err := getSecretForPool(ctx, r.Client, pool)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "could not get secret")
+	}
+	nb, err := getNetboxClient(secret, r.NetboxServiceFactory)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "could not create Netbox client")
+	}
+
+	// Handle deleted clusters
+	if !cluster.GetDeletionTimestamp().IsZero() {
+		return r.reconcileDelete(ctx, nb, cluster)
+	}
+
+	// If the Cluster doesn't have our finalizer, add it.
+	// Requeue immediately after adding finalizer to avoid the race condition between init and delete
+	if !ctrlutil.ContainsFinalizer(cluster, InventoryFinalizer) {
+		ctrlutil.AddFinalizer(cluster, InventoryFinalizer)
+		return reconcile.Result{}, nil
+	}
+
+	// Handle non-deleted clusters
+	return r.reconcileNormal(ctx, nb, cluster)
+}
+
+func (r *InventoryReconciler) reconcileDelete(ctx context.Context, nb netbox.Client, cluster *clusterv1.Cluster) (reconcile.Result, error) {
+	log := logger.FromContext(ctx)
+
+	nbCluster, err := nb.FindCluster(ctx, inventoryClusterName(cluster))
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if nbCluster != nil {
+		virtualMachines, err := nb.GetVirtualMachines(ctx, nbCluster.Id)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		for i := range virtualMachines {
+			if !virtualMachines[i].HasTag(r.tag()) {
+				continue
+			}
+			if err := decommissionVirtualMachine(ctx, nb, &virtualMachines[i]); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		if nbCluster.Status == nil || nbCluster.Status.Value != netbox.DecommissioningStatus {
+			log.Info("Decommissioning cluster in Netbox", "cluster", nbCluster.Display)
+			if _, err := nb.UpdateClusterStatus(ctx, nbCluster.Id, netbox.DecommissioningStatus); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+	}
+
+	// Cluster is decommissioned so remove the finalizer.
+	ctrlutil.RemoveFinalizer(cluster, InventoryFinalizer)
+
+	return reconcile.Result{}, nil
+}
+
+func (r *InventoryReconciler) reconcileNormal(ctx context.Context, nb netbox.Client, cluster *clusterv1.Cluster) (reconcile.Result, error) {
+	nbCluster, err := r.ensureCluster(ctx, nb, inventoryClusterName(cluster))
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	tag, err := r.ensureTag(ctx, nb)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	machines := &clusterv1.MachineList{}
+	if err := r.Client.List(ctx, machines,
+		client.InNamespace(cluster.Namespace),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: cluster.Name},
+	); err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "failed to list machines")
+	}
+	addresses, err := r.machineAddresses(ctx, cluster)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	pools := map[string]*ipamv1alpha1.NetboxIPPool{}
+	synced := map[string]bool{}
+	var errs []error
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		if err := r.reconcileMachine(ctx, nb, nbCluster, tag, machine, addresses[machine.Name], pools); err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to sync machine %s", machine.Name))
+		}
+		synced[machine.Name] = true
+	}
+
+	// Virtual machines created for Machines that no longer exist are decommissioned.
+	virtualMachines, err := nb.GetVirtualMachines(ctx, nbCluster.Id)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	for i := range virtualMachines {
+		if !synced[virtualMachines[i].Name] && virtualMachines[i].HasTag(tag.Slug) {
+			if err := decommissionVirtualMachine(ctx, nb, &virtualMachines[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return reconcile.Result{}, kerrors.NewAggregate(errs)
+}
+
+// reconcileMachine syncs the virtual machine of the Machine in the Netbox cluster, and assigns the addresses of the
+// Machine to its interfaces. The virtual machine is created with the tag if the Netbox cluster has none of the name
+// of the Machine, virtual machines of other clusters are not taken over.
+func (r *InventoryReconciler) reconcileMachine(ctx context.Context, nb netbox.Client, nbCluster *netbox.Cluster, tag *netbox.Tag,
+	machine *clusterv1.Machine, addresses []ipamv1.IPAddress, pools map[string]*ipamv1alpha1.NetboxIPPool,
+) error {
+	status := netbox.ActiveStatus
+	if !machine.GetDeletionTimestamp().IsZero() {
+		status = netbox.DecommissioningStatus
+	}
+
+	virtualMachine, err := nb.FindClusterVirtualMachine(ctx, nbCluster.Id, machine.Name)
+	if err != nil {
+		return err
+	}
+	if virtualMachine == nil {
+		virtualMachine, err = nb.CreateVirtualMachine(ctx, &netbox.VirtualMachineRequest{
+			Name:    machine.Name,
+			Status:  status,
+			Cluster: &netbox.Cluster{Id: nbCluster.Id},
+			Tags:    []netbox.Tag{{Id: tag.Id}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	vmPatch := netbox.VirtualMachinePatch{}
+	if virtualMachine.Status == nil || virtualMachine.Status.Value != status {
+		vmPatch.Status = status
+	}
+
+	var primaryIp4, primaryIp6 *netbox.IPAddress
+	for i := range addresses {
+		pool, err := r.getPool(ctx, addresses[i], pools)
+		if err != nil {
+			return err
+		}
+		ipAddress, err := assignToVirtualMachine(ctx, nb, virtualMachine, pool, &addresses[i])
+		if err != nil {
+			return err
+		}
+		if ipAddress == nil {
+			continue
+		}
+		switch ipaddr.NewIPAddressString(addresses[i].Spec.Address).GetIPVersion() {
+		case ipaddr.IPv4:
+			if primaryIp4 == nil {
+				primaryIp4 = ipAddress
+			}
+		case ipaddr.IPv6:
+			if primaryIp6 == nil {
+				primaryIp6 = ipAddress
+			}
+		}
+	}
+	if primaryIp4 != nil && (virtualMachine.PrimaryIp4 == nil || virtualMachine.PrimaryIp4.Id != primaryIp4.Id) {
+		vmPatch.PrimaryIp4 = &primaryIp4.Id
+	}
+	if primaryIp6 != nil && (virtualMachine.PrimaryIp6 == nil || virtualMachine.PrimaryIp6.Id != primaryIp6.Id) {
+		vmPatch.PrimaryIp6 = &primaryIp6.Id
+	}
+
+	if vmPatch != (netbox.VirtualMachinePatch{}) {
+		if _, err := nb.UpdateVirtualMachine(ctx, virtualMachine.Id, &vmPatch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureCluster returns the Netbox cluster with the given name, creating it and its cluster type if they do not
+// exist.
+func (r *InventoryReconciler) ensureCluster(ctx context.Context, nb netbox.Client, name string) (*netbox.Cluster, error) {
+	nbCluster, err := nb.FindCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if nbCluster != nil {
+		if nbCluster.Status != nil && nbCluster.Status.Value != netbox.ActiveStatus {
+			return nb.UpdateClusterStatus(ctx, nbCluster.Id, netbox.ActiveStatus)
+		}
+		return nbCluster, nil
+	}
+
+	slug := r.ClusterType
+	if slug == "" {
+		slug = DefaultInventoryClusterType
+	}
+	clusterType, err := nb.FindClusterType(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if clusterType == nil {
+		if clusterType, err = nb.CreateClusterType(ctx, slug, slug); err != nil {
+			return nil, err
+		}
+	}
+	return nb.CreateCluster(ctx, &netbox.ClusterRequest{
+		Name:   name,
+		Type:   &netbox.ClusterType{Id: clusterType.Id},
+		Status: netbox.ActiveStatus,
+	})
+}
+
+// ensureTag returns the Netbox tag of the virtual machines created by the sync, creating it if it does not exist.
+func (r *InventoryReconciler) ensureTag(ctx context.Context, nb netbox.Client) (*netbox.Tag, error) {
+	tag, err := nb.FindTag(ctx, r.tag())
+	if err != nil {
+		return nil, err
+	}
+	if tag != nil {
+		return tag, nil
+	}
+	return nb.CreateTag(ctx, r.tag(), r.tag())
+}
+
+func (r *InventoryReconciler) tag() string {
+	if r.Tag == "" {
+		return DefaultInventoryTag
+	}
+	return r.Tag
+}
+
+// inventoryClusterName returns the name of the Netbox cluster of the Cluster.
+func inventoryClusterName(cluster *clusterv1.Cluster) string {
+	return cluster.Namespace + "/" + cluster.Name
+}
+
+// inventoryPool returns the NetboxIPPool whose credentials are used to sync the Cluster, and records it in the
+// InventoryPoolAnnotation of the Cluster. If the Cluster has no addresses of a NetboxIPPool, nil is returned.
+func (r *InventoryReconciler) inventoryPool(ctx context.Context, cluster *clusterv1.Cluster) (*ipamv1alpha1.NetboxIPPool, error) {
+	log := logger.FromContext(ctx)
+
+	name := cluster.GetAnnotations()[ipamv1alpha1.InventoryPoolAnnotation]
+	if name == "" {
+		addresses, err := r.clusterAddresses(ctx, cluster)
+		if err != nil {
+			return nil, err
+		}
+		if len(addresses) == 0 {
+			return nil, nil
+		}
+		name = addresses[0].Spec.PoolRef.Name
+		if cluster.Annotations == nil {
+			cluster.Annotations = map[string]string{}
+		}
+		cluster.Annotations[ipamv1alpha1.InventoryPoolAnnotation] = name
+	}
+
+	pool := &ipamv1alpha1.NetboxIPPool{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, pool); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to fetch pool")
+		}
+		log.Info("pool of the inventory does not exist, Cluster is not synced", "Pool", klog.KRef(cluster.Namespace, name))
+		return nil, nil
+	}
+	return pool, nil
+}
+
+// clusterAddresses returns the addresses of the Cluster allocated by NetboxIPPools, ordered by name.
+func (r *InventoryReconciler) clusterAddresses(ctx context.Context, cluster *clusterv1.Cluster) ([]ipamv1.IPAddress, error) {
+	addressList := &ipamv1.IPAddressList{}
+	if err := r.Client.List(ctx, addressList,
+		client.InNamespace(cluster.Namespace),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: cluster.Name},
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to list addresses")
+	}
+	var addresses []ipamv1.IPAddress
+	for _, a := range addressList.Items {
+		if referencesNetboxIPPool(&a) && a.Spec.Address != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].Name < addresses[j].Name
+	})
+	return addresses, nil
+}
+
+// machineAddresses returns the addresses of the Cluster allocated by NetboxIPPools, by the name of the Machine owning
+// their claim.
+func (r *InventoryReconciler) machineAddresses(ctx context.Context, cluster *clusterv1.Cluster) (map[string][]ipamv1.IPAddress, error) {
+	addresses, err := r.clusterAddresses(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	machineAddresses := map[string][]ipamv1.IPAddress{}
+	for _, a := range addresses {
+		claim := &ipamv1.IPAddressClaim{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: a.Namespace, Name: a.Spec.ClaimRef.Name}, claim); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to fetch claim")
+		}
+		if machine := claimMachine(claim); machine != nil {
+			machineAddresses[machine.Name] = append(machineAddresses[machine.Name], a)
+		}
+	}
+	return machineAddresses, nil
+}
+
+func (r *InventoryReconciler) getPool(ctx context.Context, address ipamv1.IPAddress, pools map[string]*ipamv1alpha1.NetboxIPPool) (*ipamv1alpha1.NetboxIPPool, error) {
+	if pool, ok := pools[address.Spec.PoolRef.Name]; ok {
+		return pool, nil
+	}
+	pool := &ipamv1alpha1.NetboxIPPool{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: address.Namespace, Name: address.Spec.PoolRef.Name}, pool); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch pool")
+	}
+	pools[address.Spec.PoolRef.Name] = pool
+	return pool, nil
+}
+
+// assignToVirtualMachine assigns the address to the interface of the virtual machine, creating the interface if it
+// does not exist. The interface is the one configured in the VMInterface of the pool. Child prefixes are not assigned,
+// in which case nil is returned.
+func assignToVirtualMachine(ctx context.Context, nb netbox.Client, virtualMachine *netbox.VirtualMachine, pool *ipamv1alpha1.NetboxIPPool,
+	address *ipamv1.IPAddress,
+) (*netbox.IPAddress, error) {
+	if pool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+		return nil, nil
+	}
+	familyPool, err := poolutil.ForFamily(pool, ipaddr.NewIPAddressString(address.Spec.Address).GetIPVersion())
+	if err != nil {
+		return nil, err
+	}
+	member := poolMemberFor(familyPool, address.Spec.Address)
+	ipAddress, err := getExistingIPAddress(ctx, nb, address.Spec.Address, member.Spec.Vrf)
+	if err != nil {
+		return nil, err
+	}
+
+	name := ipamv1alpha1.DefaultVMInterface
+	if pool.Spec.VMInterface != nil && pool.Spec.VMInterface.Interface != "" {
+		name = pool.Spec.VMInterface.Interface
+	}
+	vmInterface, err := nb.FindVMInterface(ctx, virtualMachine.Id, name)
+	if err != nil {
+		return nil, err
+	}
+	if vmInterface == nil {
+		if vmInterface, err = nb.CreateVMInterface(ctx, virtualMachine.Id, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if !assignedToVMInterface(ipAddress, vmInterface.Id) {
+		if _, err := nb.UpdateIPAddressAssignment(ctx, ipAddress.Id, vmInterface.Id); err != nil {
+			return nil, err
+		}
+	}
+	return ipAddress, nil
+}
+
+func decommissionVirtualMachine(ctx context.Context, nb netbox.Client, virtualMachine *netbox.VirtualMachine) error {
+	log := logger.FromContext(ctx)
+
+	if virtualMachine.Status != nil && virtualMachine.Status.Value == netbox.DecommissioningStatus {
+		return nil
+	}
+	log.Info("Decommissioning virtual machine in Netbox", "virtualMachine", virtualMachine.Display)
+	_, err := nb.UpdateVirtualMachine(ctx, virtualMachine.Id, &netbox.VirtualMachinePatch{Status: netbox.DecommissioningStatus})
+	return err
+}
+
+func objectToCluster(_ context.Context, obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[clusterv1.ClusterNameLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{
+			Namespace: obj.GetNamespace(),
+			Name:      name,
+		},
+	}}
+}
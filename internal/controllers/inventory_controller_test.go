@@ -0,0 +1,228 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+	nbmock "github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox/mock"
+)
+
+var _ = Describe("InventoryReconciler", func() {
+	const namespace = "test-namespace"
+
+	var (
+		mockCtrl   *gomock.Controller
+		netboxMock *nbmock.MockClient
+		pool       *ipamv1alpha1.NetboxIPPool
+		cluster    *clusterv1.Cluster
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		netboxMock = nbmock.NewMockClient(mockCtrl)
+		pool = &ipamv1alpha1.NetboxIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Namespace: namespace},
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{
+				Type:           ipamv1alpha1.PrefixType,
+				CIDR:           "10.0.0.0/24",
+				CredentialsRef: &corev1.SecretReference{Name: "netbox-credentials"},
+			},
+		}
+		cluster = &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: namespace},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	newReconciler := func(objs ...client.Object) *InventoryReconciler {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "netbox-credentials", Namespace: namespace},
+			Data: map[string][]byte{
+				UrlKey:      []byte("http://netbox.local"),
+				ApiTokenKey: []byte("token"),
+			},
+		}
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(append(objs, secret, pool)...).
+			Build()
+
+		return &InventoryReconciler{
+			Client: fakeClient,
+			Scheme: scheme,
+			NetboxServiceFactory: func(_, _, _ string) (netbox.Client, error) {
+				return netboxMock, nil
+			},
+		}
+	}
+
+	request := ctrl.Request{NamespacedName: client.ObjectKey{Namespace: namespace, Name: "cluster"}}
+
+	It("syncs the cluster and its machines into Netbox", func() {
+		machine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "machine-0",
+				Namespace: namespace,
+				Labels:    map[string]string{clusterv1.ClusterNameLabel: "cluster"},
+			},
+		}
+		claim := newTestClaim("machine-0-eth0", namespace, pool.Name)
+		claim.OwnerReferences = []metav1.OwnerReference{
+			{APIVersion: clusterv1.GroupVersion.String(), Kind: "Machine", Name: "machine-0"},
+		}
+		address := newTestIPAddress("machine-0-eth0", "10.0.0.2", pool)
+		address.Labels = map[string]string{clusterv1.ClusterNameLabel: "cluster"}
+		r := newReconciler(cluster, machine, claim, address)
+
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Client.Get(ctx, request.NamespacedName, cluster)).To(Succeed())
+		Expect(cluster.Finalizers).To(ContainElement(InventoryFinalizer))
+		Expect(cluster.Annotations).To(HaveKeyWithValue(ipamv1alpha1.InventoryPoolAnnotation, pool.Name))
+
+		netboxMock.EXPECT().FindCluster(gomock.Any(), "test-namespace/cluster").Return(nil, nil)
+		netboxMock.EXPECT().FindClusterType(gomock.Any(), DefaultInventoryClusterType).Return(nil, nil)
+		netboxMock.EXPECT().CreateClusterType(gomock.Any(), DefaultInventoryClusterType, DefaultInventoryClusterType).
+			Return(&netbox.ClusterType{Id: 1, Slug: DefaultInventoryClusterType}, nil)
+		netboxMock.EXPECT().CreateCluster(gomock.Any(), &netbox.ClusterRequest{
+			Name:   "test-namespace/cluster",
+			Type:   &netbox.ClusterType{Id: 1},
+			Status: netbox.ActiveStatus,
+		}).Return(&netbox.Cluster{Id: 2, Name: "test-namespace/cluster"}, nil)
+		netboxMock.EXPECT().FindTag(gomock.Any(), DefaultInventoryTag).Return(nil, nil)
+		netboxMock.EXPECT().CreateTag(gomock.Any(), DefaultInventoryTag, DefaultInventoryTag).
+			Return(&netbox.Tag{Id: 7, Slug: DefaultInventoryTag}, nil)
+		netboxMock.EXPECT().FindClusterVirtualMachine(gomock.Any(), 2, "machine-0").Return(nil, nil)
+		netboxMock.EXPECT().CreateVirtualMachine(gomock.Any(), &netbox.VirtualMachineRequest{
+			Name:    "machine-0",
+			Status:  netbox.ActiveStatus,
+			Cluster: &netbox.Cluster{Id: 2},
+			Tags:    []netbox.Tag{{Id: 7}},
+		}).Return(&netbox.VirtualMachine{
+			Id:      3,
+			Name:    "machine-0",
+			Status:  &netbox.Status{Value: netbox.ActiveStatus},
+			Cluster: &netbox.Cluster{Id: 2},
+		}, nil)
+		netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").
+			Return(&netbox.IPAddress{Id: 5, Address: "10.0.0.2/24"}, nil)
+		netboxMock.EXPECT().FindVMInterface(gomock.Any(), 3, ipamv1alpha1.DefaultVMInterface).Return(nil, nil)
+		netboxMock.EXPECT().CreateVMInterface(gomock.Any(), 3, ipamv1alpha1.DefaultVMInterface).
+			Return(&netbox.VMInterface{Id: 4, Name: ipamv1alpha1.DefaultVMInterface}, nil)
+		netboxMock.EXPECT().UpdateIPAddressAssignment(gomock.Any(), 5, 4).Return(&netbox.IPAddress{Id: 5}, nil)
+		netboxMock.EXPECT().UpdateVirtualMachine(gomock.Any(), 3, &netbox.VirtualMachinePatch{PrimaryIp4: ptr.To(5)}).
+			Return(&netbox.VirtualMachine{Id: 3}, nil)
+		netboxMock.EXPECT().GetVirtualMachines(gomock.Any(), 2).Return([]netbox.VirtualMachine{
+			{Id: 3, Name: "machine-0", Status: &netbox.Status{Value: netbox.ActiveStatus}, Tags: []netbox.Tag{{Id: 7, Slug: DefaultInventoryTag}}},
+			{Id: 6, Name: "machine-deleted", Status: &netbox.Status{Value: netbox.ActiveStatus}, Tags: []netbox.Tag{{Id: 7, Slug: DefaultInventoryTag}}},
+			{Id: 8, Name: "hand-made", Status: &netbox.Status{Value: netbox.ActiveStatus}},
+		}, nil)
+		netboxMock.EXPECT().UpdateVirtualMachine(gomock.Any(), 6, &netbox.VirtualMachinePatch{Status: netbox.DecommissioningStatus}).
+			Return(&netbox.VirtualMachine{Id: 6}, nil)
+
+		_, err = r.Reconcile(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("decommissions the cluster in Netbox when it is deleted", func() {
+		cluster.Finalizers = []string{InventoryFinalizer}
+		cluster.Annotations = map[string]string{ipamv1alpha1.InventoryPoolAnnotation: pool.Name}
+		cluster.DeletionTimestamp = ptr.To(metav1.Now())
+		r := newReconciler(cluster)
+
+		netboxMock.EXPECT().FindCluster(gomock.Any(), "test-namespace/cluster").
+			Return(&netbox.Cluster{Id: 2, Name: "test-namespace/cluster", Status: &netbox.Status{Value: netbox.ActiveStatus}}, nil)
+		netboxMock.EXPECT().GetVirtualMachines(gomock.Any(), 2).Return([]netbox.VirtualMachine{
+			{Id: 3, Name: "machine-0", Status: &netbox.Status{Value: netbox.ActiveStatus}, Tags: []netbox.Tag{{Id: 7, Slug: DefaultInventoryTag}}},
+			{Id: 8, Name: "hand-made", Status: &netbox.Status{Value: netbox.ActiveStatus}},
+		}, nil)
+		netboxMock.EXPECT().UpdateVirtualMachine(gomock.Any(), 3, &netbox.VirtualMachinePatch{Status: netbox.DecommissioningStatus}).
+			Return(&netbox.VirtualMachine{Id: 3}, nil)
+		netboxMock.EXPECT().UpdateClusterStatus(gomock.Any(), 2, netbox.DecommissioningStatus).
+			Return(&netbox.Cluster{Id: 2}, nil)
+
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		err = r.Client.Get(ctx, request.NamespacedName, cluster)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("syncs the virtual machine of the machine in the Netbox cluster only", func() {
+		machine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "machine-0",
+				Namespace: namespace,
+				Labels:    map[string]string{clusterv1.ClusterNameLabel: "cluster"},
+			},
+		}
+		cluster.Finalizers = []string{InventoryFinalizer}
+		cluster.Annotations = map[string]string{ipamv1alpha1.InventoryPoolAnnotation: pool.Name}
+		r := newReconciler(cluster, machine)
+
+		netboxMock.EXPECT().FindCluster(gomock.Any(), "test-namespace/cluster").
+			Return(&netbox.Cluster{Id: 2, Name: "test-namespace/cluster", Status: &netbox.Status{Value: netbox.ActiveStatus}}, nil)
+		netboxMock.EXPECT().FindTag(gomock.Any(), DefaultInventoryTag).Return(&netbox.Tag{Id: 7, Slug: DefaultInventoryTag}, nil)
+		// A virtual machine of the same name in another cluster is not looked up, so it is not taken over.
+		netboxMock.EXPECT().FindClusterVirtualMachine(gomock.Any(), 2, "machine-0").Return(&netbox.VirtualMachine{
+			Id:      3,
+			Name:    "machine-0",
+			Status:  &netbox.Status{Value: netbox.ActiveStatus},
+			Cluster: &netbox.Cluster{Id: 2},
+		}, nil)
+		netboxMock.EXPECT().GetVirtualMachines(gomock.Any(), 2).Return([]netbox.VirtualMachine{
+			{Id: 3, Name: "machine-0", Status: &netbox.Status{Value: netbox.ActiveStatus}},
+		}, nil)
+
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("does not sync a cluster without addresses of a NetboxIPPool", func() {
+		r := newReconciler(cluster)
+
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Client.Get(ctx, request.NamespacedName, cluster)).To(Succeed())
+		Expect(cluster.Finalizers).To(BeEmpty())
+		Expect(cluster.Annotations).ToNot(HaveKey(ipamv1alpha1.InventoryPoolAnnotation))
+	})
+})
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+)
+
+// poolMigratedReason is the reason of the event emitted when the pool moved to another prefix or ip-range while it had
+// addresses allocated.
+const poolMigratedReason = "Migrated"
+
+// reconcileMigration records the prefix or ip-range the pool referenced before in Migration, when the pool moved to
+// another prefix, ip-range or vrf while addresses allocated from the previous one are still in use. These addresses are
+// kept and released from the previous prefix or ip-range, also when they are still in range of the pool, like after a
+// move to another vrf. The record is removed once none of these addresses are in use anymore.
+func (r *NetboxIPPoolReconciler) reconcileMigration(pool *ipamv1alpha1.NetboxIPPool, previous *ipamv1alpha1.NetboxIPPoolStatus,
+	addressesInUse []ipamv1.IPAddress) {
+	inUse := make([]string, 0, len(addressesInUse))
+	for _, address := range addressesInUse {
+		inUse = append(inUse, address.Spec.Address)
+	}
+
+	if moved(previous, &pool.Status) && len(inUse) > 0 {
+		migration := &ipamv1alpha1.NetboxPoolStatusMigration{
+			NetboxId:   previous.NetboxId,
+			NetboxType: previous.NetboxType,
+			Addresses:  inUse,
+		}
+		if len(previous.Members) > 0 {
+			migration.CIDR = previous.Members[0].CIDR
+			migration.Vrf = previous.Members[0].Vrf
+		}
+		pool.Status.Migration = migration
+		r.Recorder.Eventf(pool, corev1.EventTypeNormal, poolMigratedReason,
+			"Pool moved from %s %d to %s %d, %d addresses are released from %s %d",
+			previous.NetboxType, previous.NetboxId, pool.Status.NetboxType, pool.Status.NetboxId, len(inUse),
+			previous.NetboxType, previous.NetboxId)
+		return
+	}
+
+	migration := pool.Status.Migration
+	if migration == nil {
+		return
+	}
+	migration.Addresses = slices.DeleteFunc(migration.Addresses, func(address string) bool {
+		return !slices.Contains(inUse, address)
+	})
+	if len(migration.Addresses) == 0 {
+		pool.Status.Migration = nil
+	}
+}
+
+// moved returns whether the pool references another prefix or ip-range, or the same prefix in another vrf, than it
+// did before.
+func moved(previous, current *ipamv1alpha1.NetboxIPPoolStatus) bool {
+	if previous.NetboxId == 0 {
+		return false
+	}
+	if previous.NetboxId != current.NetboxId || previous.NetboxType != current.NetboxType {
+		return true
+	}
+	return len(previous.Members) > 0 && len(current.Members) > 0 && previous.Members[0].Vrf != current.Members[0].Vrf
+}
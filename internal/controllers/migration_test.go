@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+)
+
+var _ = Describe("reconcileMigration", func() {
+	var (
+		recorder       *record.FakeRecorder
+		reconciler     *NetboxIPPoolReconciler
+		pool           *ipamv1alpha1.NetboxIPPool
+		previous       *ipamv1alpha1.NetboxIPPoolStatus
+		addressesInUse []ipamv1.IPAddress
+	)
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &NetboxIPPoolReconciler{Recorder: recorder}
+		previous = &ipamv1alpha1.NetboxIPPoolStatus{
+			NetboxId:   1,
+			NetboxType: "prefix",
+			Members:    []ipamv1alpha1.NetboxPoolStatusMember{{CIDR: "10.0.0.0/24", Vrf: "old"}},
+		}
+		pool = &ipamv1alpha1.NetboxIPPool{
+			Status: ipamv1alpha1.NetboxIPPoolStatus{
+				NetboxId:            2,
+				NetboxType:          "prefix",
+				Members:             []ipamv1alpha1.NetboxPoolStatusMember{{CIDR: "10.1.0.0/24", Vrf: "old"}},
+				OutOfRangeAddresses: []ipamv1alpha1.NetboxPoolStatusOutOfRangeAddress{{Name: "test", Address: "10.0.0.20"}},
+			},
+		}
+		addressesInUse = []ipamv1.IPAddress{{Spec: ipamv1.IPAddressSpec{Address: "10.0.0.20"}}}
+	})
+
+	It("records the previous prefix when the pool moved with addresses in use", func() {
+		reconciler.reconcileMigration(pool, previous, addressesInUse)
+		Expect(pool.Status.Migration).To(Equal(&ipamv1alpha1.NetboxPoolStatusMigration{
+			NetboxId: 1, NetboxType: "prefix", CIDR: "10.0.0.0/24", Vrf: "old", Addresses: []string{"10.0.0.20"},
+		}))
+		Expect(recorder.Events).To(Receive(ContainSubstring(poolMigratedReason)))
+	})
+
+	It("records the previous vrf when only the vrf of the pool changed", func() {
+		pool.Status.NetboxId = 1
+		pool.Status.Members = []ipamv1alpha1.NetboxPoolStatusMember{{CIDR: "10.0.0.0/24", Vrf: "new"}}
+		pool.Status.OutOfRangeAddresses = nil
+		reconciler.reconcileMigration(pool, previous, addressesInUse)
+		Expect(pool.Status.Migration).To(Equal(&ipamv1alpha1.NetboxPoolStatusMigration{
+			NetboxId: 1, NetboxType: "prefix", CIDR: "10.0.0.0/24", Vrf: "old", Addresses: []string{"10.0.0.20"},
+		}))
+		Expect(recorder.Events).To(Receive(ContainSubstring(poolMigratedReason)))
+	})
+
+	It("records the previous type when only the type of the pool changed", func() {
+		pool.Status.NetboxId = 1
+		pool.Status.NetboxType = "iprange"
+		reconciler.reconcileMigration(pool, previous, addressesInUse)
+		Expect(pool.Status.Migration).ToNot(BeNil())
+		Expect(pool.Status.Migration.NetboxType).To(Equal("prefix"))
+	})
+
+	It("keeps the record while the addresses allocated before are in use", func() {
+		reconciler.reconcileMigration(pool, previous, addressesInUse)
+		Expect(recorder.Events).To(Receive())
+
+		pool.Status.OutOfRangeAddresses = nil
+		reconciler.reconcileMigration(pool, pool.Status.DeepCopy(), addressesInUse)
+		Expect(pool.Status.Migration).ToNot(BeNil())
+		Expect(recorder.Events).ToNot(Receive())
+	})
+
+	It("removes the record once the addresses allocated before are released", func() {
+		reconciler.reconcileMigration(pool, previous, addressesInUse)
+		addressesInUse = []ipamv1.IPAddress{{Spec: ipamv1.IPAddressSpec{Address: "10.1.0.5"}}}
+		reconciler.reconcileMigration(pool, pool.Status.DeepCopy(), addressesInUse)
+		Expect(pool.Status.Migration).To(BeNil())
+	})
+
+	It("records nothing when the pool moved without addresses in use", func() {
+		reconciler.reconcileMigration(pool, previous, nil)
+		Expect(pool.Status.Migration).To(BeNil())
+		Expect(recorder.Events).ToNot(Receive())
+	})
+
+	It("records nothing when the pool did not move", func() {
+		pool.Status.NetboxId = 1
+		pool.Status.Members = previous.Members
+		reconciler.reconcileMigration(pool, previous, addressesInUse)
+		Expect(pool.Status.Migration).To(BeNil())
+		Expect(recorder.Events).ToNot(Receive())
+	})
+})
@@ -3,10 +3,18 @@ package controller
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/cluster-api-ipam-provider-in-cluster/pkg/ipamutil"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
@@ -18,13 +26,28 @@ import (
 
 	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
 	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/logger"
+	poolutil "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/pool"
 	ipampredicates "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/predicates"
 	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
 )
 
+// maxStickyAddresses is the maximum number of addresses released by Machines that are recorded in the status of a
+// pool with the Sticky allocation strategy.
+const maxStickyAddresses = 256
+
 // NetboxProviderAdapter is used as middle layer for provider integration.
 type NetboxProviderAdapter struct {
-	NetboxServiceFactory func(url, apiToken string) (netbox.Client, error)
+	NetboxServiceFactory func(url, apiToken, apiVersion string) (netbox.Client, error)
+	// MaxConcurrentReconciles is the number of claims that are reconciled in parallel. Claims allocating from the same
+	// prefix or ip-range in Netbox are always allocated one at a time.
+	MaxConcurrentReconciles int
+	// Batcher, if set, allocates the free addresses of claims of the same pool that are reconciled at about the same
+	// time with one request to Netbox. It is not used with the Random allocation strategy.
+	Batcher *netbox.AddressBatcher
+
+	locks     poolLocks
+	quotas    quotaReservations
+	addresses addressReservations
 }
 
 var _ ipamutil.ProviderAdapter = &NetboxProviderAdapter{}
@@ -34,7 +57,11 @@ type IPAddressClaimHandler struct {
 	client.Client
 	claim                *ipamv1.IPAddressClaim
 	pool                 *ipamv1alpha1.NetboxIPPool
-	netboxServiceFactory func(url, apiToken string) (netbox.Client, error)
+	netboxServiceFactory func(url, apiToken, apiVersion string) (netbox.Client, error)
+	locks                *poolLocks
+	quotas               *quotaReservations
+	addresses            *addressReservations
+	batcher              *netbox.AddressBatcher
 }
 
 var _ ipamutil.ClaimHandler = &IPAddressClaimHandler{}
@@ -48,8 +75,8 @@ func (a *NetboxProviderAdapter) SetupWithManager(_ context.Context, b *ctrl.Buil
 			}),
 		)).
 		WithOptions(controller.Options{
-			// To avoid race conditions when allocating IP Addresses, we explicitly set this to 1
-			MaxConcurrentReconciles: 1,
+			// Race conditions when allocating IP Addresses are avoided by locking the prefixes and ip-ranges of the claim.
+			MaxConcurrentReconciles: max(a.MaxConcurrentReconciles, 1),
 		}).
 		Owns(&ipamv1.IPAddress{}, builder.WithPredicates(
 			ipampredicates.AddressReferencesPoolKind(metav1.GroupKind{
@@ -66,6 +93,10 @@ func (a *NetboxProviderAdapter) ClaimHandlerFor(cl client.Client, claim *ipamv1.
 		Client:               cl,
 		claim:                claim,
 		netboxServiceFactory: a.NetboxServiceFactory,
+		locks:                &a.locks,
+		quotas:               &a.quotas,
+		addresses:            &a.addresses,
+		batcher:              a.Batcher,
 	}
 }
 
@@ -97,53 +128,984 @@ func (h *IPAddressClaimHandler) FetchPool(ctx context.Context) (client.Object, *
 }
 
 // EnsureAddress ensures that the IPAddress contains a valid address.
-func (h *IPAddressClaimHandler) EnsureAddress(ctx context.Context, address *ipamv1.IPAddress) (*ctrl.Result, error) {
+func (h *IPAddressClaimHandler) EnsureAddress(ctx context.Context, address *ipamv1.IPAddress) (_ *ctrl.Result, rerr error) {
 	log := logger.FromContext(ctx)
 
-	secret, err := getSecretForPool(ctx, h.Client, h.pool)
+	// The address was already allocated during a previous reconciliation.
+	if address.Spec.Address != "" {
+		if err := h.ensureDnsName(ctx, address); err != nil {
+			log.Error(err, "could not update dns name")
+			return &ctrl.Result{}, fmt.Errorf("unable to ensure dns name: %w", err)
+		}
+		if err := h.ensurePrimaryAddress(ctx, address); err != nil {
+			log.Error(err, "could not mark primary address")
+			return &ctrl.Result{}, fmt.Errorf("unable to ensure primary address: %w", err)
+		}
+		return nil, nil
+	}
+
+	if conditions.IsFalse(h.pool, ipamv1alpha1.NetboxVersionSupportedCondition) {
+		err := fmt.Errorf("pool %s: %s", h.pool.GetName(),
+			conditions.GetMessage(h.pool, ipamv1alpha1.NetboxVersionSupportedCondition))
+		log.Info("Netbox version of the pool is not supported", "reason", err.Error())
+		return h.allocationFailed(err)
+	}
+
+	if err := h.ensureWithinQuota(ctx); err != nil {
+		log.Info("claim exceeds the quota of the pool", "reason", err.Error())
+		return h.allocationFailed(err)
+	}
+	// The claim keeps its reservation of the quota until its IPAddress exists, unless no address is allocated.
+	defer func(pool *ipamv1alpha1.NetboxIPPool) {
+		if rerr != nil {
+			h.quotas.release(pool, h.claim)
+		}
+	}(h.pool)
+
+	// An adopted or requested address stays reserved for the claim until its IPAddress exists, unless no address is
+	// allocated.
+	defer func() {
+		if rerr != nil {
+			h.addresses.release(h.claim)
+		}
+	}()
+
+	// From here on the handler works with the prefixes or ip-ranges of the address family of the claim.
+	family, err := claimFamily(h.claim, h.pool)
 	if err != nil {
-		log.Error(err, "could not get secret")
-		conditions.MarkFalse(h.claim,
-			clusterv1.ReadyCondition,
-			ipamv1.AllocationFailedReason,
-			clusterv1.ConditionSeverityError,
-			"could not allocate address: %s", err)
-		return &ctrl.Result{}, fmt.Errorf("unable to ensure address: %w", err)
+		log.Error(err, "could not determine ip family of claim")
+		return h.allocationFailed(err)
 	}
+	pool, err := withDiscoveredGateways(h.pool)
+	if err != nil {
+		log.Error(err, "could not get discovered gateway of pool")
+		return h.allocationFailed(err)
+	}
+	pool, err = poolutil.ForFamily(pool, family)
+	if err != nil {
+		log.Error(err, "could not select ip family of pool")
+		return h.allocationFailed(err)
+	}
+	h.pool = pool
 
-	netboxClient, err := getNetboxClient(secret, h.netboxServiceFactory)
+	netboxClient, err := h.getNetboxClient(ctx)
 	if err != nil {
 		log.Error(err, "could not get netbox client")
-		conditions.MarkFalse(h.claim,
-			clusterv1.ReadyCondition,
-			ipamv1.AllocationFailedReason,
-			clusterv1.ConditionSeverityError,
-			"could not allocate address: %s", err)
-		return &ctrl.Result{}, fmt.Errorf("unable to ensure address: %w", err)
+		return h.allocationFailed(err)
 	}
 
-	ipAddress, err := netboxClient.NextAvailablePrefixAddress(ctx)
+	members, err := h.getPoolMembers(ctx, netboxClient)
 	if err != nil {
-		log.Error(err, "could not get netbox client")
-		conditions.MarkFalse(h.claim,
-			clusterv1.ReadyCondition,
-			ipamv1.AllocationFailedReason,
-			clusterv1.ConditionSeverityError,
-			"could not allocate address: %s", err)
-		return &ctrl.Result{}, fmt.Errorf("unable to ensure address: %w", err)
+		log.Error(err, "could not get netbox pool")
+		return h.allocationFailed(err)
+	}
+
+	// Netbox and the addresses in use are consulted to find a free address, so claims allocating from the same prefixes
+	// or ip-ranges must not be allocated in parallel, even if they belong to different pools.
+	unlock := h.locks.lock(memberKeys(members)...)
+	defer func() { unlock() }()
+
+	if h.pool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+		prefix, err := h.allocatePrefix(ctx, netboxClient, members)
+		if err != nil {
+			log.Error(err, "could not allocate prefix")
+			return h.allocationFailed(err)
+		}
+
+		address.Spec.Address = prefix.WithoutPrefixLen().String()
+		address.Spec.Prefix = prefix.GetNetworkPrefixLen().Len()
+		return nil, nil
 	}
 
-	address.Spec.Address = ipAddress.String()
+	// The attributes of the address are resolved before the address is created in Netbox, so that an invalid DNS name
+	// or a missing virtual machine does not leave an address behind.
+	attributes := netbox.IPAddressAttributes{Status: string(h.pool.Spec.Status)}
+	attributes.DnsName, err = h.dnsName()
+	if err != nil {
+		log.Error(err, "could not render dns name")
+		return h.allocationFailed(err)
+	}
+	if h.pool.Spec.VMInterface != nil {
+		vmInterface, err := h.getVMInterface(ctx, netboxClient)
+		if err != nil {
+			log.Error(err, "could not get virtual machine interface")
+			return h.allocationFailed(err)
+		}
+		attributes.VMInterfaceId = vmInterface.Id
+	}
+
+	var ipAddress *ipaddr.IPAddress
+	var member poolMember
+	if requested, ok := h.claim.GetAnnotations()[ipamv1alpha1.RequestedAddressAnnotation]; ok {
+		member = memberFor(members, requested)
+		ipAddress, err = h.createRequestedAddress(ctx, netboxClient, member, attributes)
+		if err != nil {
+			log.Error(err, "could not allocate requested address")
+			return h.allocationFailed(err)
+		}
+	} else {
+		ipAddress, member, err = h.adoptAddress(ctx, netboxClient, members)
+		if err != nil {
+			log.Error(err, "could not adopt address")
+			return h.allocationFailed(err)
+		}
+		if ipAddress != nil && (attributes.DnsName != "" || attributes.VMInterfaceId != 0) {
+			if err := syncAdoptedAddress(ctx, netboxClient, ipAddress.WithoutPrefixLen().String(), member.pool.Spec.Vrf, attributes); err != nil {
+				log.Error(err, "could not update adopted address")
+				return h.allocationFailed(err)
+			}
+		}
+	}
+
+	if ipAddress == nil && h.pool.Spec.AllocationStrategy == ipamv1alpha1.StickyAllocationStrategy {
+		ipAddress, member, err = h.allocateStickyAddress(ctx, netboxClient, members, attributes)
+		if err != nil {
+			log.Error(err, "could not allocate sticky address")
+			return h.allocationFailed(err)
+		}
+	}
+
+	if ipAddress == nil {
+		if h.batched() {
+			// A batch locks its prefix or ip-range itself while it is allocated, so other claims of the pool can join
+			// the batch.
+			unlock()
+			unlock = func() {}
+		}
+		ipAddress, member, err = h.allocateAddress(ctx, netboxClient, members, attributes)
+		if err != nil {
+			log.Error(err, "could not allocate address")
+			return h.allocationFailed(err)
+		}
+	}
+
+	address.Spec.Address = ipAddress.WithoutPrefixLen().String()
 	address.Spec.Prefix = ipAddress.GetNetworkPrefixLen().Len()
-	address.Spec.Gateway = h.pool.Spec.Gateway
+	address.Spec.Gateway = member.pool.Spec.Gateway
+	setFQDNAnnotation(address, attributes.DnsName)
 
 	return nil, nil
 }
 
-func (h *IPAddressClaimHandler) ReleaseAddress(_ context.Context) (*ctrl.Result, error) {
+// ReleaseAddress releases the address of the claim in Netbox.
+func (h *IPAddressClaimHandler) ReleaseAddress(ctx context.Context) (*ctrl.Result, error) {
+	log := logger.FromContext(ctx)
+
+	// A claim deleted before its IPAddress exists does not count against the quota anymore, nor does it keep its
+	// address reserved.
+	h.quotas.release(h.pool, h.claim)
+	h.addresses.release(h.claim)
+
+	address := &ipamv1.IPAddress{}
+	if err := h.Client.Get(ctx, types.NamespacedName{Namespace: h.claim.Namespace, Name: h.claim.Name}, address); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to fetch address")
+	}
+	if address.Spec.Address == "" {
+		return nil, nil
+	}
+
+	// Deleting the address in Netbox does not interfere with allocations, so releases are not locked.
+	family := ipaddr.NewIPAddressString(address.Spec.Address).GetIPVersion()
+	pool, err := poolutil.ForFamily(h.pool, family)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to release address")
+	}
+	h.pool = pool
+
+	netboxClient, err := h.getNetboxClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to release address")
+	}
+
+	member := poolMemberFor(h.pool, address.Spec.Address)
+
+	if h.pool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+		return h.releasePrefix(ctx, netboxClient, member, address)
+	}
+
+	ipAddress, err := netboxClient.GetIPAddress(ctx, address.Spec.Address, member.Spec.Vrf)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to release address")
+	}
+	if migration := h.pool.Status.Migration; ipAddress == nil && migration != nil && migration.Vrf != member.Spec.Vrf {
+		// The address was allocated before the pool moved to another vrf.
+		ipAddress, err = netboxClient.GetIPAddress(ctx, address.Spec.Address, migration.Vrf)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to release address")
+		}
+	}
+	if ipAddress == nil {
+		log.Info("address does not exist in Netbox, nothing to release", "address", address.Spec.Address)
+		return nil, nil
+	}
+
+	if previous, ok := address.GetAnnotations()[ipamv1alpha1.PreviousPrimaryAnnotation]; ok {
+		if err := restorePrimaryAddress(ctx, netboxClient, ipAddress, family, previous); err != nil {
+			return nil, errors.Wrap(err, "unable to restore primary address")
+		}
+	}
+
+	if h.pool.Spec.AllocationStrategy == ipamv1alpha1.StickyAllocationStrategy {
+		if err := h.recordStickyAddress(ctx, address.Spec.Address); err != nil {
+			return nil, errors.Wrap(err, "unable to record sticky address")
+		}
+	}
+
+	if h.pool.Spec.VMInterface != nil && ipAddress.AssignedObjectId != nil {
+		if _, err := netboxClient.UpdateIPAddressAssignment(ctx, ipAddress.Id, 0); err != nil {
+			return nil, errors.Wrap(err, "unable to unassign address")
+		}
+	}
+
+	if quarantine := h.pool.Spec.Quarantine; quarantine != nil {
+		// The address is deleted by the QuarantineCleaner once the quarantine period expires.
+		if _, err := netboxClient.QuarantineIPAddress(ctx, ipAddress.Id, quarantineStatus(quarantine), time.Now()); err != nil {
+			return nil, errors.Wrap(err, "unable to quarantine address")
+		}
+		return nil, nil
+	}
+
+	// Deleting the address in Netbox also clears its DNS name.
+	if err := netboxClient.DeleteIPAddress(ctx, ipAddress.Id); err != nil {
+		return nil, errors.Wrap(err, "unable to release address")
+	}
+
+	return nil, nil
+}
+
+// poolMember is a single prefix or ip-range of the pool, together with its counterpart in Netbox.
+type poolMember struct {
+	pool   *ipamv1alpha1.NetboxIPPool
+	netbox *netbox.NetboxIPPool
+}
+
+// getPoolMembers returns the prefixes and ip-ranges of the pool, in allocation order.
+func (h *IPAddressClaimHandler) getPoolMembers(ctx context.Context, nb netbox.Client) ([]poolMember, error) {
+	var members []poolMember
+	for _, pool := range poolutil.Members(h.pool) {
+		netboxIPPool, err := getNetboxIPPool(ctx, nb, pool)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, poolMember{pool: pool, netbox: netboxIPPool})
+	}
+	return members, nil
+}
+
+// memberFor returns the member of the pool containing the address. If no member contains the address, the first
+// member is returned, so that validation reports the address not being part of the pool.
+func memberFor(members []poolMember, address string) poolMember {
+	ipAddress, err := ipaddr.NewIPAddressString(address).ToAddress()
+	if err == nil {
+		for _, member := range members {
+			if member.netbox.Contains(ipAddress) {
+				return member
+			}
+		}
+	}
+	return members[0]
+}
+
+// adoptAddress looks up an address that already exists in Netbox and should be linked to the claim. This is either
+// the address requested by the AdoptAddressAnnotation, or, if the pool adopts existing addresses, the address in the
+// pool whose description equals the name of the claim. If there is nothing to adopt, nil is returned.
+func (h *IPAddressClaimHandler) adoptAddress(ctx context.Context, nb netbox.Client, members []poolMember) (*ipaddr.IPAddress, poolMember, error) {
+	if requested, ok := h.claim.GetAnnotations()[ipamv1alpha1.AdoptAddressAnnotation]; ok {
+		member := memberFor(members, requested)
+		ipAddress, err := h.adoptRequestedAddress(ctx, nb, member, requested)
+		return ipAddress, member, err
+	}
+	if !h.pool.Spec.AdoptExisting {
+		return nil, poolMember{}, nil
+	}
+
+	var adoptable []*ipaddr.IPAddress
+	var adoptableMembers []poolMember
+	for _, member := range members {
+		candidates, err := nb.FindIPAddresses(ctx, h.claim.Name, member.pool.Spec.Vrf)
+		if err != nil {
+			return nil, poolMember{}, err
+		}
+		for _, candidate := range candidates {
+			ipAddress, err := ipaddr.NewIPAddressString(candidate.Address).ToAddress()
+			if err != nil {
+				return nil, poolMember{}, errors.Wrap(err, fmt.Sprintf("could not parse address '%s'", candidate.Address))
+			}
+			if member.netbox.Contains(ipAddress) {
+				adoptable = append(adoptable, ipAddress)
+				adoptableMembers = append(adoptableMembers, member)
+			}
+		}
+	}
+	if len(adoptable) == 0 {
+		return nil, poolMember{}, nil
+	}
+	if len(adoptable) != 1 {
+		return nil, poolMember{}, fmt.Errorf("multiple addresses in pool %s have description '%s'", h.pool.Name, h.claim.Name)
+	}
+
+	if err := h.reserveAddress(ctx, adoptableMembers[0], adoptable[0]); err != nil {
+		return nil, poolMember{}, err
+	}
+	return adoptable[0], adoptableMembers[0], nil
+}
+
+// adoptRequestedAddress looks up the address requested by the AdoptAddressAnnotation in Netbox.
+func (h *IPAddressClaimHandler) adoptRequestedAddress(ctx context.Context, nb netbox.Client, member poolMember, requested string) (*ipaddr.IPAddress, error) {
+	requestedAddress, rerr := ipaddr.NewIPAddressString(requested).ToAddress()
+	if rerr != nil {
+		return nil, errors.Wrap(rerr, fmt.Sprintf("invalid address '%s' to adopt", requested))
+	}
+	existing, err := nb.GetIPAddress(ctx, requestedAddress.WithoutPrefixLen().String(), member.pool.Spec.Vrf)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("address '%s' to adopt does not exist in vrf '%s'", requested, member.pool.Spec.Vrf)
+	}
+	if isQuarantined(existing) {
+		return nil, fmt.Errorf("address '%s' to adopt is quarantined", requested)
+	}
+
+	ipAddress, err := ipaddr.NewIPAddressString(existing.Address).ToAddress()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not parse address '%s'", existing.Address))
+	}
+	if !member.netbox.Contains(ipAddress) {
+		return nil, fmt.Errorf("address '%s' to adopt is not part of pool %s", existing.Address, member.netbox.Display)
+	}
+
+	if err := h.reserveAddress(ctx, member, ipAddress); err != nil {
+		return nil, err
+	}
+	return ipAddress, nil
+}
+
+// allocateAddress creates a free address of the pool in Netbox, picked by the allocation strategy of the pool. The
+// members of the pool are tried in order, falling through to the next member when one is exhausted.
+func (h *IPAddressClaimHandler) allocateAddress(ctx context.Context, nb netbox.Client, members []poolMember, attributes netbox.IPAddressAttributes) (*ipaddr.IPAddress, poolMember, error) {
+	log := logger.FromContext(ctx)
+
+	for _, member := range members {
+		ipAddress, err := h.allocateMemberAddress(ctx, nb, member, attributes)
+		if errors.Is(err, netbox.ErrPoolExhausted) {
+			log.Debug("pool member is exhausted, trying next member", "member", member.netbox.Display)
+			continue
+		}
+		if err != nil {
+			return nil, poolMember{}, err
+		}
+		return ipAddress, member, nil
+	}
+	return nil, poolMember{}, fmt.Errorf("no free address available in pool %s: %w", h.pool.Name, netbox.ErrPoolExhausted)
+}
+
+// allocateMemberAddress creates a free address of a member of the pool in Netbox, skipping the gateway and the
+// addresses excluded from the pool. Quarantined addresses still exist in Netbox, so they are never free. The lowest
+// free address is taken, or a random one with the Random allocation strategy.
+func (h *IPAddressClaimHandler) allocateMemberAddress(ctx context.Context, nb netbox.Client, member poolMember, attributes netbox.IPAddressAttributes) (*ipaddr.IPAddress, error) {
+	excluded, err := poolutil.ParseAddressRanges(member.pool.Spec.Exclude)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid excluded addresses")
+	}
+	gateway := poolGateway(member.pool)
+	skip := func(ipAddress *ipaddr.IPAddress) bool {
+		return excluded.Contains(ipAddress) || (gateway != nil && gateway.Equal(ipAddress.WithoutPrefixLen()))
+	}
+
+	if h.batched() {
+		poolKey := client.ObjectKeyFromObject(h.pool)
+		return h.batcher.CreateIPAddress(ctx, netbox.AddressBatchRequest{
+			Key:        fmt.Sprintf("%s/%s/%d", poolKey, member.netbox.Type, member.netbox.Id),
+			Client:     nb,
+			Pool:       member.netbox,
+			Vrf:        member.pool.Spec.Vrf,
+			Attributes: attributes,
+			Skip:       skip,
+			MaxSkipped: excluded.CountIn(member.netbox.Range) + 1,
+			Lock: func() func() {
+				return h.locks.lock(netboxPoolKey(member.netbox))
+			},
+		})
+	}
+
+	// The free addresses are not reserved in Netbox, so another client of Netbox can take the picked address before
+	// it is created. Netbox then rejects it as duplicate, and a free address is picked again.
+	for attempt := 1; ; attempt++ {
+		ipAddress, err := h.createFreeAddress(ctx, nb, member, attributes, skip, excluded.CountIn(member.netbox.Range))
+		if errors.Is(err, netbox.ErrDuplicateIPAddress) && attempt < netbox.AllocationAttempts {
+			logger.FromContext(ctx).Debug("free address was taken in the meantime, retrying", "member", member.netbox.Display)
+			continue
+		}
+		return ipAddress, err
+	}
+}
+
+// createFreeAddress creates a free address of a member of the pool in Netbox, which skip does not return true for.
+// Skip returns true for at most maxSkipped free addresses of the member.
+func (h *IPAddressClaimHandler) createFreeAddress(ctx context.Context, nb netbox.Client, member poolMember, attributes netbox.IPAddressAttributes, skip func(*ipaddr.IPAddress) bool, maxSkipped int) (*ipaddr.IPAddress, error) {
+	// Netbox returns the lowest free addresses first. Asking for one more address than can be skipped guarantees a
+	// usable address, as long as the pool is not exhausted. A random address is picked out of as many free addresses
+	// as Netbox returns at once.
+	limit := min(maxSkipped+2, netbox.MaxAvailableAddresses)
+	if h.pool.Spec.AllocationStrategy == ipamv1alpha1.RandomAllocationStrategy {
+		limit = netbox.MaxAvailableAddresses
+	}
+	available, err := nb.GetAvailableIPAddresses(ctx, member.netbox, limit)
+	if err != nil {
+		return nil, err
+	}
+	var usable []*ipaddr.IPAddress
+	for _, ipAddress := range available {
+		if skip(ipAddress) {
+			continue
+		}
+		usable = append(usable, ipAddress)
+	}
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("no free address available in %s: %w", member.netbox.Display, netbox.ErrPoolExhausted)
+	}
+
+	ipAddress := usable[0]
+	if h.pool.Spec.AllocationStrategy == ipamv1alpha1.RandomAllocationStrategy {
+		ipAddress = usable[rand.IntN(len(usable))]
+	}
+	if _, err := nb.CreateIPAddress(ctx, ipAddress.String(), member.pool.Spec.Vrf, attributes); err != nil {
+		return nil, err
+	}
+	return ipAddress, nil
+}
+
+// allocateStickyAddress creates the address last released by the Machine owning the claim in Netbox, if it is still
+// free. If there is no such address, or it is not free anymore, nil is returned.
+func (h *IPAddressClaimHandler) allocateStickyAddress(ctx context.Context, nb netbox.Client, members []poolMember, attributes netbox.IPAddressAttributes) (*ipaddr.IPAddress, poolMember, error) {
+	log := logger.FromContext(ctx)
+
+	machine := claimMachine(h.claim)
+	if machine == nil {
+		return nil, poolMember{}, nil
+	}
+	family := ipaddr.NewIPAddressString(h.pool.Spec.CIDR).GetIPVersion()
+	var sticky *ipaddr.IPAddress
+	for _, s := range h.pool.Status.StickyAddresses {
+		address, err := ipaddr.NewIPAddressString(s.Address).ToAddress()
+		if err == nil && s.Machine == machine.Name && address.GetIPVersion() == family {
+			sticky = address.WithoutPrefixLen()
+		}
+	}
+	if sticky == nil {
+		return nil, poolMember{}, nil
+	}
+
+	member := memberFor(members, sticky.String())
+	excluded, err := poolutil.ParseAddressRanges(member.pool.Spec.Exclude)
+	if err != nil {
+		return nil, poolMember{}, errors.Wrap(err, "invalid excluded addresses")
+	}
+	gateway := poolGateway(member.pool)
+	if !member.netbox.Contains(sticky) || excluded.Contains(sticky) || (gateway != nil && gateway.Equal(sticky)) {
+		log.Debug("sticky address is not part of the pool anymore", "address", sticky.String())
+		return nil, poolMember{}, nil
+	}
+	existing, err := nb.GetIPAddress(ctx, sticky.String(), member.pool.Spec.Vrf)
+	if err != nil {
+		return nil, poolMember{}, err
+	}
+	if existing != nil {
+		log.Debug("sticky address is already taken in Netbox", "address", sticky.String())
+		return nil, poolMember{}, nil
+	}
+	if err := h.reserveAddress(ctx, member, sticky); err != nil {
+		log.Debug("sticky address is not free", "address", sticky.String(), "reason", err.Error())
+		return nil, poolMember{}, nil
+	}
+
+	prefixLen, err := poolPrefixLen(member.pool)
+	if err != nil {
+		return nil, poolMember{}, err
+	}
+	ipAddress := sticky.SetPrefixLen(prefixLen)
+	if _, err := nb.CreateIPAddress(ctx, ipAddress.String(), member.pool.Spec.Vrf, attributes); err != nil {
+		return nil, poolMember{}, err
+	}
+	return ipAddress, member, nil
+}
+
+// recordStickyAddress records the address in the status of the pool as the address last held by the Machine owning
+// the claim, so that it can be allocated again for a Machine with the same name.
+func (h *IPAddressClaimHandler) recordStickyAddress(ctx context.Context, address string) error {
+	machine := claimMachine(h.claim)
+	if machine == nil {
+		return nil
+	}
+
+	pool := &ipamv1alpha1.NetboxIPPool{}
+	if err := h.Client.Get(ctx, client.ObjectKey{Namespace: h.pool.Namespace, Name: h.pool.Name}, pool); err != nil {
+		return err
+	}
+	original := pool.DeepCopy()
+
+	family := ipaddr.NewIPAddressString(address).GetIPVersion()
+	var stickyAddresses []ipamv1alpha1.StickyAddress
+	for _, s := range pool.Status.StickyAddresses {
+		if s.Machine != machine.Name || ipaddr.NewIPAddressString(s.Address).GetIPVersion() != family {
+			stickyAddresses = append(stickyAddresses, s)
+		}
+	}
+	stickyAddresses = append(stickyAddresses, ipamv1alpha1.StickyAddress{Machine: machine.Name, Address: address})
+	if len(stickyAddresses) > maxStickyAddresses {
+		stickyAddresses = stickyAddresses[len(stickyAddresses)-maxStickyAddresses:]
+	}
+	pool.Status.StickyAddresses = stickyAddresses
+	// Releases are not locked, so a conflicting release of another claim of the pool makes the release retry.
+	return h.Client.Status().Patch(ctx, pool, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}))
+}
+
+// allocatePrefix creates the first free child prefix of the configured length in the prefixes of the pool. The
+// members of the pool are tried in order, falling through to the next member when one is exhausted.
+func (h *IPAddressClaimHandler) allocatePrefix(ctx context.Context, nb netbox.Client, members []poolMember) (*ipaddr.IPAddress, error) {
+	log := logger.FromContext(ctx)
+
+	annotations := h.claim.GetAnnotations()
+	for _, annotation := range []string{ipamv1alpha1.AdoptAddressAnnotation, ipamv1alpha1.RequestedAddressAnnotation} {
+		if _, ok := annotations[annotation]; ok {
+			return nil, fmt.Errorf("annotation %s can not be used in %s allocation mode", annotation, ipamv1alpha1.PrefixAllocationMode)
+		}
+	}
+
+	for _, member := range members {
+		created, err := nb.CreateAvailablePrefix(ctx, member.netbox, h.pool.Spec.PrefixLength)
+		if errors.Is(err, netbox.ErrPoolExhausted) {
+			log.Debug("pool member is exhausted, trying next member", "member", member.netbox.Display)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := ipaddr.NewIPAddressString(created.Prefix).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse prefix '%s'", created.Prefix))
+		}
+		return prefix.ToPrefixBlock().GetLower(), nil
+	}
+	return nil, fmt.Errorf("no free /%d prefix available in pool %s: %w", h.pool.Spec.PrefixLength, h.pool.Name, netbox.ErrPoolExhausted)
+}
+
+// releasePrefix deletes the child prefix described by the address in Netbox.
+func (h *IPAddressClaimHandler) releasePrefix(ctx context.Context, nb netbox.Client, member *ipamv1alpha1.NetboxIPPool, address *ipamv1.IPAddress) (*ctrl.Result, error) {
+	log := logger.FromContext(ctx)
+
+	cidr := fmt.Sprintf("%s/%d", address.Spec.Address, address.Spec.Prefix)
+	prefix, err := nb.FindPrefix(ctx, cidr, member.Spec.Vrf)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to release prefix")
+	}
+	if prefix == nil {
+		log.Info("prefix does not exist in Netbox, nothing to release", "prefix", cidr)
+		return nil, nil
+	}
+
+	if err := nb.DeletePrefix(ctx, prefix.Id); err != nil {
+		return nil, errors.Wrap(err, "unable to release prefix")
+	}
+
 	return nil, nil
 }
 
+// createRequestedAddress creates the address requested by the RequestedAddressAnnotation in Netbox. The address must
+// be part of the pool, must not be the gateway and must not exist in Netbox yet. The address is created with the
+// description of the claim, so that an address created for the claim before its IPAddress could be created is taken
+// as allocated on retry.
+func (h *IPAddressClaimHandler) createRequestedAddress(ctx context.Context, nb netbox.Client, member poolMember, attributes netbox.IPAddressAttributes) (*ipaddr.IPAddress, error) {
+	annotations := h.claim.GetAnnotations()
+	if _, ok := annotations[ipamv1alpha1.AdoptAddressAnnotation]; ok {
+		return nil, fmt.Errorf("annotations %s and %s can not be used together",
+			ipamv1alpha1.AdoptAddressAnnotation, ipamv1alpha1.RequestedAddressAnnotation)
+	}
+
+	requested := annotations[ipamv1alpha1.RequestedAddressAnnotation]
+	requestedAddress, rerr := ipaddr.NewIPAddressString(requested).ToAddress()
+	if rerr != nil {
+		return nil, errors.Wrap(rerr, fmt.Sprintf("invalid requested address '%s'", requested))
+	}
+	requestedAddress = requestedAddress.WithoutPrefixLen()
+
+	if !member.netbox.Contains(requestedAddress) {
+		return nil, fmt.Errorf("requested address '%s' is not part of pool %s", requested, member.netbox.Display)
+	}
+	if gateway := poolGateway(member.pool); gateway != nil && gateway.Equal(requestedAddress) {
+		return nil, fmt.Errorf("requested address '%s' is the gateway of the pool", requested)
+	}
+	excluded, err := poolutil.ParseAddressRanges(member.pool.Spec.Exclude)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid excluded addresses")
+	}
+	if excluded.Contains(requestedAddress) {
+		return nil, fmt.Errorf("requested address '%s' is excluded from the pool", requested)
+	}
+
+	if err := h.reserveAddress(ctx, member, requestedAddress); err != nil {
+		return nil, err
+	}
+	existing, err := nb.GetIPAddress(ctx, requestedAddress.String(), member.pool.Spec.Vrf)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && isQuarantined(existing) {
+		return nil, fmt.Errorf("requested address '%s' is quarantined", requested)
+	}
+	if existing != nil && existing.Description == claimDescription(h.claim) {
+		ipAddress, err := ipaddr.NewIPAddressString(existing.Address).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse address '%s'", existing.Address))
+		}
+		return ipAddress, nil
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("requested address '%s' is already taken in Netbox", requested)
+	}
+
+	prefixLen, err := poolPrefixLen(member.pool)
+	if err != nil {
+		return nil, err
+	}
+	ipAddress := requestedAddress.SetPrefixLen(prefixLen)
+	attributes.Description = claimDescription(h.claim)
+	if _, err := nb.CreateIPAddress(ctx, ipAddress.String(), member.pool.Spec.Vrf, attributes); err != nil {
+		return nil, err
+	}
+	return ipAddress, nil
+}
+
+// claimDescription returns the description of addresses created in Netbox for the address requested by the claim.
+func claimDescription(claim *ipamv1.IPAddressClaim) string {
+	return fmt.Sprintf("requested by IPAddressClaim %s", client.ObjectKeyFromObject(claim))
+}
+
+// ensureDnsName keeps the DNS name of an allocated address in Netbox in sync with the DNS name pattern of the pool, for
+// example when the Machine owning the claim changed. Netbox is only updated when the rendered DNS name differs from
+// the FQDNAnnotation of the address.
+func (h *IPAddressClaimHandler) ensureDnsName(ctx context.Context, address *ipamv1.IPAddress) error {
+	if h.pool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+		return nil
+	}
+	dnsName, err := h.dnsName()
+	if err != nil {
+		return err
+	}
+	if fqdn, ok := address.GetAnnotations()[ipamv1alpha1.FQDNAnnotation]; fqdn == dnsName && (ok || dnsName == "") {
+		return nil
+	}
+
+	netboxClient, ipAddress, err := h.getAllocatedIPAddress(ctx, address)
+	if err != nil {
+		return err
+	}
+	if err := updateDnsName(ctx, netboxClient, ipAddress, dnsName); err != nil {
+		return err
+	}
+	setFQDNAnnotation(address, dnsName)
+	return nil
+}
+
+// ensurePrimaryAddress marks the address as primary address of the device or virtual machine it is assigned to in
+// Netbox, when requested by the pool or the claim. The address it replaced is recorded in the PreviousPrimaryAnnotation,
+// so it can be restored when the address is released.
+func (h *IPAddressClaimHandler) ensurePrimaryAddress(ctx context.Context, address *ipamv1.IPAddress) error {
+	if h.pool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode || !h.primaryAddress() {
+		return nil
+	}
+	if _, ok := address.GetAnnotations()[ipamv1alpha1.PreviousPrimaryAnnotation]; ok {
+		return nil
+	}
+
+	netboxClient, ipAddress, err := h.getAllocatedIPAddress(ctx, address)
+	if err != nil {
+		return err
+	}
+	host, err := netboxClient.GetIPAddressHost(ctx, ipAddress)
+	if err != nil {
+		return err
+	}
+	if host == nil {
+		return fmt.Errorf("address '%s' is not assigned to a device or virtual machine", address.Spec.Address)
+	}
+	family := ipaddr.NewIPAddressString(address.Spec.Address).GetIPVersion()
+	previous := host.PrimaryIp(family)
+	if previous == ipAddress.Id {
+		previous = 0
+	} else if err := netboxClient.SetPrimaryIPAddress(ctx, host, family, ipAddress.Id); err != nil {
+		return err
+	}
+
+	annotations := address.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[ipamv1alpha1.PreviousPrimaryAnnotation] = strconv.Itoa(previous)
+	address.SetAnnotations(annotations)
+	return nil
+}
+
+// primaryAddress returns whether the address of the claim should be marked as primary address of its host.
+func (h *IPAddressClaimHandler) primaryAddress() bool {
+	if h.pool.Spec.PrimaryAddress {
+		return true
+	}
+	primary, _ := strconv.ParseBool(h.claim.GetAnnotations()[ipamv1alpha1.PrimaryAddressAnnotation])
+	return primary
+}
+
+// getAllocatedIPAddress returns the Netbox client and the Netbox address of an address allocated earlier.
+func (h *IPAddressClaimHandler) getAllocatedIPAddress(ctx context.Context, address *ipamv1.IPAddress) (netbox.Client, *netbox.IPAddress, error) {
+	family := ipaddr.NewIPAddressString(address.Spec.Address).GetIPVersion()
+	pool, err := poolutil.ForFamily(h.pool, family)
+	if err != nil {
+		return nil, nil, err
+	}
+	netboxClient, err := h.getNetboxClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	member := poolMemberFor(pool, address.Spec.Address)
+	ipAddress, err := getExistingIPAddress(ctx, netboxClient, address.Spec.Address, member.Spec.Vrf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return netboxClient, ipAddress, nil
+}
+
+// restorePrimaryAddress restores the primary address of the host of the address to the one recorded before the
+// address was marked primary, or clears it when there was none or it can not be restored.
+func restorePrimaryAddress(ctx context.Context, netboxClient netbox.Client, ipAddress *netbox.IPAddress, family ipaddr.IPVersion, previous string) error {
+	log := logger.FromContext(ctx)
+
+	host, err := netboxClient.GetIPAddressHost(ctx, ipAddress)
+	if err != nil {
+		return err
+	}
+	if host == nil || host.PrimaryIp(family) != ipAddress.Id {
+		return nil
+	}
+	if previousId, _ := strconv.Atoi(previous); previousId != 0 {
+		err := netboxClient.SetPrimaryIPAddress(ctx, host, family, previousId)
+		if err == nil {
+			return nil
+		}
+		log.Warn("unable to restore previous primary address, clearing it", "host", host.Display, "error", err)
+	}
+	return netboxClient.SetPrimaryIPAddress(ctx, host, family, 0)
+}
+
+// dnsName renders the DNS name pattern of the pool for the claim. Without a pattern, the DNS name is empty.
+func (h *IPAddressClaimHandler) dnsName() (string, error) {
+	if h.pool.Spec.DNSNamePattern == "" {
+		return "", nil
+	}
+	var machineName string
+	if machine := claimMachine(h.claim); machine != nil {
+		machineName = machine.Name
+	}
+	return poolutil.RenderDNSName(h.pool.Spec.DNSNamePattern, poolutil.DNSNameData{
+		Claim:     h.claim.Name,
+		Namespace: h.claim.Namespace,
+		Machine:   machineName,
+		Cluster:   claimClusterName(h.claim),
+		Pool:      h.pool.Name,
+	})
+}
+
+// getVMInterface returns the interface of the Netbox virtual machine of the Machine owning the claim. The virtual
+// machine and the interface are created if they do not exist and the pool allows it.
+func (h *IPAddressClaimHandler) getVMInterface(ctx context.Context, nb netbox.Client) (*netbox.VMInterface, error) {
+	assignment := h.pool.Spec.VMInterface
+	machine := claimMachine(h.claim)
+	if machine == nil {
+		return nil, fmt.Errorf("claim %s is not owned by a machine", h.claim.Name)
+	}
+
+	var virtualMachine *netbox.VirtualMachine
+	var err error
+	if assignment.MatchBy == ipamv1alpha1.CustomFieldMatchStrategy {
+		virtualMachine, err = nb.FindVirtualMachineByCustomField(ctx, assignment.CustomField, string(machine.UID))
+	} else {
+		virtualMachine, err = nb.FindVirtualMachine(ctx, machine.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if virtualMachine == nil {
+		if !assignment.Create {
+			return nil, fmt.Errorf("virtual machine of machine %s does not exist in Netbox", machine.Name)
+		}
+		request := &netbox.VirtualMachineRequest{Name: machine.Name}
+		cluster := assignment.Cluster
+		if cluster == "" {
+			cluster = claimClusterName(h.claim)
+		}
+		if cluster != "" {
+			request.Cluster = &netbox.Cluster{Name: cluster}
+		}
+		if assignment.MatchBy == ipamv1alpha1.CustomFieldMatchStrategy {
+			request.CustomFields = map[string]any{assignment.CustomField: string(machine.UID)}
+		}
+		if virtualMachine, err = nb.CreateVirtualMachine(ctx, request); err != nil {
+			return nil, err
+		}
+	}
+
+	name := assignment.Interface
+	if name == "" {
+		name = ipamv1alpha1.DefaultVMInterface
+	}
+	vmInterface, err := nb.FindVMInterface(ctx, virtualMachine.Id, name)
+	if err != nil {
+		return nil, err
+	}
+	if vmInterface == nil {
+		if !assignment.Create {
+			return nil, fmt.Errorf("interface %s of virtual machine %s does not exist in Netbox", name, virtualMachine.Display)
+		}
+		if vmInterface, err = nb.CreateVMInterface(ctx, virtualMachine.Id, name); err != nil {
+			return nil, err
+		}
+	}
+	return vmInterface, nil
+}
+
+// syncAdoptedAddress sets the attributes of an adopted address in Netbox, if they differ. Attributes that are not set
+// are left alone.
+func syncAdoptedAddress(ctx context.Context, nb netbox.Client, address string, vrf string, attributes netbox.IPAddressAttributes) error {
+	ipAddress, err := getExistingIPAddress(ctx, nb, address, vrf)
+	if err != nil {
+		return err
+	}
+	if attributes.DnsName != "" {
+		if err := updateDnsName(ctx, nb, ipAddress, attributes.DnsName); err != nil {
+			return err
+		}
+	}
+	if attributes.VMInterfaceId != 0 && !assignedToVMInterface(ipAddress, attributes.VMInterfaceId) {
+		if _, err := nb.UpdateIPAddressAssignment(ctx, ipAddress.Id, attributes.VMInterfaceId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignedToVMInterface returns whether the address is assigned to the virtual machine interface in Netbox.
+func assignedToVMInterface(ipAddress *netbox.IPAddress, vmInterfaceId int) bool {
+	return ipAddress.AssignedObjectType == netbox.VMInterfaceObjectType &&
+		ipAddress.AssignedObjectId != nil && *ipAddress.AssignedObjectId == vmInterfaceId
+}
+
+// getExistingIPAddress returns the address in Netbox, or an error if it does not exist.
+func getExistingIPAddress(ctx context.Context, nb netbox.Client, address string, vrf string) (*netbox.IPAddress, error) {
+	ipAddress, err := nb.GetIPAddress(ctx, address, vrf)
+	if err != nil {
+		return nil, err
+	}
+	if ipAddress == nil {
+		return nil, fmt.Errorf("address '%s' does not exist in vrf '%s'", address, vrf)
+	}
+	return ipAddress, nil
+}
+
+// updateDnsName sets the DNS name of the address in Netbox, if it differs.
+func updateDnsName(ctx context.Context, nb netbox.Client, ipAddress *netbox.IPAddress, dnsName string) error {
+	if ipAddress.DnsName == dnsName {
+		return nil
+	}
+	if _, err := nb.UpdateIPAddressDnsName(ctx, ipAddress.Id, dnsName); err != nil {
+		return err
+	}
+	return nil
+}
+
+// claimMachine returns the owner reference of the Machine owning the claim. Claims are usually owned by the
+// infrastructure machine, which is named after the Machine, so the controller owner is used when the claim has no
+// Machine owner.
+func claimMachine(claim *ipamv1.IPAddressClaim) *metav1.OwnerReference {
+	var controller *metav1.OwnerReference
+	for i, ref := range claim.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err == nil && gv.Group == clusterv1.GroupVersion.Group && ref.Kind == "Machine" {
+			return &claim.OwnerReferences[i]
+		}
+		if ref.Controller != nil && *ref.Controller {
+			controller = &claim.OwnerReferences[i]
+		}
+	}
+	return controller
+}
+
+// claimClusterName returns the name of the Cluster the claim belongs to, or an empty string if it is not known.
+func claimClusterName(claim *ipamv1.IPAddressClaim) string {
+	if claim.Spec.ClusterName != "" {
+		return claim.Spec.ClusterName
+	}
+	return claim.GetLabels()[clusterv1.ClusterNameLabel]
+}
+
+func setFQDNAnnotation(address *ipamv1.IPAddress, dnsName string) {
+	if dnsName == "" {
+		delete(address.Annotations, ipamv1alpha1.FQDNAnnotation)
+		return
+	}
+	if address.Annotations == nil {
+		address.Annotations = map[string]string{}
+	}
+	address.Annotations[ipamv1alpha1.FQDNAnnotation] = dnsName
+}
+
+// reserveAddress reserves the address of the member for the claim until its IPAddress exists. It returns an error if the
+// address is already assigned to another claim of the pool, or reserved by another claim adopting or requesting it.
+func (h *IPAddressClaimHandler) reserveAddress(ctx context.Context, member poolMember, ipAddress *ipaddr.IPAddress) error {
+	poolTypeRef := corev1.TypedLocalObjectReference{
+		APIGroup: ptr.To(ipamv1alpha1.GroupVersion.Group),
+		Kind:     ipamv1alpha1.NetboxIPPoolKind,
+		Name:     h.pool.GetName(),
+	}
+	addressesInUse, err := poolutil.ListAddressesInUse(ctx, h.Client, h.pool.GetNamespace(), poolTypeRef)
+	if err != nil {
+		return errors.Wrap(err, "failed to list addresses")
+	}
+	for _, a := range addressesInUse {
+		if a.Spec.ClaimRef.Name != h.claim.Name && a.Spec.Address == ipAddress.WithoutPrefixLen().String() {
+			return fmt.Errorf("address '%s' is already in use by claim %s", a.Spec.Address, a.Spec.ClaimRef.Name)
+		}
+	}
+	if owner, ok := h.addresses.reserve(member.pool.Spec.Vrf, ipAddress.WithoutPrefixLen().String(), h.claim, addressesInUse); !ok {
+		return fmt.Errorf("address '%s' is already reserved by claim %s", ipAddress.WithoutPrefixLen().String(), owner)
+	}
+	return nil
+}
+
+// batched returns whether free addresses of the pool are allocated in batches.
+func (h *IPAddressClaimHandler) batched() bool {
+	return h.batcher != nil && h.pool.Spec.AllocationStrategy != ipamv1alpha1.RandomAllocationStrategy
+}
+
+func (h *IPAddressClaimHandler) getNetboxClient(ctx context.Context) (netbox.Client, error) {
+	secret, err := getSecretForPool(ctx, h.Client, h.pool)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get secret")
+	}
+	return getNetboxClient(secret, h.netboxServiceFactory)
+}
+
+func (h *IPAddressClaimHandler) allocationFailed(err error) (*ctrl.Result, error) {
+	conditions.MarkFalse(h.claim,
+		clusterv1.ReadyCondition,
+		ipamv1.AllocationFailedReason,
+		clusterv1.ConditionSeverityError,
+		"could not allocate address: %s", err)
+	return &ctrl.Result{}, fmt.Errorf("unable to ensure address: %w", err)
+}
+
 // GetPool returns local pool.
 func (h *IPAddressClaimHandler) GetPool() client.Object {
 	return h.pool
@@ -0,0 +1,1406 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/index"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+	nbmock "github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox/mock"
+)
+
+var _ = Describe("IPAddressClaimHandler", func() {
+	const namespace = "test-namespace"
+
+	var (
+		mockCtrl   *gomock.Controller
+		netboxMock *nbmock.MockClient
+		pool       *ipamv1alpha1.NetboxIPPool
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		netboxMock = nbmock.NewMockClient(mockCtrl)
+		pool = &ipamv1alpha1.NetboxIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Namespace: namespace},
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{
+				Type:           ipamv1alpha1.PrefixType,
+				CIDR:           "10.0.0.0/24",
+				Gateway:        "10.0.0.1",
+				CredentialsRef: &corev1.SecretReference{Name: "netbox-credentials"},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	newHandler := func(claim *ipamv1.IPAddressClaim, objs ...client.Object) *IPAddressClaimHandler {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "netbox-credentials", Namespace: namespace},
+			Data: map[string][]byte{
+				UrlKey:      []byte("http://netbox.local"),
+				ApiTokenKey: []byte("token"),
+			},
+		}
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(append(objs, secret)...).
+			WithIndex(&ipamv1.IPAddress{}, index.IPAddressPoolRefCombinedField, index.IPAddressByCombinedPoolRef).
+			WithStatusSubresource(&ipamv1alpha1.NetboxIPPool{}).
+			Build()
+
+		return &IPAddressClaimHandler{
+			Client: fakeClient,
+			claim:  claim,
+			pool:   pool,
+			netboxServiceFactory: func(_, _, _ string) (netbox.Client, error) {
+				return netboxMock, nil
+			},
+			locks:     &poolLocks{},
+			quotas:    &quotaReservations{},
+			addresses: &addressReservations{},
+		}
+	}
+
+	Describe("adopting addresses", func() {
+		BeforeEach(func() {
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+		})
+
+		It("adopts the address requested by the claim", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.20"))
+			Expect(address.Spec.Prefix).To(Equal(24))
+			Expect(address.Spec.Gateway).To(Equal("10.0.0.1"))
+		})
+
+		It("does not adopt an address outside of the pool", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.1.20"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.1.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.1.20/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("is not part of pool")))
+			Expect(address.Spec.Address).To(BeEmpty())
+		})
+
+		It("does not adopt an address owned by another claim", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim, newTestIPAddress("other", "10.0.0.20", pool)).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("already in use by claim other")))
+		})
+
+		It("does not adopt an address another claim adopted before its IPAddress exists", func() {
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil).Times(2)
+
+			addresses := &addressReservations{}
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			handler := newHandler(claim)
+			handler.addresses = addresses
+			_, err := handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+			Expect(err).ToNot(HaveOccurred())
+
+			other := newTestClaim("other", namespace, pool.Name)
+			other.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			handler = newHandler(other)
+			handler.addresses = addresses
+			address := ipamv1.IPAddress{}
+			_, err = handler.EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("already reserved by claim test-namespace/test")))
+			Expect(address.Spec.Address).To(BeEmpty())
+		})
+
+		It("adopts an address reserved by a claim deleted before its IPAddress exists", func() {
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil).Times(2)
+
+			addresses := &addressReservations{}
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			handler := newHandler(claim)
+			handler.addresses = addresses
+			_, err := handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = handler.ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			other := newTestClaim("other", namespace, pool.Name)
+			other.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			handler = newHandler(other)
+			handler.addresses = addresses
+			address := ipamv1.IPAddress{}
+			_, err = handler.EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.20"))
+		})
+
+		It("does not adopt a quarantined address", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24", CustomFields: map[string]any{netbox.QuarantinedCustomField: "2026-01-02T03:04:05Z"}}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("is quarantined")))
+			Expect(address.Spec.Address).To(BeEmpty())
+		})
+
+		It("adopts the address matching the claim name when the pool adopts existing addresses", func() {
+			pool.Spec.AdoptExisting = true
+			claim := newTestClaim("test", namespace, pool.Name)
+			netboxMock.EXPECT().FindIPAddresses(gomock.Any(), "test", "").
+				Return([]netbox.IPAddress{{Id: 30, Address: "10.0.0.30/24", Description: "test"}}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.30"))
+		})
+	})
+
+	Describe("requesting a static address", func() {
+		BeforeEach(func() {
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+		})
+
+		It("creates the requested address in Netbox", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "10.0.0.53"}
+			gomock.InOrder(
+				netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.53", "").Return(nil, nil),
+				netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.53/24", "", netbox.IPAddressAttributes{Description: "requested by IPAddressClaim test-namespace/test"}).
+					Return(&netbox.IPAddress{Id: 53, Address: "10.0.0.53/24"}, nil),
+			)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.53"))
+			Expect(address.Spec.Prefix).To(Equal(24))
+		})
+
+		It("fails when the requested address is taken", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "10.0.0.53"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.53", "").
+				Return(&netbox.IPAddress{Id: 53, Address: "10.0.0.53/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("already taken")))
+			Expect(conditions.IsFalse(claim, clusterv1.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(claim, clusterv1.ReadyCondition)).To(Equal(ipamv1.AllocationFailedReason))
+		})
+
+		It("takes the address created for the claim before its IPAddress existed as allocated", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "10.0.0.53"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.53", "").Return(&netbox.IPAddress{
+				Id:          53,
+				Address:     "10.0.0.53/24",
+				Description: "requested by IPAddressClaim test-namespace/test",
+			}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.53"))
+			Expect(address.Spec.Prefix).To(Equal(24))
+		})
+
+		It("fails when the requested address was created for a claim of another namespace", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "10.0.0.53"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.53", "").Return(&netbox.IPAddress{
+				Id:          53,
+				Address:     "10.0.0.53/24",
+				Description: "requested by IPAddressClaim other-namespace/test",
+			}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("already taken")))
+		})
+
+		It("fails when the requested address is quarantined", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "10.0.0.53"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.53", "").
+				Return(&netbox.IPAddress{Id: 53, Address: "10.0.0.53/24", CustomFields: map[string]any{netbox.QuarantinedCustomField: "2026-01-02T03:04:05Z"}}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("is quarantined")))
+		})
+
+		DescribeTable("rejects requested addresses that can not be allocated",
+			func(requested string, expectedError string) {
+				pool.Spec.Exclude = []string{"10.0.0.2"}
+				claim := newTestClaim("test", namespace, pool.Name)
+				claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: requested}
+
+				address := ipamv1.IPAddress{}
+				_, err := newHandler(claim).EnsureAddress(ctx, &address)
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			},
+			Entry("outside of the pool", "10.0.1.53", "is not part of pool"),
+			Entry("the gateway", "10.0.0.1", "is the gateway of the pool"),
+			Entry("excluded from the pool", "10.0.0.2", "is excluded from the pool"),
+			Entry("not an address", "10.0.0.300", "invalid requested address"),
+		)
+	})
+
+	Describe("allocating the next free address", func() {
+		BeforeEach(func() {
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+		})
+
+		It("skips the gateway and excluded addresses", func() {
+			pool.Spec.Exclude = []string{"10.0.0.2-10.0.0.3"}
+			claim := newTestClaim("test", namespace, pool.Name)
+			available := []*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("10.0.0.1/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.4/24").GetAddress(),
+			}
+			gomock.InOrder(
+				netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 4).Return(available, nil),
+				netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.4/24", "", netbox.IPAddressAttributes{}).
+					Return(&netbox.IPAddress{Id: 4, Address: "10.0.0.4/24"}, nil),
+			)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.4"))
+			Expect(address.Spec.Prefix).To(Equal(24))
+		})
+
+		It("fails when the pool has no usable address left", func() {
+			pool.Spec.Exclude = []string{"10.0.0.2"}
+			claim := newTestClaim("test", namespace, pool.Name)
+			available := []*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("10.0.0.1/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress(),
+			}
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 3).Return(available, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("no free address available")))
+		})
+
+		It("picks another free address when the address was taken in Netbox in the meantime", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			gomock.InOrder(
+				netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return([]*ipaddr.IPAddress{
+					ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress(),
+				}, nil),
+				netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.2/24", "", netbox.IPAddressAttributes{}).
+					Return(nil, fmt.Errorf("could not create ip-address '10.0.0.2/24': %w", netbox.ErrDuplicateIPAddress)),
+				netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return([]*ipaddr.IPAddress{
+					ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress(),
+				}, nil),
+				netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.3/24", "", netbox.IPAddressAttributes{}).
+					Return(&netbox.IPAddress{Id: 3, Address: "10.0.0.3/24"}, nil),
+			)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.3"))
+		})
+
+		It("gives up when the free addresses keep being taken in Netbox", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return([]*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress(),
+			}, nil).Times(netbox.AllocationAttempts)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.2/24", "", netbox.IPAddressAttributes{}).
+				Return(nil, fmt.Errorf("could not create ip-address '10.0.0.2/24': %w", netbox.ErrDuplicateIPAddress)).
+				Times(netbox.AllocationAttempts)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(netbox.ErrDuplicateIPAddress))
+			Expect(address.Spec.Address).To(BeEmpty())
+		})
+
+		It("allocates claims of different pools sharing a prefix one at a time", func() {
+			other := pool.DeepCopy()
+			other.Name = "other-pool"
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+
+			var mu sync.Mutex
+			allocating := 0
+			next := 2
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).
+				DoAndReturn(func(context.Context, *netbox.NetboxIPPool, int) ([]*ipaddr.IPAddress, error) {
+					mu.Lock()
+					allocating++
+					Expect(allocating).To(Equal(1), "claims of the same prefix are allocated in parallel")
+					free := ipaddr.NewIPAddressString(fmt.Sprintf("10.0.0.%d/24", next)).GetAddress()
+					mu.Unlock()
+					time.Sleep(50 * time.Millisecond)
+					return []*ipaddr.IPAddress{free}, nil
+				}).Times(2)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), gomock.Any(), "", netbox.IPAddressAttributes{}).
+				DoAndReturn(func(_ context.Context, address string, _ string, _ netbox.IPAddressAttributes) (*netbox.IPAddress, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					allocating--
+					next++
+					return &netbox.IPAddress{Address: address}, nil
+				}).Times(2)
+
+			locks := &poolLocks{}
+			results := make(chan string, 2)
+			for _, p := range []*ipamv1alpha1.NetboxIPPool{pool, other} {
+				handler := newHandler(newTestClaim("test", namespace, p.Name))
+				handler.pool = p
+				handler.locks = locks
+				go func() {
+					defer GinkgoRecover()
+					address := ipamv1.IPAddress{}
+					_, err := handler.EnsureAddress(ctx, &address)
+					Expect(err).ToNot(HaveOccurred())
+					results <- address.Spec.Address
+				}()
+			}
+			Expect([]string{<-results, <-results}).To(ConsistOf("10.0.0.2", "10.0.0.3"))
+		})
+	})
+
+	Describe("dual-stack pools", func() {
+		BeforeEach(func() {
+			pool.Spec.Exclude = []string{"10.0.0.2", "2001:db8::2"}
+			pool.Spec.DualStack = &ipamv1alpha1.NetboxPoolReference{
+				Type:    ipamv1alpha1.PrefixType,
+				CIDR:    "2001:db8::/64",
+				Vrf:     "v6",
+				Gateway: "2001:db8::1",
+			}
+		})
+
+		It("allocates from the prefix of the family selected by the claim", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.IPFamilyAnnotation: "IPv6"}
+			available := []*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("2001:db8::1/64").GetAddress(),
+				ipaddr.NewIPAddressString("2001:db8::2/64").GetAddress(),
+				ipaddr.NewIPAddressString("2001:db8::3/64").GetAddress(),
+			}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "2001:db8::/64", "v6").Return(newNetboxPrefix("2001:db8::/64"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 3).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "2001:db8::3/64", "v6", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 3, Address: "2001:db8::3/64"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("2001:db8::3"))
+			Expect(address.Spec.Prefix).To(Equal(64))
+			Expect(address.Spec.Gateway).To(Equal("2001:db8::1"))
+		})
+
+		It("allocates from the family of the requested address", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "2001:db8::53"}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "2001:db8::/64", "v6").Return(newNetboxPrefix("2001:db8::/64"), nil)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "2001:db8::53", "v6").Return(nil, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "2001:db8::53/64", "v6", netbox.IPAddressAttributes{Description: "requested by IPAddressClaim test-namespace/test"}).
+				Return(&netbox.IPAddress{Id: 53, Address: "2001:db8::53/64"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("2001:db8::53"))
+		})
+
+		It("rejects an invalid ip family", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.IPFamilyAnnotation: "IPv5"}
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("invalid ip family")))
+		})
+
+		It("releases the address in the vrf of its family", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "2001:db8::3", "v6").
+				Return(&netbox.IPAddress{Id: 3, Address: "2001:db8::3/64"}, nil)
+			netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 3).Return(nil)
+
+			_, err := newHandler(claim, newTestIPAddress("test", "2001:db8::3", pool)).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	It("fails to allocate a family the pool does not have", func() {
+		claim := newTestClaim("test", namespace, pool.Name)
+		claim.Annotations = map[string]string{ipamv1alpha1.IPFamilyAnnotation: "IPv6"}
+
+		address := ipamv1.IPAddress{}
+		_, err := newHandler(claim).EnsureAddress(ctx, &address)
+		Expect(err).To(MatchError(ContainSubstring("has no IPv6 addresses")))
+	})
+
+	Describe("pools with fallback members", func() {
+		BeforeEach(func() {
+			pool.Spec.Fallback = []ipamv1alpha1.NetboxPoolReference{
+				{Type: ipamv1alpha1.PrefixType, CIDR: "10.0.1.0/24", Vrf: "fallback", Gateway: "10.0.1.1"},
+			}
+		})
+
+		expectMembers := func() {
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			fallbackPrefix := newNetboxPrefix("10.0.1.0/24")
+			fallbackPrefix.Id = 2
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.1.0/24", "fallback").Return(fallbackPrefix, nil)
+		}
+
+		It("falls through to the next member when a member is exhausted", func() {
+			expectMembers()
+			claim := newTestClaim("test", namespace, pool.Name)
+			available := []*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("10.0.1.1/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.1.2/24").GetAddress(),
+			}
+			gomock.InOrder(
+				netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), netboxPoolWithId(1), 2).Return(nil, nil),
+				netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), netboxPoolWithId(2), 2).Return(available, nil),
+				netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.1.2/24", "fallback", netbox.IPAddressAttributes{}).
+					Return(&netbox.IPAddress{Id: 12, Address: "10.0.1.2/24"}, nil),
+			)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.1.2"))
+			Expect(address.Spec.Gateway).To(Equal("10.0.1.1"))
+		})
+
+		It("fails when all members are exhausted", func() {
+			expectMembers()
+			claim := newTestClaim("test", namespace, pool.Name)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(nil, nil).Times(2)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("no free address available in pool test-pool")))
+		})
+
+		It("creates a requested address in the member containing it", func() {
+			expectMembers()
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "10.0.1.53"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.1.53", "fallback").Return(nil, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.1.53/24", "fallback", netbox.IPAddressAttributes{Description: "requested by IPAddressClaim test-namespace/test"}).
+				Return(&netbox.IPAddress{Id: 53, Address: "10.0.1.53/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.1.53"))
+			Expect(address.Spec.Gateway).To(Equal("10.0.1.1"))
+		})
+
+		It("releases the address in the vrf of its member", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.1.20", "fallback").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.1.20/24"}, nil)
+			netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 20).Return(nil)
+
+			_, err := newHandler(claim, newTestIPAddress("test", "10.0.1.20", pool)).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("pools discovering their gateway", func() {
+		BeforeEach(func() {
+			pool.Spec.Gateway = ""
+			pool.Spec.DiscoverGateway = true
+		})
+
+		It("fills in the gateway discovered by the pool reconciler", func() {
+			pool.Status.Members = []ipamv1alpha1.NetboxPoolStatusMember{{CIDR: "10.0.0.0/24", Gateway: "10.0.0.254"}}
+			claim := newTestClaim("test", namespace, pool.Name)
+			available := []*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("10.0.0.254/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.1/24").GetAddress(),
+			}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.1/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 1, Address: "10.0.0.1/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.1"))
+			Expect(address.Spec.Gateway).To(Equal("10.0.0.254"))
+		})
+
+		It("waits for the gateway to be discovered", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("has not been discovered yet")))
+			Expect(address.Spec.Address).To(BeEmpty())
+		})
+	})
+
+	Describe("dns names", func() {
+		var claim *ipamv1.IPAddressClaim
+
+		BeforeEach(func() {
+			pool.Spec.DNSNamePattern = "{{.Machine}}.{{.Cluster}}.example.com"
+			claim = newTestClaim("test", namespace, pool.Name)
+			claim.Spec.ClusterName = "cluster"
+			claim.OwnerReferences = []metav1.OwnerReference{
+				{APIVersion: clusterv1.GroupVersion.String(), Kind: "Machine", Name: "machine-0"},
+			}
+		})
+
+		It("creates the address with the dns name of the pattern", func() {
+			available := []*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress()}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.2/24", "", netbox.IPAddressAttributes{DnsName: "machine-0.cluster.example.com"}).
+				Return(&netbox.IPAddress{Id: 2, Address: "10.0.0.2/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.2"))
+			Expect(address.Annotations).To(HaveKeyWithValue(ipamv1alpha1.FQDNAnnotation, "machine-0.cluster.example.com"))
+		})
+
+		It("sets the dns name of an adopted address", func() {
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24", DnsName: "old.example.com"}, nil).Times(2)
+			netboxMock.EXPECT().UpdateIPAddressDnsName(gomock.Any(), 20, "machine-0.cluster.example.com").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.20"))
+			Expect(address.Annotations).To(HaveKeyWithValue(ipamv1alpha1.FQDNAnnotation, "machine-0.cluster.example.com"))
+		})
+
+		It("updates the dns name when the owning machine changes", func() {
+			address := newTestIPAddress("test", "10.0.0.20", pool)
+			address.Annotations = map[string]string{ipamv1alpha1.FQDNAnnotation: "machine-0.cluster.example.com"}
+			claim.OwnerReferences[0].Name = "machine-1"
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24", DnsName: "machine-0.cluster.example.com"}, nil)
+			netboxMock.EXPECT().UpdateIPAddressDnsName(gomock.Any(), 20, "machine-1.cluster.example.com").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			_, err := newHandler(claim).EnsureAddress(ctx, address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Annotations).To(HaveKeyWithValue(ipamv1alpha1.FQDNAnnotation, "machine-1.cluster.example.com"))
+		})
+
+		It("does not call Netbox when the dns name is up to date", func() {
+			address := newTestIPAddress("test", "10.0.0.20", pool)
+			address.Annotations = map[string]string{ipamv1alpha1.FQDNAnnotation: "machine-0.cluster.example.com"}
+
+			_, err := newHandler(claim).EnsureAddress(ctx, address)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("clears the dns name when the pool has no pattern anymore", func() {
+			pool.Spec.DNSNamePattern = ""
+			address := newTestIPAddress("test", "10.0.0.20", pool)
+			address.Annotations = map[string]string{ipamv1alpha1.FQDNAnnotation: "machine-0.cluster.example.com"}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24", DnsName: "machine-0.cluster.example.com"}, nil)
+			netboxMock.EXPECT().UpdateIPAddressDnsName(gomock.Any(), 20, "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			_, err := newHandler(claim).EnsureAddress(ctx, address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Annotations).ToNot(HaveKey(ipamv1alpha1.FQDNAnnotation))
+		})
+
+		It("does not allocate when the dns name can not be rendered", func() {
+			claim.OwnerReferences = nil
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("is not valid")))
+			Expect(address.Spec.Address).To(BeEmpty())
+		})
+	})
+
+	Describe("assigning addresses to virtual machine interfaces", func() {
+		var claim *ipamv1.IPAddressClaim
+
+		BeforeEach(func() {
+			pool.Spec.VMInterface = &ipamv1alpha1.VMInterfaceAssignment{MatchBy: ipamv1alpha1.NameMatchStrategy}
+			claim = newTestClaim("test", namespace, pool.Name)
+			claim.Spec.ClusterName = "cluster"
+			claim.OwnerReferences = []metav1.OwnerReference{
+				{APIVersion: clusterv1.GroupVersion.String(), Kind: "Machine", Name: "machine-0", UID: "machine-uid"},
+			}
+		})
+
+		expectAllocation := func(attributes netbox.IPAddressAttributes) {
+			available := []*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress()}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.2/24", "", attributes).
+				Return(&netbox.IPAddress{Id: 2, Address: "10.0.0.2/24"}, nil)
+		}
+
+		It("assigns the address to the interface of the virtual machine named after the machine", func() {
+			netboxMock.EXPECT().FindVirtualMachine(gomock.Any(), "machine-0").
+				Return(&netbox.VirtualMachine{Id: 3, Name: "machine-0"}, nil)
+			netboxMock.EXPECT().FindVMInterface(gomock.Any(), 3, ipamv1alpha1.DefaultVMInterface).
+				Return(&netbox.VMInterface{Id: 4, Name: ipamv1alpha1.DefaultVMInterface}, nil)
+			expectAllocation(netbox.IPAddressAttributes{VMInterfaceId: 4})
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.2"))
+		})
+
+		It("creates the virtual machine and its interface when configured", func() {
+			pool.Spec.VMInterface = &ipamv1alpha1.VMInterfaceAssignment{
+				MatchBy:     ipamv1alpha1.CustomFieldMatchStrategy,
+				CustomField: "machine_uid",
+				Interface:   "ens192",
+				Create:      true,
+			}
+			netboxMock.EXPECT().FindVirtualMachineByCustomField(gomock.Any(), "machine_uid", "machine-uid").Return(nil, nil)
+			netboxMock.EXPECT().CreateVirtualMachine(gomock.Any(), &netbox.VirtualMachineRequest{
+				Name:         "machine-0",
+				Cluster:      &netbox.Cluster{Name: "cluster"},
+				CustomFields: map[string]any{"machine_uid": "machine-uid"},
+			}).Return(&netbox.VirtualMachine{Id: 3, Name: "machine-0"}, nil)
+			netboxMock.EXPECT().FindVMInterface(gomock.Any(), 3, "ens192").Return(nil, nil)
+			netboxMock.EXPECT().CreateVMInterface(gomock.Any(), 3, "ens192").
+				Return(&netbox.VMInterface{Id: 4, Name: "ens192"}, nil)
+			expectAllocation(netbox.IPAddressAttributes{VMInterfaceId: 4})
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.2"))
+		})
+
+		It("does not allocate when the virtual machine does not exist", func() {
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().FindVirtualMachine(gomock.Any(), "machine-0").Return(nil, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("does not exist in Netbox")))
+			Expect(address.Spec.Address).To(BeEmpty())
+		})
+
+		It("assigns an adopted address to the interface", func() {
+			claim.Annotations = map[string]string{ipamv1alpha1.AdoptAddressAnnotation: "10.0.0.20"}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().FindVirtualMachine(gomock.Any(), "machine-0").
+				Return(&netbox.VirtualMachine{Id: 3, Name: "machine-0"}, nil)
+			netboxMock.EXPECT().FindVMInterface(gomock.Any(), 3, ipamv1alpha1.DefaultVMInterface).
+				Return(&netbox.VMInterface{Id: 4, Name: ipamv1alpha1.DefaultVMInterface}, nil)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil).Times(2)
+			netboxMock.EXPECT().UpdateIPAddressAssignment(gomock.Any(), 20, 4).
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.20"))
+		})
+
+		It("unassigns the address before deleting it on release", func() {
+			gomock.InOrder(
+				netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").
+					Return(&netbox.IPAddress{Id: 2, Address: "10.0.0.2/24", AssignedObjectType: netbox.VMInterfaceObjectType, AssignedObjectId: ptr.To(4)}, nil),
+				netboxMock.EXPECT().UpdateIPAddressAssignment(gomock.Any(), 2, 0).
+					Return(&netbox.IPAddress{Id: 2, Address: "10.0.0.2/24"}, nil),
+				netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 2).Return(nil),
+			)
+
+			_, err := newHandler(claim, newTestIPAddress("test", "10.0.0.2", pool)).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("marking addresses as primary address", func() {
+		var (
+			claim    *ipamv1.IPAddressClaim
+			assigned *netbox.IPAddress
+			host     *netbox.Host
+		)
+
+		BeforeEach(func() {
+			claim = newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.PrimaryAddressAnnotation: "true"}
+			assigned = &netbox.IPAddress{Id: 2, Address: "10.0.0.2/24", AssignedObjectType: netbox.VMInterfaceObjectType, AssignedObjectId: ptr.To(4)}
+			host = &netbox.Host{Type: netbox.VirtualMachineHostType, Id: 3, Display: "machine-0", PrimaryIp4: 9}
+		})
+
+		It("marks the address as primary and records the previous primary address", func() {
+			address := newTestIPAddress("test", "10.0.0.2", pool)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").Return(assigned, nil)
+			netboxMock.EXPECT().GetIPAddressHost(gomock.Any(), assigned).Return(host, nil)
+			netboxMock.EXPECT().SetPrimaryIPAddress(gomock.Any(), host, ipaddr.IPv4, 2).Return(nil)
+
+			_, err := newHandler(claim).EnsureAddress(ctx, address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Annotations).To(HaveKeyWithValue(ipamv1alpha1.PreviousPrimaryAnnotation, "9"))
+		})
+
+		It("marks the address as primary when the pool requests it", func() {
+			claim.Annotations = nil
+			pool.Spec.PrimaryAddress = true
+			host.PrimaryIp4 = 0
+			address := newTestIPAddress("test", "10.0.0.2", pool)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").Return(assigned, nil)
+			netboxMock.EXPECT().GetIPAddressHost(gomock.Any(), assigned).Return(host, nil)
+			netboxMock.EXPECT().SetPrimaryIPAddress(gomock.Any(), host, ipaddr.IPv4, 2).Return(nil)
+
+			_, err := newHandler(claim).EnsureAddress(ctx, address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Annotations).To(HaveKeyWithValue(ipamv1alpha1.PreviousPrimaryAnnotation, "0"))
+		})
+
+		It("fails when the address is not assigned to a host", func() {
+			address := newTestIPAddress("test", "10.0.0.2", pool)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").Return(&netbox.IPAddress{Id: 2, Address: "10.0.0.2/24"}, nil)
+			netboxMock.EXPECT().GetIPAddressHost(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+			_, err := newHandler(claim).EnsureAddress(ctx, address)
+			Expect(err).To(MatchError(ContainSubstring("is not assigned to a device or virtual machine")))
+			Expect(address.Annotations).ToNot(HaveKey(ipamv1alpha1.PreviousPrimaryAnnotation))
+		})
+
+		It("does not call Netbox when the address was marked before", func() {
+			address := newTestIPAddress("test", "10.0.0.2", pool)
+			address.Annotations = map[string]string{ipamv1alpha1.PreviousPrimaryAnnotation: "9"}
+
+			_, err := newHandler(claim).EnsureAddress(ctx, address)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("restores the previous primary address on release", func() {
+			address := newTestIPAddress("test", "10.0.0.2", pool)
+			address.Annotations = map[string]string{ipamv1alpha1.PreviousPrimaryAnnotation: "9"}
+			host.PrimaryIp4 = 2
+			gomock.InOrder(
+				netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").Return(assigned, nil),
+				netboxMock.EXPECT().GetIPAddressHost(gomock.Any(), assigned).Return(host, nil),
+				netboxMock.EXPECT().SetPrimaryIPAddress(gomock.Any(), host, ipaddr.IPv4, 9).Return(nil),
+				netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 2).Return(nil),
+			)
+
+			_, err := newHandler(claim, address).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("clears the primary address on release when the previous one can not be restored", func() {
+			address := newTestIPAddress("test", "10.0.0.2", pool)
+			address.Annotations = map[string]string{ipamv1alpha1.PreviousPrimaryAnnotation: "9"}
+			host.PrimaryIp4 = 2
+			gomock.InOrder(
+				netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").Return(assigned, nil),
+				netboxMock.EXPECT().GetIPAddressHost(gomock.Any(), assigned).Return(host, nil),
+				netboxMock.EXPECT().SetPrimaryIPAddress(gomock.Any(), host, ipaddr.IPv4, 9).Return(fmt.Errorf("not found")),
+				netboxMock.EXPECT().SetPrimaryIPAddress(gomock.Any(), host, ipaddr.IPv4, 0).Return(nil),
+				netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 2).Return(nil),
+			)
+
+			_, err := newHandler(claim, address).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("leaves the primary address alone on release when it was changed in Netbox", func() {
+			address := newTestIPAddress("test", "10.0.0.2", pool)
+			address.Annotations = map[string]string{ipamv1alpha1.PreviousPrimaryAnnotation: "9"}
+			host.PrimaryIp4 = 11
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.2", "").Return(assigned, nil)
+			netboxMock.EXPECT().GetIPAddressHost(gomock.Any(), assigned).Return(host, nil)
+			netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 2).Return(nil)
+
+			_, err := newHandler(claim, address).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("address status", func() {
+		It("creates the address with the status of the pool", func() {
+			pool.Spec.Status = ipamv1alpha1.ReservedAddressStatus
+			claim := newTestClaim("test", namespace, pool.Name)
+			available := []*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress()}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.2/24", "", netbox.IPAddressAttributes{Status: "reserved"}).
+				Return(&netbox.IPAddress{Id: 2, Address: "10.0.0.2/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.2"))
+		})
+
+		It("quarantines the address on release instead of deleting it", func() {
+			pool.Spec.Quarantine = &ipamv1alpha1.AddressQuarantine{Period: metav1.Duration{Duration: time.Hour}}
+			claim := newTestClaim("test", namespace, pool.Name)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+			netboxMock.EXPECT().QuarantineIPAddress(gomock.Any(), 20, "deprecated", gomock.Any()).
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			_, err := newHandler(claim, newTestIPAddress("test", "10.0.0.20", pool)).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("allocation strategies", func() {
+		var claim *ipamv1.IPAddressClaim
+
+		BeforeEach(func() {
+			claim = newTestClaim("test", namespace, pool.Name)
+			claim.OwnerReferences = []metav1.OwnerReference{
+				{APIVersion: clusterv1.GroupVersion.String(), Kind: "Machine", Name: "machine-0"},
+			}
+		})
+
+		It("allocates a random free address", func() {
+			pool.Spec.AllocationStrategy = ipamv1alpha1.RandomAllocationStrategy
+			available := []*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("10.0.0.1/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.5/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.9/24").GetAddress(),
+			}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), netbox.MaxAvailableAddresses).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), gomock.Any(), "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 2}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(BeElementOf("10.0.0.5", "10.0.0.9"))
+		})
+
+		It("allocates the address last held by a machine with the same name", func() {
+			pool.Spec.AllocationStrategy = ipamv1alpha1.StickyAllocationStrategy
+			pool.Status.StickyAddresses = []ipamv1alpha1.StickyAddress{
+				{Machine: "machine-0", Address: "10.0.0.20"},
+				{Machine: "machine-1", Address: "10.0.0.21"},
+			}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").Return(nil, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.20/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.20"))
+		})
+
+		It("allocates the lowest free address when the sticky address is taken", func() {
+			pool.Spec.AllocationStrategy = ipamv1alpha1.StickyAllocationStrategy
+			pool.Status.StickyAddresses = []ipamv1alpha1.StickyAddress{{Machine: "machine-0", Address: "10.0.0.20"}}
+			available := []*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress()}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.2/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 2, Address: "10.0.0.2/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.2"))
+		})
+
+		It("records the address of the machine on release", func() {
+			pool.Spec.AllocationStrategy = ipamv1alpha1.StickyAllocationStrategy
+			pool.Status.StickyAddresses = []ipamv1alpha1.StickyAddress{
+				{Machine: "machine-0", Address: "10.0.0.30"},
+				{Machine: "machine-1", Address: "10.0.0.21"},
+			}
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+			netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 20).Return(nil)
+
+			handler := newHandler(claim, pool, newTestIPAddress("test", "10.0.0.20", pool))
+			_, err := handler.ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			updated := &ipamv1alpha1.NetboxIPPool{}
+			Expect(handler.Client.Get(ctx, client.ObjectKeyFromObject(pool), updated)).To(Succeed())
+			Expect(updated.Status.StickyAddresses).To(Equal([]ipamv1alpha1.StickyAddress{
+				{Machine: "machine-1", Address: "10.0.0.21"},
+				{Machine: "machine-0", Address: "10.0.0.20"},
+			}))
+		})
+	})
+
+	Describe("batched allocation", func() {
+		It("allocates the addresses of claims of the same pool with one request", func() {
+			available := []*ipaddr.IPAddress{
+				ipaddr.NewIPAddressString("10.0.0.1/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.2/24").GetAddress(),
+				ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress(),
+			}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil).Times(2)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 4).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.2/24", "10.0.0.3/24"}, "", gomock.Any()).
+				Return(make([]netbox.IPAddress, 2), nil)
+
+			batcher := netbox.NewAddressBatcher(100 * time.Millisecond)
+			locks := &poolLocks{}
+			results := make(chan string, 2)
+			for _, name := range []string{"test-0", "test-1"} {
+				handler := newHandler(newTestClaim(name, namespace, pool.Name))
+				handler.batcher = batcher
+				handler.locks = locks
+				go func() {
+					defer GinkgoRecover()
+					address := ipamv1.IPAddress{}
+					_, err := handler.EnsureAddress(ctx, &address)
+					Expect(err).ToNot(HaveOccurred())
+					results <- address.Spec.Address
+				}()
+			}
+			Expect([]string{<-results, <-results}).To(ConsistOf("10.0.0.2", "10.0.0.3"))
+		})
+	})
+
+	It("fails claims of a pool with an unsupported Netbox version", func() {
+		conditions.MarkFalse(pool, ipamv1alpha1.NetboxVersionSupportedCondition, ipamv1alpha1.UnsupportedVersionReason,
+			clusterv1.ConditionSeverityError, "unsupported Netbox version 2.11.0, supported are 3.5 up to 4.x")
+		claim := newTestClaim("test", namespace, pool.Name)
+
+		address := ipamv1.IPAddress{}
+		_, err := newHandler(claim).EnsureAddress(ctx, &address)
+		Expect(err).To(MatchError(ContainSubstring("unsupported Netbox version 2.11.0")))
+		Expect(conditions.GetReason(claim, clusterv1.ReadyCondition)).To(Equal(ipamv1.AllocationFailedReason))
+	})
+
+	Describe("quotas", func() {
+		var claim *ipamv1.IPAddressClaim
+
+		clusterAddress := func(name string, address string, cluster string) *ipamv1.IPAddress {
+			ipAddress := newTestIPAddress(name, address, pool)
+			ipAddress.Labels = map[string]string{clusterv1.ClusterNameLabel: cluster}
+			return ipAddress
+		}
+
+		BeforeEach(func() {
+			claim = newTestClaim("test", namespace, pool.Name)
+			claim.Labels = map[string]string{clusterv1.ClusterNameLabel: "cluster-a"}
+		})
+
+		It("fails claims of a cluster that reached its quota", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerCluster: ptr.To(1)}
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim, clusterAddress("other", "10.0.0.2", "cluster-a")).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("quota exceeded: cluster cluster-a already uses 1 of 1 addresses")))
+			Expect(conditions.IsFalse(claim, clusterv1.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(claim, clusterv1.ReadyCondition)).To(Equal(ipamv1.AllocationFailedReason))
+			Expect(conditions.GetMessage(claim, clusterv1.ReadyCondition)).To(ContainSubstring("quota exceeded"))
+		})
+
+		It("allocates addresses of a cluster below its quota", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerCluster: ptr.To(1)}
+			available := []*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress()}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.3/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 3, Address: "10.0.0.3/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim, clusterAddress("other", "10.0.0.2", "cluster-b")).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.3"))
+		})
+
+		It("fails claims of a namespace that reached its quota", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerNamespace: ptr.To(2)}
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim,
+				clusterAddress("other-0", "10.0.0.2", "cluster-b"),
+				newTestIPAddress("other-1", "10.0.0.3", pool),
+			).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("quota exceeded: namespace test-namespace already uses 2 of 2 addresses")))
+		})
+
+		It("counts the addresses of the pools of the namespace sharing the prefix", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerNamespace: ptr.To(2)}
+			sharing := pool.DeepCopy()
+			sharing.Name = "sharing-pool"
+			sharing.Spec.Quota = nil
+			separate := pool.DeepCopy()
+			separate.Name = "separate-pool"
+			separate.Spec.CIDR = "10.0.1.0/24"
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim, sharing, separate,
+				newTestIPAddress("other-0", "10.0.0.2", pool),
+				newTestIPAddress("other-1", "10.0.0.3", sharing),
+				newTestIPAddress("other-2", "10.0.1.2", separate),
+			).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("quota exceeded: namespace test-namespace already uses 2 of 2 addresses")))
+		})
+
+		It("does not count the addresses of the pools of the namespace with another prefix", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerNamespace: ptr.To(2)}
+			separate := pool.DeepCopy()
+			separate.Name = "separate-pool"
+			separate.Spec.CIDR = "10.0.1.0/24"
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).
+				Return([]*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress()}, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.3/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 3, Address: "10.0.0.3/24"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim, separate,
+				newTestIPAddress("other-0", "10.0.0.2", pool),
+				newTestIPAddress("other-1", "10.0.1.2", separate),
+			).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.3"))
+		})
+
+		It("does not exceed the quota with claims allocated in parallel", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerCluster: ptr.To(1)}
+			available := []*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress()}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).Return(available, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.3/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 3, Address: "10.0.0.3/24"}, nil)
+
+			locks, quotas := &poolLocks{}, &quotaReservations{}
+			errs := make(chan error, 2)
+			for _, name := range []string{"test-0", "test-1"} {
+				claim := newTestClaim(name, namespace, pool.Name)
+				claim.Labels = map[string]string{clusterv1.ClusterNameLabel: "cluster-a"}
+				handler := newHandler(claim)
+				handler.locks = locks
+				handler.quotas = quotas
+				go func() {
+					defer GinkgoRecover()
+					_, err := handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+					errs <- err
+				}()
+			}
+			var results []error
+			for range 2 {
+				var err error
+				Eventually(errs).Should(Receive(&err))
+				results = append(results, err)
+			}
+			Expect(results).To(ConsistOf(
+				BeNil(),
+				MatchError(ContainSubstring("quota exceeded: cluster cluster-a already uses 1 of 1 addresses")),
+			))
+		})
+
+		It("counts a claim once when its IPAddress exists", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerNamespace: ptr.To(2)}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil).Times(2)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).
+				Return([]*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress()}, nil)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).
+				Return([]*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.4/24").GetAddress()}, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), gomock.Any(), "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{}, nil).Times(2)
+
+			quotas := &quotaReservations{}
+			handler := newHandler(newTestClaim("test-0", namespace, pool.Name))
+			handler.quotas = quotas
+			address := ipamv1.IPAddress{}
+			_, err := handler.EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+
+			handler = newHandler(newTestClaim("test-1", namespace, pool.Name), newTestIPAddress("test-0", address.Spec.Address, pool))
+			handler.quotas = quotas
+			_, err = handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("releases the reservation of a claim whose allocation failed", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerCluster: ptr.To(1)}
+			gomock.InOrder(
+				netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(nil, errors.New("netbox down")),
+				netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil),
+			)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).
+				Return([]*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress()}, nil)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.3/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 3, Address: "10.0.0.3/24"}, nil)
+
+			quotas := &quotaReservations{}
+			handler := newHandler(claim)
+			handler.quotas = quotas
+			_, err := handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+			Expect(err).To(MatchError(ContainSubstring("netbox down")))
+
+			other := newTestClaim("other", namespace, pool.Name)
+			other.Labels = map[string]string{clusterv1.ClusterNameLabel: "cluster-a"}
+			handler = newHandler(other)
+			handler.quotas = quotas
+			_, err = handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("releases the reservation of a claim deleted before its IPAddress exists", func() {
+			pool.Spec.Quota = &ipamv1alpha1.AddressQuota{PerCluster: ptr.To(1)}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil).Times(2)
+			netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), gomock.Any(), 2).
+				Return([]*ipaddr.IPAddress{ipaddr.NewIPAddressString("10.0.0.3/24").GetAddress()}, nil).Times(2)
+			netboxMock.EXPECT().CreateIPAddress(gomock.Any(), "10.0.0.3/24", "", netbox.IPAddressAttributes{}).
+				Return(&netbox.IPAddress{Id: 3, Address: "10.0.0.3/24"}, nil).Times(2)
+
+			quotas := &quotaReservations{}
+			handler := newHandler(claim)
+			handler.quotas = quotas
+			_, err := handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = handler.ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			other := newTestClaim("other", namespace, pool.Name)
+			other.Labels = map[string]string{clusterv1.ClusterNameLabel: "cluster-a"}
+			handler = newHandler(other)
+			handler.quotas = quotas
+			_, err = handler.EnsureAddress(ctx, &ipamv1.IPAddress{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("allocating child prefixes", func() {
+		BeforeEach(func() {
+			pool.Spec.Gateway = ""
+			pool.Spec.AllocationMode = ipamv1alpha1.PrefixAllocationMode
+			pool.Spec.PrefixLength = 28
+		})
+
+		It("creates the next free child prefix in Netbox", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+			netboxMock.EXPECT().CreateAvailablePrefix(gomock.Any(), gomock.Any(), 28).
+				Return(&netbox.Prefix{Id: 7, Prefix: "10.0.0.16/28"}, nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(address.Spec.Address).To(Equal("10.0.0.16"))
+			Expect(address.Spec.Prefix).To(Equal(28))
+			Expect(address.Spec.Gateway).To(BeEmpty())
+		})
+
+		It("does not support requesting a specific address", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			claim.Annotations = map[string]string{ipamv1alpha1.RequestedAddressAnnotation: "10.0.0.16"}
+			netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+
+			address := ipamv1.IPAddress{}
+			_, err := newHandler(claim).EnsureAddress(ctx, &address)
+			Expect(err).To(MatchError(ContainSubstring("can not be used in Prefix allocation mode")))
+		})
+
+		It("deletes the child prefix in Netbox on release", func() {
+			claim := newTestClaim("test", namespace, pool.Name)
+			ipAddress := newTestIPAddress("test", "10.0.0.16", pool)
+			ipAddress.Spec.Prefix = 28
+			netboxMock.EXPECT().FindPrefix(gomock.Any(), "10.0.0.16/28", "").
+				Return(&netbox.Prefix{Id: 7, Prefix: "10.0.0.16/28"}, nil)
+			netboxMock.EXPECT().DeletePrefix(gomock.Any(), 7).Return(nil)
+
+			_, err := newHandler(claim, ipAddress).ReleaseAddress(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	It("keeps an address that is already allocated", func() {
+		claim := newTestClaim("test", namespace, pool.Name)
+		address := ipamv1.IPAddress{Spec: ipamv1.IPAddressSpec{Address: "10.0.0.40", Prefix: 24}}
+		_, err := newHandler(claim).EnsureAddress(ctx, &address)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(address.Spec.Address).To(Equal("10.0.0.40"))
+	})
+
+	It("deletes the address in Netbox on release", func() {
+		claim := newTestClaim("test", namespace, pool.Name)
+		netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "").
+			Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil)
+		netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 20).Return(nil)
+
+		_, err := newHandler(claim, newTestIPAddress("test", "10.0.0.20", pool)).ReleaseAddress(ctx)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	It("releases an address allocated before the pool moved to another vrf from the previous vrf", func() {
+		pool.Spec.Vrf = "new"
+		pool.Status.Migration = &ipamv1alpha1.NetboxPoolStatusMigration{NetboxId: 1, CIDR: "10.0.0.0/24", Vrf: "old"}
+		claim := newTestClaim("test", namespace, pool.Name)
+		gomock.InOrder(
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "new").Return(nil, nil),
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "old").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil),
+		)
+		netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 20).Return(nil)
+
+		_, err := newHandler(claim, newTestIPAddress("test", "10.0.0.20", pool)).ReleaseAddress(ctx)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("releases an address in range from the previous vrf after the pool only moved to another vrf", func() {
+		address := newTestIPAddress("test", "10.0.0.20", pool)
+		previous := &ipamv1alpha1.NetboxIPPoolStatus{
+			NetboxId:   1,
+			NetboxType: "Prefix",
+			Members:    []ipamv1alpha1.NetboxPoolStatusMember{{CIDR: "10.0.0.0/24", Vrf: "old"}},
+		}
+		pool.Spec.Vrf = "new"
+		pool.Status.NetboxId = 2
+		pool.Status.NetboxType = "Prefix"
+		pool.Status.Members = []ipamv1alpha1.NetboxPoolStatusMember{{CIDR: "10.0.0.0/24", Vrf: "new"}}
+		reconciler := &NetboxIPPoolReconciler{Recorder: record.NewFakeRecorder(10)}
+		reconciler.reconcileMigration(pool, previous, []ipamv1.IPAddress{*address})
+		Expect(pool.Status.OutOfRangeAddresses).To(BeEmpty())
+		Expect(pool.Status.Migration).ToNot(BeNil())
+
+		claim := newTestClaim("test", namespace, pool.Name)
+		gomock.InOrder(
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "new").Return(nil, nil),
+			netboxMock.EXPECT().GetIPAddress(gomock.Any(), "10.0.0.20", "old").
+				Return(&netbox.IPAddress{Id: 20, Address: "10.0.0.20/24"}, nil),
+		)
+		netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 20).Return(nil)
+
+		_, err := newHandler(claim, address).ReleaseAddress(ctx)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
+
+func newTestClaim(name, namespace, poolName string) *ipamv1.IPAddressClaim {
+	claim := newClaim(name, namespace, poolName)
+	return &claim
+}
+
+func newTestIPAddress(name string, address string, pool *ipamv1alpha1.NetboxIPPool) *ipamv1.IPAddress {
+	return &ipamv1.IPAddress{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "IPAddress",
+			APIVersion: ipamv1.GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pool.Namespace,
+		},
+		Spec: ipamv1.IPAddressSpec{
+			ClaimRef: corev1.LocalObjectReference{Name: name},
+			PoolRef: corev1.TypedLocalObjectReference{
+				APIGroup: ptr.To(ipamv1alpha1.GroupVersion.Group),
+				Kind:     ipamv1alpha1.NetboxIPPoolKind,
+				Name:     pool.Name,
+			},
+			Address: address,
+			Prefix:  24,
+		},
+	}
+}
+
+func newNetboxPrefix(cidr string) *netbox.NetboxIPPool {
+	prefix, err := ipaddr.NewIPAddressString(cidr).ToAddress()
+	Expect(err).ToNot(HaveOccurred())
+	return &netbox.NetboxIPPool{
+		Id:      1,
+		Type:    netbox.PrefixPoolType,
+		Display: cidr,
+		Range:   prefix.ToSequentialRange(),
+	}
+}
+
+func netboxPoolWithId(id int) gomock.Matcher {
+	return gomock.Cond(func(x any) bool {
+		pool, ok := x.(*netbox.NetboxIPPool)
+		return ok && pool.Id == id
+	})
+}
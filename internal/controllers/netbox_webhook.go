@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/logger"
+	poolutil "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/pool"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+// maxWebhookPayloadSize is the maximum size of a webhook request accepted from Netbox.
+const maxWebhookPayloadSize = 1 << 20
+
+// NetboxWebhookReceiver accepts the webhooks Netbox sends when ip-addresses, prefixes or ip-ranges change, and
+// enqueues the pools whose prefixes or ip-ranges cover the changed addresses, so that their status is refreshed right
+// away. Pools are matched by their addresses only, so pools with the same CIDR in another vrf are refreshed as well.
+type NetboxWebhookReceiver struct {
+	Client client.Client
+	// BindAddress is the address the receiver listens on for webhook requests.
+	BindAddress string
+	// Secret is the secret configured on the webhooks in Netbox. Requests without a valid signature are rejected.
+	Secret []byte
+	// Events receives an event for every pool affected by a change in Netbox.
+	Events chan<- event.GenericEvent
+}
+
+var _ manager.LeaderElectionRunnable = &NetboxWebhookReceiver{}
+
+func (r *NetboxWebhookReceiver) SetupWithManager(mgr manager.Manager) error {
+	if len(r.Secret) == 0 {
+		return errors.New("a secret is required to verify Netbox webhooks")
+	}
+	return mgr.Add(r)
+}
+
+// NeedLeaderElection makes sure webhooks are only accepted by the leader, which runs the pool controller the affected
+// pools are enqueued to.
+func (r *NetboxWebhookReceiver) NeedLeaderElection() bool {
+	return true
+}
+
+// Start serves webhook requests on BindAddress, until the context is done.
+func (r *NetboxWebhookReceiver) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              r.BindAddress,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "failed to serve Netbox webhooks")
+	}
+	return nil
+}
+
+// ServeHTTP handles a webhook request of Netbox.
+func (r *NetboxWebhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	log := logger.FromContext(ctx)
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxWebhookPayloadSize))
+	if err != nil {
+		http.Error(w, "could not read payload", http.StatusBadRequest)
+		return
+	}
+	if !netbox.VerifyWebhookSignature(r.Secret, body, req.Header.Get(netbox.WebhookSignatureHeader)) {
+		log.Warn("rejected Netbox webhook with invalid signature", "remote", req.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	payload, err := netbox.ParseWebhookPayload(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pools, err := r.affectedPools(ctx, payload)
+	if err != nil {
+		log.Error(err, "could not find pools affected by Netbox webhook")
+		http.Error(w, "could not find affected pools", http.StatusInternalServerError)
+		return
+	}
+	for i := range pools {
+		log.Debug("enqueueing pool changed in Netbox", "pool", pools[i].Name, "model", payload.Model, "event", payload.Event)
+		select {
+		case r.Events <- event.GenericEvent{Object: &pools[i]}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// affectedPools returns the pools that have a prefix or ip-range covering the addresses of the changed object, before
+// or after the change.
+func (r *NetboxWebhookReceiver) affectedPools(ctx context.Context, payload *netbox.WebhookPayload) ([]ipamv1alpha1.NetboxIPPool, error) {
+	log := logger.FromContext(ctx)
+
+	var changed []*ipaddr.SequentialRange[*ipaddr.IPAddress]
+	objects := []*netbox.WebhookObject{payload.Data}
+	if payload.Snapshots != nil {
+		objects = append(objects, payload.Snapshots.Prechange, payload.Snapshots.Postchange)
+	}
+	for _, object := range objects {
+		if object == nil {
+			continue
+		}
+		rng, err := object.Range(payload.Model)
+		if err != nil {
+			return nil, err
+		}
+		if rng != nil {
+			changed = append(changed, rng)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	pools := &ipamv1alpha1.NetboxIPPoolList{}
+	if err := r.Client.List(ctx, pools); err != nil {
+		return nil, errors.Wrap(err, "failed to list pools")
+	}
+	var affected []ipamv1alpha1.NetboxIPPool
+	for _, pool := range pools.Items {
+		members, err := poolutil.AllMembers(&pool)
+		if err != nil {
+			log.Debug("skipping invalid pool", "pool", pool.Name, "error", err.Error())
+			continue
+		}
+		if poolCovers(members, changed) {
+			affected = append(affected, pool)
+		}
+	}
+	return affected, nil
+}
+
+// poolCovers returns whether one of the members overlaps one of the address ranges. The addresses of an ip-range
+// member are not known without asking Netbox, so the prefix block of its start address is used instead.
+func poolCovers(members []*ipamv1alpha1.NetboxIPPool, ranges []*ipaddr.SequentialRange[*ipaddr.IPAddress]) bool {
+	for _, member := range members {
+		cidr, err := ipaddr.NewIPAddressString(member.Spec.CIDR).ToAddress()
+		if err != nil {
+			continue
+		}
+		block := cidr.ToPrefixBlock().ToSequentialRange()
+		for _, rng := range ranges {
+			if block.Overlaps(rng) {
+				return true
+			}
+		}
+	}
+	return false
+}
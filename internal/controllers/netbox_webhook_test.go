@@ -0,0 +1,119 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+var _ = Describe("NetboxWebhookReceiver", func() {
+	const namespace = "test-namespace"
+	secret := []byte("webhook-secret")
+
+	var (
+		events   chan event.GenericEvent
+		receiver *NetboxWebhookReceiver
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(ipamv1alpha1.AddToScheme(scheme)).To(Succeed())
+		newPool := func(name string, cidr string) *ipamv1alpha1.NetboxIPPool {
+			return &ipamv1alpha1.NetboxIPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec:       ipamv1alpha1.NetboxIPPoolSpec{Type: ipamv1alpha1.PrefixType, CIDR: cidr},
+			}
+		}
+
+		events = make(chan event.GenericEvent, 10)
+		receiver = &NetboxWebhookReceiver{
+			Client: fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(newPool("pool-a", "10.0.0.0/24"), newPool("pool-b", "10.0.1.0/24")).Build(),
+			Secret: secret,
+			Events: events,
+		}
+	})
+
+	send := func(body string, signature string) int {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(netbox.WebhookSignatureHeader, signature)
+		recorder := httptest.NewRecorder()
+		receiver.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	sign := func(body string) string {
+		mac := hmac.New(sha512.New, secret)
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	enqueued := func() []string {
+		close(events)
+		var names []string
+		for e := range events {
+			names = append(names, e.Object.GetName())
+		}
+		return names
+	}
+
+	It("enqueues the pool of a changed address", func() {
+		body := `{"event":"created","model":"ipaddress","data":{"address":"10.0.1.5/24"}}`
+		Expect(send(body, sign(body))).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(ConsistOf("pool-b"))
+	})
+
+	It("enqueues the pools of an address before and after the change", func() {
+		body := `{"event":"updated","model":"ipaddress","data":{"address":"10.0.1.5/24"},` +
+			`"snapshots":{"prechange":{"address":"10.0.0.5/24"},"postchange":{"address":"10.0.1.5/24"}}}`
+		Expect(send(body, sign(body))).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(ConsistOf("pool-a", "pool-b"))
+	})
+
+	It("enqueues the pools overlapping a changed prefix", func() {
+		body := `{"event":"deleted","model":"prefix","data":{"prefix":"10.0.0.0/16"}}`
+		Expect(send(body, sign(body))).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(ConsistOf("pool-a", "pool-b"))
+	})
+
+	It("ignores changes outside of the pools", func() {
+		body := `{"event":"created","model":"iprange","data":{"start_address":"192.168.0.1/24","end_address":"192.168.0.9/24"}}`
+		Expect(send(body, sign(body))).To(Equal(http.StatusNoContent))
+		Expect(enqueued()).To(BeEmpty())
+	})
+
+	It("rejects payloads with an invalid signature", func() {
+		body := `{"event":"created","model":"ipaddress","data":{"address":"10.0.1.5/24"}}`
+		Expect(send(body, sign("{}"))).To(Equal(http.StatusUnauthorized))
+		Expect(enqueued()).To(BeEmpty())
+	})
+})
@@ -19,6 +19,8 @@ package controller
 import (
 	"context"
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/seancfoley/ipaddress-go/ipaddr"
@@ -27,17 +29,23 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	clusterutil "sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	"sigs.k8s.io/cluster-api/util/patch"
 	ctrlutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -56,11 +64,20 @@ const (
 type NetboxIPPoolReconciler struct {
 	client.Client
 	Scheme               *runtime.Scheme
-	netboxServiceFactory func(url, apiToken string) (netbox.Client, error)
+	NetboxServiceFactory func(url, apiToken, apiVersion string) (netbox.Client, error)
+	Recorder             record.EventRecorder
+	// ResyncInterval is how often the usage of pools is refreshed from Netbox, unless a pool has its own
+	// ResyncInterval. Pools are only refreshed on changes if 0.
+	ResyncInterval time.Duration
+	// Events, if set, enqueues the pools sent to it, for example by the NetboxWebhookReceiver.
+	Events <-chan event.GenericEvent
 }
 
+// resyncJitter is the maximum fraction of the resync interval that is added at random.
+const resyncJitter = 0.1
+
 func (r *NetboxIPPoolReconciler) SetupWithManager(mgr manager.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&ipamv1alpha1.NetboxIPPool{}).
 		Watches(
 			&ipamv1.IPAddress{},
@@ -71,13 +88,17 @@ func (r *NetboxIPPoolReconciler) SetupWithManager(mgr manager.Manager) error {
 				}
 				return ipAddressToNetboxIPPool(ipAddress)
 			}),
-		).
-		Complete(r)
+		)
+	if r.Events != nil {
+		b = b.WatchesRawSource(source.Channel(r.Events, &handler.EnqueueRequestForObject{}))
+	}
+	return b.Complete(r)
 }
 
 // +kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=netboxippools,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=netboxippools/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=netboxippools/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -148,11 +169,12 @@ func (r *NetboxIPPoolReconciler) reconcileDelete(ctx context.Context, pool *ipam
 
 	// Pool is deleted so remove the finalizer.
 	ctrlutil.RemoveFinalizer(pool, PoolFinalizer)
+	deleteCapacityMetric(pool)
 
 	return reconcile.Result{}, nil
 }
 
-func (r *NetboxIPPoolReconciler) reconcileNormal(ctx context.Context, pool *ipamv1alpha1.NetboxIPPool, addressesInUse []ipamv1.IPAddress) (reconcile.Result, error) { //nolint:unparam
+func (r *NetboxIPPoolReconciler) reconcileNormal(ctx context.Context, pool *ipamv1alpha1.NetboxIPPool, addressesInUse []ipamv1.IPAddress) (reconcile.Result, error) {
 	log := logger.FromContext(ctx)
 
 	secret, err := r.reconcileNormalCredentialsSecret(ctx, pool)
@@ -161,38 +183,309 @@ func (r *NetboxIPPoolReconciler) reconcileNormal(ctx context.Context, pool *ipam
 		return reconcile.Result{}, err
 	}
 
-	netboxIPPool, err := r.getNetboxIPPool(ctx, secret, pool)
+	nb, err := getNetboxClient(secret, r.NetboxServiceFactory)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "could not create Netbox client")
+	}
+	// Access is checked first, so that an unreachable Netbox is reported even though its version is unknown.
+	reconcileAccess(ctx, nb, pool)
+	if err := reconcileVersion(ctx, nb, pool); err != nil {
+		if errors.Is(err, netbox.ErrUnsupportedVersion) {
+			return ctrl.Result{RequeueAfter: r.resyncAfter(pool)}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	previous := pool.Status.DeepCopy()
+	family := ipaddr.NewIPAddressString(pool.Spec.CIDR).GetIPVersion()
+	status, err := poolAddressesStatus(ctx, nb, pool, family, addressesInUse)
 	if err != nil {
-		return ctrl.Result{}, errors.Wrap(err, "failed to get Netbox IPPool")
+		markGatewayDiscoveryFailed(pool, err)
+		return ctrl.Result{}, err
+	}
+	netboxIPPool := status.netboxIPPool
+	outOfRange := status.outOfRange
+	pool.Status.Addresses = status.addresses
+	pool.Status.Members = status.members
+	pool.Status.Gateway = status.members[0].Gateway
+	pool.Status.Clusters = clusterUsage(addressesInUse)
+
+	pool.Status.DualStackAddresses = nil
+	if pool.Spec.DualStack != nil {
+		family := ipaddr.NewIPAddressString(pool.Spec.DualStack.CIDR).GetIPVersion()
+		dualStackStatus, err := poolAddressesStatus(ctx, nb, pool, family, addressesInUse)
+		if err != nil {
+			markGatewayDiscoveryFailed(pool, err)
+			return ctrl.Result{}, err
+		}
+		outOfRange = append(outOfRange, dualStackStatus.outOfRange...)
+		pool.Status.DualStackAddresses = dualStackStatus.addresses
+		pool.Status.Members = append(pool.Status.Members, dualStackStatus.members...)
+	}
+
+	pool.Status.OutOfRangeAddresses = nil
+	for _, address := range outOfRange {
+		pool.Status.OutOfRangeAddresses = append(pool.Status.OutOfRangeAddresses, ipamv1alpha1.NetboxPoolStatusOutOfRangeAddress{
+			Name:    address.Name,
+			Claim:   address.Spec.ClaimRef.Name,
+			Address: address.Spec.Address,
+		})
+	}
+	if err := r.flagOutOfRangeClaims(ctx, pool, addressesInUse, outOfRange); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if pool.Spec.DiscoverGateway {
+		conditions.MarkTrue(pool, ipamv1alpha1.GatewayDiscoveredCondition)
+	} else {
+		conditions.Delete(pool, ipamv1alpha1.GatewayDiscoveredCondition)
+	}
+
+	if err := r.reconcileCapacity(pool); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pool.Status.NetboxId = netboxIPPool.Id
+	pool.Status.NetboxType = (string)(netboxIPPool.Type)
+	r.reconcileMigration(pool, previous, addressesInUse)
+
+	log.Info("Updating pool with usage info", "statusAddresses", pool.Status.Addresses,
+		"statusDualStackAddresses", pool.Status.DualStackAddresses)
+
+	return ctrl.Result{RequeueAfter: r.resyncAfter(pool)}, nil
+}
+
+// resyncAfter returns when the usage of the pool is refreshed from Netbox next, or 0 if it is not refreshed
+// periodically.
+func (r *NetboxIPPoolReconciler) resyncAfter(pool *ipamv1alpha1.NetboxIPPool) time.Duration {
+	interval := r.ResyncInterval
+	if pool.Spec.ResyncInterval != nil {
+		interval = pool.Spec.ResyncInterval.Duration
+	}
+	if interval <= 0 {
+		return 0
+	}
+	return wait.Jitter(interval, resyncJitter)
+}
+
+// familyStatus is the usage of the prefixes and ip-ranges of one address family of a pool.
+type familyStatus struct {
+	// netboxIPPool is the first prefix or ip-range of the family in Netbox.
+	netboxIPPool *netbox.NetboxIPPool
+	// addresses is the usage of the family, summed over its members.
+	addresses *ipamv1alpha1.NetboxPoolStatusIPAddresses
+	// members is the usage per member of the family.
+	members []ipamv1alpha1.NetboxPoolStatusMember
+	// outOfRange are the IPAddresses of the family whose address is not part of any member in Netbox.
+	outOfRange []ipamv1.IPAddress
+}
+
+// poolAddressesStatus returns the usage of the given address family of the pool.
+func poolAddressesStatus(ctx context.Context, nb netbox.Client, pool *ipamv1alpha1.NetboxIPPool, family ipaddr.IPVersion,
+	addressesInUse []ipamv1.IPAddress) (*familyStatus, error) {
+	familyPool, err := poolutil.ForFamily(pool, family)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &familyStatus{addresses: &ipamv1alpha1.NetboxPoolStatusIPAddresses{}}
+	addresses := status.addresses
+	var netboxIPPools []*netbox.NetboxIPPool
+	for _, member := range poolutil.Members(familyPool) {
+		netboxIPPool, err := getNetboxIPPool(ctx, nb, member)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get Netbox IPPool")
+		}
+		if status.netboxIPPool == nil {
+			status.netboxIPPool = netboxIPPool
+		}
+		netboxIPPools = append(netboxIPPools, netboxIPPool)
+
+		if pool.Spec.DiscoverGateway && member.Spec.Gateway == "" {
+			gateway, err := discoverGateway(ctx, nb, netboxIPPool)
+			if err != nil {
+				return nil, err
+			}
+			member.Spec.Gateway = gateway.String()
+		}
+
+		var poolCount, usedCount, quarantinedCount int
+		if member.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+			poolCount, usedCount, err = countPrefixes(ctx, nb, member, netboxIPPool)
+		} else {
+			poolCount, usedCount, quarantinedCount, err = countAddresses(ctx, nb, member, netboxIPPool)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		status.members = append(status.members, ipamv1alpha1.NetboxPoolStatusMember{
+			CIDR:    member.Spec.CIDR,
+			Vrf:     member.Spec.Vrf,
+			Gateway: member.Spec.Gateway,
+			Addresses: ipamv1alpha1.NetboxPoolStatusIPAddresses{
+				Total:       poolCount,
+				Used:        usedCount,
+				Free:        max(poolCount-usedCount, 0),
+				Quarantined: quarantinedCount,
+			},
+		})
+		addresses.Total = addCount(addresses.Total, poolCount)
+		addresses.Used = addCount(addresses.Used, usedCount)
+		addresses.Free = addCount(addresses.Free, max(poolCount-usedCount, 0))
+		addresses.Quarantined = addCount(addresses.Quarantined, quarantinedCount)
+	}
+
+	for _, address := range addressesInUse {
+		ipAddress, err := ipaddr.NewIPAddressString(address.Spec.Address).ToAddress()
+		if err != nil || ipAddress.GetIPVersion() != family || inNetboxIPPools(netboxIPPools, ipAddress) {
+			continue
+		}
+		status.outOfRange = append(status.outOfRange, address)
 	}
+	addresses.Extra = len(status.outOfRange)
 
-	poolCount := netboxIPPool.Total()
+	return status, nil
+}
+
+// inNetboxIPPools returns whether the address is part of one of the prefixes or ip-ranges.
+func inNetboxIPPools(netboxIPPools []*netbox.NetboxIPPool, ipAddress *ipaddr.IPAddress) bool {
+	for _, netboxIPPool := range netboxIPPools {
+		if netboxIPPool.Contains(ipAddress) {
+			return true
+		}
+	}
+	return false
+}
+
+// flagOutOfRangeClaims sets the OutOfRangeAnnotation on the claims of the IPAddresses whose address is not part of
+// the pool, and removes it from the claims whose address is part of the pool again.
+func (r *NetboxIPPoolReconciler) flagOutOfRangeClaims(ctx context.Context, pool *ipamv1alpha1.NetboxIPPool,
+	addressesInUse []ipamv1.IPAddress, outOfRange []ipamv1.IPAddress) error {
+	log := logger.FromContext(ctx)
+
+	flagged := map[string]string{}
+	for _, address := range outOfRange {
+		flagged[address.Spec.ClaimRef.Name] = address.Spec.Address
+	}
+	for _, address := range addressesInUse {
+		claim := &ipamv1.IPAddressClaim{}
+		key := types.NamespacedName{Namespace: pool.Namespace, Name: address.Spec.ClaimRef.Name}
+		if err := r.Client.Get(ctx, key, claim); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return errors.Wrap(err, "failed to get claim")
+		}
+
+		value, flag := flagged[claim.Name]
+		current, isFlagged := claim.GetAnnotations()[ipamv1alpha1.OutOfRangeAnnotation]
+		if flag == isFlagged && value == current {
+			continue
+		}
+		patch := client.MergeFrom(claim.DeepCopy())
+		if flag {
+			log.Info("address of claim is out of range of the pool", "claim", claim.Name, "address", value)
+			if claim.Annotations == nil {
+				claim.Annotations = map[string]string{}
+			}
+			claim.Annotations[ipamv1alpha1.OutOfRangeAnnotation] = value
+		} else {
+			delete(claim.Annotations, ipamv1alpha1.OutOfRangeAnnotation)
+		}
+		if err := r.Client.Patch(ctx, claim, patch); err != nil {
+			return errors.Wrap(err, "failed to patch claim")
+		}
+	}
+	return nil
+}
+
+// markGatewayDiscoveryFailed marks the GatewayDiscoveredCondition false if err is caused by gateway discovery.
+func markGatewayDiscoveryFailed(pool *ipamv1alpha1.NetboxIPPool, err error) {
+	switch {
+	case errors.Is(err, errGatewayNotFound):
+		conditions.MarkFalse(pool, ipamv1alpha1.GatewayDiscoveredCondition, ipamv1alpha1.GatewayNotFoundReason,
+			clusterv1.ConditionSeverityError, "%s", err.Error())
+	case errors.Is(err, errMultipleGateways):
+		conditions.MarkFalse(pool, ipamv1alpha1.GatewayDiscoveredCondition, ipamv1alpha1.MultipleGatewaysReason,
+			clusterv1.ConditionSeverityError, "%s", err.Error())
+	}
+}
+
+// addCount adds two counts, reporting counts greater than int can contain as math.MaxInt.
+func addCount(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// countAddresses returns the number of allocatable addresses of the pool, the number of those addresses in use in
+// Netbox and the number of addresses in use that are quarantined. The gateway and the excluded addresses are not
+// counted.
+func countAddresses(ctx context.Context, nb netbox.Client, pool *ipamv1alpha1.NetboxIPPool, netboxIPPool *netbox.NetboxIPPool) (poolCount, usedCount, quarantinedCount int, err error) {
+	excluded, err := poolutil.ParseAddressRanges(pool.Spec.Exclude)
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "failed to parse excluded addresses")
+	}
+
+	poolCount = netboxIPPool.Total() - excluded.CountIn(netboxIPPool.Range)
 	if pool.Spec.Gateway != "" {
 		gatewayAddress, err := ipaddr.NewIPAddressString(pool.Spec.Gateway).ToAddress()
 		if err != nil {
-			return ctrl.Result{}, errors.Wrap(err, "failed to parse pool gateway")
+			return 0, 0, 0, errors.Wrap(err, "failed to parse pool gateway")
 		}
 
-		if netboxIPPool.Contains(gatewayAddress) {
+		if netboxIPPool.Contains(gatewayAddress) && !excluded.Contains(gatewayAddress) {
 			poolCount--
 		}
 	}
 
-	inUseCount := len(addressesInUse)
+	ipAddresses, err := nb.GetIPAddresses(ctx, netboxIPPool)
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "failed to get Netbox ip-addresses")
+	}
 
-	pool.Status.Addresses = &ipamv1alpha1.NetboxPoolStatusIPAddresses{
-		Total: poolCount,
-		Used:  netboxIPPool.InUse(),
-		Free:  netboxIPPool.Available(),
-		Extra: inUseCount,
+	gateway := poolGateway(pool)
+	for _, a := range ipAddresses {
+		address, err := ipaddr.NewIPAddressString(a.Address).ToAddress()
+		if err != nil {
+			logger.FromContext(ctx).Error(err, fmt.Sprintf("could not parse ipAddress %s", a.Address))
+			continue
+		}
+		if excluded.Contains(address) || (gateway != nil && gateway.Equal(address.WithoutPrefixLen())) {
+			continue
+		}
+		usedCount++
+		if isQuarantined(&a) {
+			quarantinedCount++
+		}
 	}
 
-	pool.Status.NetboxId = netboxIPPool.Id
-	pool.Status.NetboxType = (string)(netboxIPPool.Type)
+	return poolCount, usedCount, quarantinedCount, nil
+}
 
-	log.Info("Updating pool with usage info", "statusAddresses", pool.Status.Addresses)
+// countPrefixes returns the number of child prefixes of the configured length that fit in the pool and the number
+// of those prefixes that exist in Netbox.
+func countPrefixes(ctx context.Context, nb netbox.Client, pool *ipamv1alpha1.NetboxIPPool, netboxIPPool *netbox.NetboxIPPool) (poolCount, usedCount int, err error) {
+	prefixLen, err := poolPrefixLen(pool)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch bits := pool.Spec.PrefixLength - prefixLen; {
+	case bits < 0:
+		poolCount = 0
+	case bits >= 63:
+		poolCount = math.MaxInt
+	default:
+		poolCount = 1 << bits
+	}
 
-	return ctrl.Result{}, nil
+	prefixes, err := nb.GetChildPrefixes(ctx, netboxIPPool, pool.Spec.PrefixLength)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get Netbox child prefixes")
+	}
+	return poolCount, len(prefixes), nil
 }
 
 func (r *NetboxIPPoolReconciler) reconcileNormalCredentialsSecret(ctx context.Context, pool *ipamv1alpha1.NetboxIPPool) (*corev1.Secret, error) {
@@ -263,26 +556,8 @@ func (r *NetboxIPPoolReconciler) reconcileDeleteCredentialsSecret(ctx context.Co
 	return helper.Patch(ctx, secret)
 }
 
-func (r *NetboxIPPoolReconciler) getNetboxIPPool(ctx context.Context, secret *corev1.Secret, pool *ipamv1alpha1.NetboxIPPool) (*netbox.NetboxIPPool, error) {
-	nb, err := getNetboxClient(secret, r.netboxServiceFactory)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not create Netbox client")
-	}
-
-	switch pool.Spec.Type {
-	case ipamv1alpha1.PrefixType:
-		return nb.GetPrefix(ctx, pool.Spec.CIDR, pool.Spec.Vrf)
-
-	case ipamv1alpha1.IPRangeType:
-		return nb.GetIPRange(ctx, pool.Spec.CIDR, pool.Spec.Vrf)
-	}
-	return nil, errors.New(fmt.Sprintf("unknown IPPoolType %s", pool.Spec.Type))
-}
-
 func ipAddressToNetboxIPPool(ipAddress *ipamv1.IPAddress) []reconcile.Request {
-	if ipAddress.Spec.PoolRef.APIGroup != nil &&
-		*ipAddress.Spec.PoolRef.APIGroup == ipamv1alpha1.GroupVersion.Group &&
-		ipAddress.Spec.PoolRef.Kind == ipamv1alpha1.NetboxIPPoolKind {
+	if referencesNetboxIPPool(ipAddress) {
 		return []reconcile.Request{{
 			NamespacedName: types.NamespacedName{
 				Namespace: ipAddress.Namespace,
@@ -292,3 +567,10 @@ func ipAddressToNetboxIPPool(ipAddress *ipamv1.IPAddress) []reconcile.Request {
 	}
 	return nil
 }
+
+// referencesNetboxIPPool returns whether the address is allocated by a NetboxIPPool.
+func referencesNetboxIPPool(ipAddress *ipamv1.IPAddress) bool {
+	return ipAddress.Spec.PoolRef.APIGroup != nil &&
+		*ipAddress.Spec.PoolRef.APIGroup == ipamv1alpha1.GroupVersion.Group &&
+		ipAddress.Spec.PoolRef.Kind == ipamv1alpha1.NetboxIPPoolKind
+}
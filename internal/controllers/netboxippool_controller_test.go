@@ -23,12 +23,17 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
 	"go.uber.org/mock/gomock"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/cluster-api-ipam-provider-in-cluster/pkg/ipamutil"
 	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	. "sigs.k8s.io/controller-runtime/pkg/envtest/komega"
 
 	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
@@ -60,14 +65,17 @@ var _ = Describe("NetboxIPPool Controller", func() {
 			createdClaimNames = nil
 			mockCtrl = gomock.NewController(GinkgoT())
 			netboxMock = nbmock.NewMockClient(mockCtrl)
-			netboxFactory := func(url, apiToken string) (netbox.Client, error) {
+			netboxMock.EXPECT().CheckPermissions(gomock.Any()).Return(nil, nil).AnyTimes()
+			netboxMock.EXPECT().Version(gomock.Any()).Return(netbox.Version{Major: 3, Minor: 7}, nil).AnyTimes()
+			netboxFactory := func(url, apiToken, apiVersion string) (netbox.Client, error) {
 				return netboxMock, nil
 			}
 			Expect(
 				(&NetboxIPPoolReconciler{
 					Client:               testEnv.GetClient(),
 					Scheme:               testEnv.GetScheme(),
-					netboxServiceFactory: netboxFactory,
+					NetboxServiceFactory: netboxFactory,
+					Recorder:             testEnv.GetEventRecorderFor("netboxippool-controller"),
 				}).SetupWithManager(testEnv)).To(Succeed())
 			Expect(
 				(&ipamutil.ClaimReconciler{
@@ -94,6 +102,7 @@ var _ = Describe("NetboxIPPool Controller", func() {
 					netboxMock.EXPECT().GetPrefix(gomock.Any(), gomock.Any(), gomock.Any()).Return(&netbox.NetboxIPPool{}, nil),
 					netboxMock.EXPECT().GetPrefix(gomock.Any(), gomock.Any(), gomock.Any()).Return(&netbox.NetboxIPPool{}, nil),
 				)
+				netboxMock.EXPECT().GetIPAddresses(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
 
 				pool = newPool(testPool, namespace, credentialSecret, gateway, address)
 				Expect(testEnv.Create(context.Background(), pool)).To(Succeed())
@@ -122,7 +131,36 @@ var _ = Describe("NetboxIPPool Controller", func() {
 			Entry("When there is 1 claim and no gateway", 24, "10.0.0.10/16", "", 0, 0, 0),
 		)
 
-		DescribeTable("it shows the out of range ips if any")
+		DescribeTable("it shows the out of range ips if any",
+			func(addresses []string, expectedOutOfRange []string) {
+				netboxMock.EXPECT().GetPrefix(gomock.Any(), gomock.Any(), gomock.Any()).Return(newNetboxPrefix("10.0.0.0/24"), nil).AnyTimes()
+				netboxMock.EXPECT().GetIPAddresses(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+				pool = newPool(testPool, namespace, credentialSecret, "", "10.0.0.0/24")
+				Expect(testEnv.Create(context.Background(), pool)).To(Succeed())
+
+				for i, address := range addresses {
+					ipAddress := newIPAddress(fmt.Sprintf("test%d", i), namespace, pool.GetName(), address)
+					Expect(testEnv.Create(context.Background(), &ipAddress)).To(Succeed())
+					DeferCleanup(func() {
+						Expect(testEnv.Delete(context.Background(), &ipAddress)).To(Succeed())
+					})
+				}
+
+				Eventually(Object(pool)).
+					WithTimeout(5 * time.Second).WithPolling(100 * time.Millisecond).Should(
+					HaveField("Status.Addresses.Extra", Equal(len(expectedOutOfRange))))
+				var outOfRange []string
+				for _, address := range pool.Status.OutOfRangeAddresses {
+					outOfRange = append(outOfRange, address.Address)
+				}
+				Expect(outOfRange).To(Equal(expectedOutOfRange))
+			},
+
+			Entry("When all addresses are in range", []string{"10.0.0.1", "10.0.0.2"}, nil),
+			Entry("When some addresses are out of range", []string{"10.0.0.1", "10.0.1.1", "192.168.0.1"},
+				[]string{"10.0.1.1", "192.168.0.1"}),
+		)
 	})
 
 	Context("when the pool has IPAddresses", func() {
@@ -147,7 +185,8 @@ func createCredentialsSecret(namespace string) *corev1.Secret {
 			Namespace:    namespace,
 		},
 		StringData: map[string]string{
-			"bla": "bla",
+			UrlKey:      "http://netbox.local",
+			ApiTokenKey: "token",
 		},
 	}
 	EventuallyWithOffset(1, testEnv.Create).WithArguments(context.Background(), secret).Should(Succeed())
@@ -170,6 +209,112 @@ func newClaim(name, namespace, poolName string) ipamv1.IPAddressClaim {
 	}
 }
 
+func newIPAddress(name, namespace, poolName, address string) ipamv1.IPAddress {
+	return ipamv1.IPAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: ipamv1.IPAddressSpec{
+			ClaimRef: corev1.LocalObjectReference{Name: name},
+			PoolRef: corev1.TypedLocalObjectReference{
+				APIGroup: ptr.To("ipam.cluster.x-k8s.io"),
+				Kind:     "NetboxIPPool",
+				Name:     poolName,
+			},
+			Address: address,
+			Prefix:  24,
+		},
+	}
+}
+
+var _ = Describe("out of range addresses", func() {
+	const namespace = "test-namespace"
+	var (
+		mockCtrl   *gomock.Controller
+		netboxMock *nbmock.MockClient
+		pool       *ipamv1alpha1.NetboxIPPool
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		netboxMock = nbmock.NewMockClient(mockCtrl)
+		netboxMock.EXPECT().GetIPAddresses(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+		pool = newPool("test-pool", namespace, &corev1.Secret{}, "", "10.0.0.0/24")
+		pool.Name = "test-pool"
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("counts the addresses outside of all members as extra", func() {
+		pool.Spec.Fallback = []ipamv1alpha1.NetboxPoolReference{{Type: ipamv1alpha1.PrefixType, CIDR: "10.0.1.0/24"}}
+		netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", gomock.Any()).Return(newNetboxPrefix("10.0.0.0/24"), nil)
+		netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.1.0/24", gomock.Any()).Return(newNetboxPrefix("10.0.1.0/24"), nil)
+
+		addresses := []ipamv1.IPAddress{
+			newIPAddress("in-first", namespace, pool.Name, "10.0.0.1"),
+			newIPAddress("in-fallback", namespace, pool.Name, "10.0.1.1"),
+			newIPAddress("outside", namespace, pool.Name, "10.0.2.1"),
+			newIPAddress("other-family", namespace, pool.Name, "fd00::1"),
+		}
+		status, err := poolAddressesStatus(ctx, netboxMock, pool, ipaddr.IPv4, addresses)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.addresses.Extra).To(Equal(1))
+		Expect(status.outOfRange).To(HaveLen(1))
+		Expect(status.outOfRange[0].Name).To(Equal("outside"))
+		for _, member := range status.members {
+			Expect(member.Addresses.Extra).To(BeZero())
+		}
+	})
+
+	It("flags the claims of out of range addresses and unflags them when back in range", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
+
+		inRange := newClaim("in-range", namespace, pool.Name)
+		inRange.Annotations = map[string]string{ipamv1alpha1.OutOfRangeAnnotation: "10.0.0.1"}
+		outside := newClaim("outside", namespace, pool.Name)
+		reconciler := &NetboxIPPoolReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&inRange, &outside).Build(),
+		}
+
+		addresses := []ipamv1.IPAddress{
+			newIPAddress("in-range", namespace, pool.Name, "10.0.0.1"),
+			newIPAddress("outside", namespace, pool.Name, "10.0.2.1"),
+			newIPAddress("no-claim", namespace, pool.Name, "10.0.2.2"),
+		}
+		Expect(reconciler.flagOutOfRangeClaims(ctx, pool, addresses, addresses[1:])).To(Succeed())
+
+		claim := &ipamv1.IPAddressClaim{}
+		Expect(reconciler.Client.Get(ctx, client.ObjectKeyFromObject(&inRange), claim)).To(Succeed())
+		Expect(claim.Annotations).ToNot(HaveKey(ipamv1alpha1.OutOfRangeAnnotation))
+		Expect(reconciler.Client.Get(ctx, client.ObjectKeyFromObject(&outside), claim)).To(Succeed())
+		Expect(claim.Annotations).To(HaveKeyWithValue(ipamv1alpha1.OutOfRangeAnnotation, "10.0.2.1"))
+	})
+})
+
+var _ = Describe("resyncAfter", func() {
+	It("uses the resync interval of the controller with jitter", func() {
+		reconciler := &NetboxIPPoolReconciler{ResyncInterval: 10 * time.Minute}
+		Expect(reconciler.resyncAfter(&ipamv1alpha1.NetboxIPPool{})).
+			To(And(BeNumerically(">=", 10*time.Minute), BeNumerically("<=", 11*time.Minute)))
+	})
+
+	It("prefers the resync interval of the pool", func() {
+		reconciler := &NetboxIPPoolReconciler{ResyncInterval: 10 * time.Minute}
+		pool := &ipamv1alpha1.NetboxIPPool{
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{ResyncInterval: &metav1.Duration{Duration: time.Minute}},
+		}
+		Expect(reconciler.resyncAfter(pool)).To(And(BeNumerically(">=", time.Minute), BeNumerically("<=", 66*time.Second)))
+
+		pool.Spec.ResyncInterval.Duration = 0
+		Expect(reconciler.resyncAfter(pool)).To(BeZero())
+	})
+})
+
 func deleteClaim(name, namespace string) {
 	claim := ipamv1.IPAddressClaim{
 		ObjectMeta: metav1.ObjectMeta{
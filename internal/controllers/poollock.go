@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+// poolLocks serializes the allocations of the prefixes and ip-ranges in Netbox, while allocations of different
+// prefixes and ip-ranges are handled in parallel. The locks are keyed on the prefix or ip-range in Netbox rather than
+// on the NetboxIPPool, because several pools can allocate from the same prefix or ip-range.
+type poolLocks struct {
+	locks sync.Map
+}
+
+// lock locks the prefixes and ip-ranges with the given keys and returns the function that unlocks them again. The
+// keys are locked in order, so that claims locking overlapping keys do not deadlock.
+func (l *poolLocks) lock(keys ...string) func() {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	mutexes := make([]*sync.Mutex, 0, len(keys))
+	for _, key := range keys {
+		value, _ := l.locks.LoadOrStore(key, &sync.Mutex{})
+		mutex := value.(*sync.Mutex)
+		mutex.Lock()
+		mutexes = append(mutexes, mutex)
+	}
+	return func() {
+		for i := len(mutexes) - 1; i >= 0; i-- {
+			mutexes[i].Unlock()
+		}
+	}
+}
+
+// netboxPoolKey returns the key of the lock of a prefix or ip-range in Netbox.
+func netboxPoolKey(pool *netbox.NetboxIPPool) string {
+	return fmt.Sprintf("%s/%d", pool.Type, pool.Id)
+}
+
+// memberKeys returns the keys of the locks of the prefixes and ip-ranges of the members.
+func memberKeys(members []poolMember) []string {
+	keys := make([]string, 0, len(members))
+	for _, member := range members {
+		keys = append(keys, netboxPoolKey(member.netbox))
+	}
+	return keys
+}
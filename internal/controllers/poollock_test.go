@@ -0,0 +1,89 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("poolLocks", func() {
+	var locks *poolLocks
+
+	BeforeEach(func() {
+		locks = &poolLocks{}
+	})
+
+	lockAsync := func(keys ...string) chan struct{} {
+		locked := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			unlock := locks.lock(keys...)
+			close(locked)
+			unlock()
+		}()
+		return locked
+	}
+
+	It("serializes claims of the same prefix", func() {
+		unlock := locks.lock("Prefix/1")
+		locked := lockAsync("Prefix/1")
+
+		Consistently(locked).WithTimeout(100 * time.Millisecond).ShouldNot(BeClosed())
+		unlock()
+		Eventually(locked).Should(BeClosed())
+	})
+
+	It("does not block claims of other prefixes and ip-ranges", func() {
+		unlock := locks.lock("Prefix/1")
+		defer unlock()
+
+		Eventually(lockAsync("Prefix/2")).Should(BeClosed())
+		Eventually(lockAsync("IPRange/1")).Should(BeClosed())
+	})
+
+	It("locks all keys of a claim", func() {
+		unlock := locks.lock("IPRange/1")
+		locked := lockAsync("Prefix/1", "IPRange/1")
+
+		Consistently(locked).WithTimeout(100 * time.Millisecond).ShouldNot(BeClosed())
+		Eventually(lockAsync("Prefix/2")).Should(BeClosed())
+		unlock()
+		Eventually(locked).Should(BeClosed())
+	})
+
+	It("does not deadlock claims locking the same keys in another order", func() {
+		lockRepeatedly := func(keys ...string) chan struct{} {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				for range 100 {
+					locks.lock(keys...)()
+				}
+				close(done)
+			}()
+			return done
+		}
+
+		first := lockRepeatedly("Prefix/1", "Prefix/2")
+		second := lockRepeatedly("Prefix/2", "Prefix/1")
+		Eventually(first).Should(BeClosed())
+		Eventually(second).Should(BeClosed())
+	})
+})
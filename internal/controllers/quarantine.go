@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/logger"
+	poolutil "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/pool"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+// DefaultQuarantineCleanupInterval is how often quarantined addresses are checked, if not configured.
+const DefaultQuarantineCleanupInterval = 5 * time.Minute
+
+// QuarantineCleaner deletes addresses that were released into quarantine from Netbox, once the quarantine period of
+// their pool has expired. The period starts at the time recorded in the QuarantinedCustomField of the address, so
+// editing the address in Netbox does not extend it.
+type QuarantineCleaner struct {
+	Client               client.Client
+	NetboxServiceFactory func(url, apiToken, apiVersion string) (netbox.Client, error)
+	Interval             time.Duration
+}
+
+var _ manager.LeaderElectionRunnable = &QuarantineCleaner{}
+
+func (c *QuarantineCleaner) SetupWithManager(mgr manager.Manager) error {
+	return mgr.Add(c)
+}
+
+// NeedLeaderElection makes sure only the leader deletes quarantined addresses.
+func (c *QuarantineCleaner) NeedLeaderElection() bool {
+	return true
+}
+
+// Start cleans up quarantined addresses every Interval, until the context is done.
+func (c *QuarantineCleaner) Start(ctx context.Context) error {
+	log := logger.FromContext(ctx)
+
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultQuarantineCleanupInterval
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.Cleanup(ctx); err != nil {
+			log.Error(err, "failed to clean up quarantined addresses")
+		}
+	}, interval)
+	return nil
+}
+
+// Cleanup deletes the quarantined addresses of all pools whose quarantine period has expired.
+func (c *QuarantineCleaner) Cleanup(ctx context.Context) error {
+	pools := &ipamv1alpha1.NetboxIPPoolList{}
+	if err := c.Client.List(ctx, pools); err != nil {
+		return errors.Wrap(err, "failed to list pools")
+	}
+
+	var errs []error
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		if pool.Spec.Quarantine == nil || pool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+			continue
+		}
+		if err := c.cleanupPool(ctx, pool); err != nil {
+			errs = append(errs, errors.Wrap(err, fmt.Sprintf("failed to clean up pool %s/%s", pool.Namespace, pool.Name)))
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+func (c *QuarantineCleaner) cleanupPool(ctx context.Context, pool *ipamv1alpha1.NetboxIPPool) error {
+	log := logger.FromContext(ctx)
+
+	secret, err := getSecretForPool(ctx, c.Client, pool)
+	if err != nil {
+		return err
+	}
+	netboxClient, err := getNetboxClient(secret, c.NetboxServiceFactory)
+	if err != nil {
+		return err
+	}
+
+	status := quarantineStatus(pool.Spec.Quarantine)
+	expired := time.Now().Add(-pool.Spec.Quarantine.Period.Duration)
+	members, err := poolutil.AllMembers(pool)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		// The end of an ip-range is only known to Netbox, so the addresses are checked against the prefix or ip-range
+		// in Netbox, like allocations are.
+		netboxIPPool, err := getNetboxIPPool(ctx, netboxClient, member)
+		if err != nil {
+			return err
+		}
+		candidates, err := netboxClient.FindQuarantinedIPAddresses(ctx, member.Spec.Vrf)
+		if err != nil {
+			return err
+		}
+		for _, candidate := range candidates {
+			since, ok := candidate.QuarantinedSince()
+			if !ok || candidate.Status == nil || candidate.Status.Value != status || since.After(expired) {
+				continue
+			}
+			ipAddress, aerr := ipaddr.NewIPAddressString(candidate.Address).ToAddress()
+			if aerr != nil || !netboxIPPool.Contains(ipAddress) {
+				continue
+			}
+			log.Info("deleting quarantined address", "pool", pool.Name, "address", candidate.Address)
+			if err := netboxClient.DeleteIPAddress(ctx, candidate.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isQuarantined returns whether the address was released into quarantine.
+func isQuarantined(ipAddress *netbox.IPAddress) bool {
+	_, ok := ipAddress.QuarantinedSince()
+	return ok
+}
+
+// quarantineStatus returns the Netbox status of addresses quarantined by the pool.
+func quarantineStatus(quarantine *ipamv1alpha1.AddressQuarantine) string {
+	if quarantine.Status == "" {
+		return string(ipamv1alpha1.DeprecatedAddressStatus)
+	}
+	return string(quarantine.Status)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+	nbmock "github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox/mock"
+)
+
+var _ = Describe("QuarantineCleaner", func() {
+	const namespace = "test-namespace"
+
+	var (
+		mockCtrl   *gomock.Controller
+		netboxMock *nbmock.MockClient
+		pool       *ipamv1alpha1.NetboxIPPool
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		netboxMock = nbmock.NewMockClient(mockCtrl)
+		pool = &ipamv1alpha1.NetboxIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Namespace: namespace},
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{
+				Type:           ipamv1alpha1.PrefixType,
+				CIDR:           "10.0.0.0/24",
+				Quarantine:     &ipamv1alpha1.AddressQuarantine{Period: metav1.Duration{Duration: time.Hour}},
+				CredentialsRef: &corev1.SecretReference{Name: "netbox-credentials"},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	newCleaner := func(objs ...client.Object) *QuarantineCleaner {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "netbox-credentials", Namespace: namespace},
+			Data: map[string][]byte{
+				UrlKey:      []byte("http://netbox.local"),
+				ApiTokenKey: []byte("token"),
+			},
+		}
+		return &QuarantineCleaner{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs, secret)...).Build(),
+			NetboxServiceFactory: func(_, _, _ string) (netbox.Client, error) {
+				return netboxMock, nil
+			},
+		}
+	}
+
+	quarantined := func(id int, address string, status string, released time.Duration) netbox.IPAddress {
+		return netbox.IPAddress{
+			Id:      id,
+			Address: address,
+			Status:  &netbox.Status{Value: status},
+			CustomFields: map[string]any{
+				netbox.QuarantinedCustomField: time.Now().Add(-released).UTC().Format(time.RFC3339),
+			},
+		}
+	}
+
+	It("deletes quarantined addresses whose period has expired", func() {
+		netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+		netboxMock.EXPECT().FindQuarantinedIPAddresses(gomock.Any(), "").Return([]netbox.IPAddress{
+			quarantined(2, "10.0.0.2/24", "deprecated", 2*time.Hour),
+			quarantined(3, "10.0.0.3/24", "deprecated", time.Minute),
+			quarantined(4, "10.0.0.4/24", "active", 2*time.Hour),
+			quarantined(5, "10.0.1.5/24", "deprecated", 2*time.Hour),
+		}, nil)
+		netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 2).Return(nil)
+
+		Expect(newCleaner(pool).Cleanup(ctx)).To(Succeed())
+	})
+
+	It("measures the quarantine period from the time the address was quarantined", func() {
+		edited := quarantined(2, "10.0.0.2/24", "deprecated", 2*time.Hour)
+		edited.Description = "edited in Netbox"
+		edited.LastUpdated = ptr.To(time.Now().Add(-time.Minute))
+		netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+		netboxMock.EXPECT().FindQuarantinedIPAddresses(gomock.Any(), "").Return([]netbox.IPAddress{edited}, nil)
+		netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 2).Return(nil)
+
+		Expect(newCleaner(pool).Cleanup(ctx)).To(Succeed())
+	})
+
+	It("uses the quarantine status of the pool", func() {
+		pool.Spec.Quarantine.Status = ipamv1alpha1.ReservedAddressStatus
+		netboxMock.EXPECT().GetPrefix(gomock.Any(), "10.0.0.0/24", "").Return(newNetboxPrefix("10.0.0.0/24"), nil)
+		netboxMock.EXPECT().FindQuarantinedIPAddresses(gomock.Any(), "").Return([]netbox.IPAddress{
+			quarantined(2, "10.0.0.2/24", "deprecated", 2*time.Hour),
+			quarantined(3, "10.0.0.3/24", "reserved", 2*time.Hour),
+		}, nil)
+		netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 3).Return(nil)
+
+		Expect(newCleaner(pool).Cleanup(ctx)).To(Succeed())
+	})
+
+	It("only deletes the addresses within the ip-range of an ip-range pool", func() {
+		pool.Spec.Type = ipamv1alpha1.IPRangeType
+		pool.Spec.CIDR = "10.0.0.10/24"
+		netboxMock.EXPECT().GetIPRange(gomock.Any(), "10.0.0.10/24", "").Return(&netbox.NetboxIPPool{
+			Id:    1,
+			Type:  netbox.IPRangePoolType,
+			Range: ipaddr.NewIPAddressString("10.0.0.10").GetAddress().SpanWithRange(ipaddr.NewIPAddressString("10.0.0.20").GetAddress()),
+		}, nil)
+		netboxMock.EXPECT().FindQuarantinedIPAddresses(gomock.Any(), "").Return([]netbox.IPAddress{
+			quarantined(12, "10.0.0.12/24", "deprecated", 2*time.Hour),
+			quarantined(30, "10.0.0.30/24", "deprecated", 2*time.Hour),
+		}, nil)
+		netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), 12).Return(nil)
+
+		Expect(newCleaner(pool).Cleanup(ctx)).To(Succeed())
+	})
+
+	It("ignores pools without quarantine", func() {
+		pool.Spec.Quarantine = nil
+
+		Expect(newCleaner(pool).Cleanup(ctx)).To(Succeed())
+	})
+})
+
+var _ = Describe("countAddresses", func() {
+	It("counts quarantined addresses as used", func() {
+		mockCtrl := gomock.NewController(GinkgoT())
+		defer mockCtrl.Finish()
+		netboxMock := nbmock.NewMockClient(mockCtrl)
+		pool := &ipamv1alpha1.NetboxIPPool{
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{Type: ipamv1alpha1.PrefixType, CIDR: "10.0.0.0/24", Gateway: "10.0.0.1"},
+		}
+		prefix := newNetboxPrefix("10.0.0.0/24")
+		netboxMock.EXPECT().GetIPAddresses(gomock.Any(), prefix).Return([]netbox.IPAddress{
+			{Address: "10.0.0.1/24"},
+			{Address: "10.0.0.2/24"},
+			{Address: "10.0.0.3/24", CustomFields: map[string]any{netbox.QuarantinedCustomField: "2026-01-02T03:04:05Z"}},
+		}, nil)
+
+		poolCount, usedCount, quarantinedCount, err := countAddresses(ctx, netboxMock, pool, prefix)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(poolCount).To(Equal(prefix.Total() - 1))
+		Expect(usedCount).To(Equal(2))
+		Expect(quarantinedCount).To(Equal(1))
+	})
+})
@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+	poolutil "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/pool"
+)
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// quotaReservations tracks the claims of pools with a quota that passed the quota check, but whose IPAddress is not
+// listed yet. They count against the quota, so that claims allocated in parallel can not exceed it together.
+type quotaReservations struct {
+	// locks serializes the quota checks of the pools of a namespace sharing a prefix or ip-range, while the addresses
+	// of the pools are listed.
+	locks poolLocks
+
+	mu sync.Mutex
+	// pools maps the pools to the names of their reserved claims, and the clusters of the claims.
+	pools map[client.ObjectKey]map[string]string
+}
+
+// release releases the reservation of the claim, if any.
+func (r *quotaReservations) release(pool client.Object, claim client.Object) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pools[client.ObjectKeyFromObject(pool)], claim.GetName())
+}
+
+// reserved returns the reserved claims of the pools and their clusters. Claims whose IPAddress is listed in
+// addressesInUse are dropped, their IPAddress is counted itself.
+func (r *quotaReservations) reserved(pools []ipamv1alpha1.NetboxIPPool, addressesInUse []ipamv1.IPAddress) map[client.ObjectKey]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	listed := map[client.ObjectKey]bool{}
+	for _, a := range addressesInUse {
+		listed[client.ObjectKey{Namespace: a.Namespace, Name: a.Spec.ClaimRef.Name}] = true
+	}
+
+	reserved := map[client.ObjectKey]string{}
+	for i := range pools {
+		poolKey := client.ObjectKeyFromObject(&pools[i])
+		for name, cluster := range r.pools[poolKey] {
+			claim := client.ObjectKey{Namespace: poolKey.Namespace, Name: name}
+			if listed[claim] {
+				delete(r.pools[poolKey], name)
+				continue
+			}
+			reserved[claim] = cluster
+		}
+	}
+	return reserved
+}
+
+// reserve reserves the quota of the pool for the claim.
+func (r *quotaReservations) reserve(pool client.Object, claim client.Object, cluster string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pools == nil {
+		r.pools = map[client.ObjectKey]map[string]string{}
+	}
+	reserved := r.pools[client.ObjectKeyFromObject(pool)]
+	if reserved == nil {
+		reserved = map[string]string{}
+		r.pools[client.ObjectKeyFromObject(pool)] = reserved
+	}
+	reserved[claim.GetName()] = cluster
+}
+
+// ensureWithinQuota returns an error wrapping errQuotaExceeded if allocating an address for the claim would exceed
+// the quota of the pool. The quota limits the addresses that the claims of the namespace, or of a Cluster, hold in the
+// prefixes and ip-ranges of the pool, so the addresses of the other pools of the namespace sharing a prefix or ip-range
+// with the pool are counted too. If the claim is within the quota, it is reserved until its IPAddress is listed, or
+// until the reservation is released.
+func (h *IPAddressClaimHandler) ensureWithinQuota(ctx context.Context) error {
+	quota := h.pool.Spec.Quota
+	if quota == nil {
+		return nil
+	}
+
+	members, err := poolutil.AllMembers(h.pool)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(members))
+	for _, member := range members {
+		keys = append(keys, quotaKey(member))
+	}
+	unlock := h.quotas.locks.lock(keys...)
+	defer unlock()
+
+	pools, err := h.sharingPools(ctx, keys)
+	if err != nil {
+		return err
+	}
+	var addressesInUse []ipamv1.IPAddress
+	for _, pool := range pools {
+		poolTypeRef := corev1.TypedLocalObjectReference{
+			APIGroup: ptr.To(ipamv1alpha1.GroupVersion.Group),
+			Kind:     ipamv1alpha1.NetboxIPPoolKind,
+			Name:     pool.GetName(),
+		}
+		poolAddresses, err := poolutil.ListAddressesInUse(ctx, h.Client, pool.GetNamespace(), poolTypeRef)
+		if err != nil {
+			return errors.Wrap(err, "failed to list addresses")
+		}
+		addressesInUse = append(addressesInUse, poolAddresses...)
+	}
+
+	cluster := h.claim.GetLabels()[clusterv1.ClusterNameLabel]
+	namespaceUsed, clusterUsed := 0, 0
+	count := func(claim string, claimCluster string) {
+		if claim == h.claim.Name {
+			return
+		}
+		namespaceUsed++
+		if cluster != "" && claimCluster == cluster {
+			clusterUsed++
+		}
+	}
+
+	for _, a := range addressesInUse {
+		count(a.Spec.ClaimRef.Name, a.GetLabels()[clusterv1.ClusterNameLabel])
+	}
+	for claim, claimCluster := range h.quotas.reserved(pools, addressesInUse) {
+		count(claim.Name, claimCluster)
+	}
+
+	if quota.PerNamespace != nil && namespaceUsed >= *quota.PerNamespace {
+		return fmt.Errorf("%w: namespace %s already uses %d of %d addresses of pool %s",
+			errQuotaExceeded, h.pool.GetNamespace(), namespaceUsed, *quota.PerNamespace, h.pool.GetName())
+	}
+	if quota.PerCluster != nil && cluster != "" && clusterUsed >= *quota.PerCluster {
+		return fmt.Errorf("%w: cluster %s already uses %d of %d addresses of pool %s",
+			errQuotaExceeded, cluster, clusterUsed, *quota.PerCluster, h.pool.GetName())
+	}
+
+	h.quotas.reserve(h.pool, h.claim, cluster)
+	return nil
+}
+
+// sharingPools returns the pool and the other pools of its namespace that share a prefix or ip-range with it.
+func (h *IPAddressClaimHandler) sharingPools(ctx context.Context, keys []string) ([]ipamv1alpha1.NetboxIPPool, error) {
+	poolList := &ipamv1alpha1.NetboxIPPoolList{}
+	if err := h.Client.List(ctx, poolList, client.InNamespace(h.pool.GetNamespace())); err != nil {
+		return nil, errors.Wrap(err, "failed to list pools")
+	}
+
+	pools := []ipamv1alpha1.NetboxIPPool{*h.pool}
+	for _, pool := range poolList.Items {
+		if pool.GetName() == h.pool.GetName() {
+			continue
+		}
+		poolMembers, err := poolutil.AllMembers(&pool)
+		if err != nil {
+			continue
+		}
+		if slices.ContainsFunc(poolMembers, func(member *ipamv1alpha1.NetboxIPPool) bool {
+			return slices.Contains(keys, quotaKey(member))
+		}) {
+			pools = append(pools, pool)
+		}
+	}
+	return pools, nil
+}
+
+// quotaKey returns the key of the lock of the quota checks of the pools of a namespace sharing the prefix or ip-range of
+// the member.
+func quotaKey(member *ipamv1alpha1.NetboxIPPool) string {
+	return fmt.Sprintf("quota/%s/%s/%s/%s", member.GetNamespace(), member.Spec.Type, member.Spec.Vrf, member.Spec.CIDR)
+}
+
+// clusterUsage returns the count of addresses in use per Cluster, sorted by the name of the Cluster. Addresses
+// without the cluster-name label are not counted.
+func clusterUsage(addressesInUse []ipamv1.IPAddress) []ipamv1alpha1.NetboxPoolStatusCluster {
+	used := map[string]int{}
+	for _, a := range addressesInUse {
+		if cluster := a.GetLabels()[clusterv1.ClusterNameLabel]; cluster != "" {
+			used[cluster]++
+		}
+	}
+
+	usage := make([]ipamv1alpha1.NetboxPoolStatusCluster, 0, len(used))
+	for cluster, count := range used {
+		usage = append(usage, ipamv1alpha1.NetboxPoolStatusCluster{Name: cluster, Used: count})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Name < usage[j].Name
+	})
+	return usage
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+)
+
+var _ = Describe("clusterUsage", func() {
+	address := func(cluster string) ipamv1.IPAddress {
+		ipAddress := ipamv1.IPAddress{}
+		if cluster != "" {
+			ipAddress.ObjectMeta = metav1.ObjectMeta{Labels: map[string]string{clusterv1.ClusterNameLabel: cluster}}
+		}
+		return ipAddress
+	}
+
+	It("counts the addresses per cluster", func() {
+		usage := clusterUsage([]ipamv1.IPAddress{address("cluster-b"), address("cluster-a"), address(""), address("cluster-b")})
+		Expect(usage).To(Equal([]ipamv1alpha1.NetboxPoolStatusCluster{
+			{Name: "cluster-a", Used: 1},
+			{Name: "cluster-b", Used: 2},
+		}))
+	})
+})
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"sync"
+
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// addressReservations tracks the addresses that claims adopted or requested, but whose IPAddress is not listed yet.
+// Adopting an address creates nothing in Netbox, and the prefixes and ip-ranges of a claim are unlocked before its
+// IPAddress is created, so without the reservation two claims reconciled in parallel could take the same address.
+type addressReservations struct {
+	mu sync.Mutex
+	// addresses maps the vrf and the address to the claim that reserved it.
+	addresses map[string]client.ObjectKey
+}
+
+func reservationKey(vrf string, address string) string {
+	return vrf + "/" + address
+}
+
+// reserve reserves the address in the vrf for the claim. If another claim reserved the address, the address is not
+// reserved and the other claim is returned. Reservations of claims whose IPAddress is listed in addressesInUse are
+// dropped first, the IPAddress itself keeps the address in use.
+func (r *addressReservations) reserve(vrf string, address string, claim client.Object, addressesInUse []ipamv1.IPAddress) (client.ObjectKey, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, a := range addressesInUse {
+		listed := client.ObjectKey{Namespace: a.Namespace, Name: a.Spec.ClaimRef.Name}
+		for key, owner := range r.addresses {
+			if owner == listed {
+				delete(r.addresses, key)
+			}
+		}
+	}
+
+	key := reservationKey(vrf, address)
+	if owner, ok := r.addresses[key]; ok && owner != client.ObjectKeyFromObject(claim) {
+		return owner, false
+	}
+	if r.addresses == nil {
+		r.addresses = map[string]client.ObjectKey{}
+	}
+	r.addresses[key] = client.ObjectKeyFromObject(claim)
+	return client.ObjectKey{}, true
+}
+
+// release releases the addresses reserved by the claim, if any.
+func (r *addressReservations) release(claim client.Object) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, owner := range r.addresses {
+		if owner == client.ObjectKeyFromObject(claim) {
+			delete(r.addresses, key)
+		}
+	}
+}
@@ -2,18 +2,26 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
 	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
 	corev1 "k8s.io/api/core/v1"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	poolutil "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/pool"
 	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
 )
 
 const (
 	UrlKey      = "url"
 	ApiTokenKey = "apiToken"
+	// ApiVersionKey optionally selects the major version of the Netbox API, "3" or "4". Without it, the version is
+	// detected from the status of Netbox.
+	ApiVersionKey = "apiVersion"
 )
 
 func getSecretForPool(ctx context.Context, cl client.Reader, pool *ipamv1alpha1.NetboxIPPool) (*corev1.Secret, error) {
@@ -39,19 +47,19 @@ func getSecretForPool(ctx context.Context, cl client.Reader, pool *ipamv1alpha1.
 	return secret, nil
 }
 
-func getNetboxClient(secret *corev1.Secret, netboxServiceFactory func(url, apiToken string) (netbox.Client, error)) (netbox.Client, error) {
+func getNetboxClient(secret *corev1.Secret, netboxServiceFactory func(url, apiToken, apiVersion string) (netbox.Client, error)) (netbox.Client, error) {
 	url := getData(secret, UrlKey)
-	if url != "" {
+	if url == "" {
 		return nil, errors.New("can not connect to Netbox, secret must contain url")
 	}
 	apiToken := getData(secret, ApiTokenKey)
-	if url != "" {
+	if apiToken == "" {
 		return nil, errors.New("can not connect to Netbox, secret must contain apiToken")
 	}
 	if netboxServiceFactory == nil {
 		return nil, errors.New("must provide a Netbox service factory")
 	}
-	return netboxServiceFactory(url, apiToken)
+	return netboxServiceFactory(url, apiToken, getData(secret, ApiVersionKey))
 }
 
 func getData(secret *corev1.Secret, key string) string {
@@ -63,3 +71,172 @@ func getData(secret *corev1.Secret, key string) string {
 	}
 	return ""
 }
+
+func getNetboxIPPool(ctx context.Context, nb netbox.Client, pool *ipamv1alpha1.NetboxIPPool) (*netbox.NetboxIPPool, error) {
+	switch pool.Spec.Type {
+	case ipamv1alpha1.PrefixType:
+		return nb.GetPrefix(ctx, pool.Spec.CIDR, pool.Spec.Vrf)
+
+	case ipamv1alpha1.IPRangeType:
+		return nb.GetIPRange(ctx, pool.Spec.CIDR, pool.Spec.Vrf)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown IPPoolType %s", pool.Spec.Type))
+}
+
+// poolPrefixLen returns the prefix length of the CIDR of the pool, which is used for addresses created in Netbox.
+func poolPrefixLen(pool *ipamv1alpha1.NetboxIPPool) (ipaddr.BitCount, error) {
+	cidr, err := ipaddr.NewIPAddressString(pool.Spec.CIDR).ToAddress()
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("could not parse pool CIDR '%s'", pool.Spec.CIDR))
+	}
+	prefixLen := cidr.GetNetworkPrefixLen()
+	if prefixLen == nil {
+		return cidr.GetBitCount(), nil
+	}
+	return prefixLen.Len(), nil
+}
+
+// poolGateway returns the gateway of the pool, or nil if the pool has no (valid) gateway.
+func poolGateway(pool *ipamv1alpha1.NetboxIPPool) *ipaddr.IPAddress {
+	if pool.Spec.Gateway == "" {
+		return nil
+	}
+	gateway, err := ipaddr.NewIPAddressString(pool.Spec.Gateway).ToAddress()
+	if err != nil {
+		return nil
+	}
+	return gateway.WithoutPrefixLen()
+}
+
+// poolMemberFor returns the member of the pool whose CIDR contains the address, without consulting Netbox. If no
+// member contains the address, the first member is returned.
+func poolMemberFor(pool *ipamv1alpha1.NetboxIPPool, address string) *ipamv1alpha1.NetboxIPPool {
+	members := poolutil.Members(pool)
+	ipAddress, err := ipaddr.NewIPAddressString(address).ToAddress()
+	if err == nil {
+		for _, member := range members {
+			cidr, err := ipaddr.NewIPAddressString(member.Spec.CIDR).ToAddress()
+			if err == nil && cidr.ToPrefixBlock().Contains(ipAddress.WithoutPrefixLen()) {
+				return member
+			}
+		}
+	}
+	return members[0]
+}
+
+// claimFamily returns the address family the claim allocates from the pool. This is the family selected by the
+// IPFamilyAnnotation, the family of the requested or adopted address, or else the family of the CIDR of the pool.
+func claimFamily(claim *ipamv1.IPAddressClaim, pool *ipamv1alpha1.NetboxIPPool) (ipaddr.IPVersion, error) {
+	annotations := claim.GetAnnotations()
+	if family, ok := annotations[ipamv1alpha1.IPFamilyAnnotation]; ok {
+		switch {
+		case strings.EqualFold(family, ipaddr.IPv4.String()):
+			return ipaddr.IPv4, nil
+		case strings.EqualFold(family, ipaddr.IPv6.String()):
+			return ipaddr.IPv6, nil
+		}
+		return ipaddr.IndeterminateIPVersion, fmt.Errorf("invalid ip family '%s', must be %s or %s", family, ipaddr.IPv4, ipaddr.IPv6)
+	}
+	for _, annotation := range []string{ipamv1alpha1.RequestedAddressAnnotation, ipamv1alpha1.AdoptAddressAnnotation} {
+		if address, ok := annotations[annotation]; ok {
+			if family := ipaddr.NewIPAddressString(address).GetIPVersion(); family != ipaddr.IndeterminateIPVersion {
+				return family, nil
+			}
+		}
+	}
+	return ipaddr.NewIPAddressString(pool.Spec.CIDR).GetIPVersion(), nil
+}
+
+var (
+	errGatewayNotFound  = errors.New("no gateway found in Netbox")
+	errMultipleGateways = errors.New("multiple gateway candidates found in Netbox")
+)
+
+// discoverGateway looks up the gateway of a prefix or ip-range in Netbox. Candidates are the address in the
+// gateway custom field of the prefix or ip-range, and the addresses in it with the gateway role. There must be
+// exactly one candidate.
+func discoverGateway(ctx context.Context, nb netbox.Client, netboxIPPool *netbox.NetboxIPPool) (*ipaddr.IPAddress, error) {
+	var candidates []*ipaddr.IPAddress
+	addCandidate := func(address string) error {
+		candidate, err := ipaddr.NewIPAddressString(address).ToAddress()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid gateway '%s' in Netbox", address))
+		}
+		candidate = candidate.WithoutPrefixLen()
+		for _, c := range candidates {
+			if c.Equal(candidate) {
+				return nil
+			}
+		}
+		candidates = append(candidates, candidate)
+		return nil
+	}
+
+	if netboxIPPool.Gateway != "" {
+		if err := addCandidate(netboxIPPool.Gateway); err != nil {
+			return nil, err
+		}
+	}
+	ipAddresses, err := nb.GetIPAddresses(ctx, netboxIPPool)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Netbox ip-addresses")
+	}
+	for _, a := range ipAddresses {
+		if a.Role != nil && a.Role.Value == netbox.GatewayRole {
+			if err := addCandidate(a.Address); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("%w for %s", errGatewayNotFound, netboxIPPool.Display)
+	case 1:
+		return candidates[0], nil
+	}
+	var addresses []string
+	for _, c := range candidates {
+		addresses = append(addresses, c.String())
+	}
+	return nil, fmt.Errorf("%w for %s: %s", errMultipleGateways, netboxIPPool.Display, strings.Join(addresses, ", "))
+}
+
+// withDiscoveredGateways returns the pool with the gateways discovered in Netbox filled in, as recorded in the status
+// by the pool reconciler. Gateways configured in the spec take precedence.
+func withDiscoveredGateways(pool *ipamv1alpha1.NetboxIPPool) (*ipamv1alpha1.NetboxIPPool, error) {
+	if !pool.Spec.DiscoverGateway {
+		return pool, nil
+	}
+
+	discovered := pool.DeepCopy()
+	lookup := func(cidr, vrf string) (string, error) {
+		for _, member := range pool.Status.Members {
+			if member.CIDR == cidr && member.Vrf == vrf && member.Gateway != "" {
+				return member.Gateway, nil
+			}
+		}
+		return "", fmt.Errorf("gateway of %s has not been discovered yet", cidr)
+	}
+
+	var err error
+	if discovered.Spec.Gateway == "" {
+		if discovered.Spec.Gateway, err = lookup(pool.Spec.CIDR, pool.Spec.Vrf); err != nil {
+			return nil, err
+		}
+	}
+	if dualStack := discovered.Spec.DualStack; dualStack != nil && dualStack.Gateway == "" {
+		if dualStack.Gateway, err = lookup(dualStack.CIDR, dualStack.Vrf); err != nil {
+			return nil, err
+		}
+	}
+	for i := range discovered.Spec.Fallback {
+		fallback := &discovered.Spec.Fallback[i]
+		if fallback.Gateway == "" {
+			if fallback.Gateway, err = lookup(fallback.CIDR, fallback.Vrf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return discovered, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+	nbmock "github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox/mock"
+)
+
+var _ = Describe("discoverGateway", func() {
+	var (
+		mockCtrl   *gomock.Controller
+		netboxMock *nbmock.MockClient
+		prefix     *netbox.NetboxIPPool
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		netboxMock = nbmock.NewMockClient(mockCtrl)
+		prefix = newNetboxPrefix("10.0.0.0/24")
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	gatewayRole := &netbox.Role{Value: netbox.GatewayRole}
+
+	It("finds the address with the gateway role", func() {
+		netboxMock.EXPECT().GetIPAddresses(gomock.Any(), prefix).Return([]netbox.IPAddress{
+			{Address: "10.0.0.1/24", Role: gatewayRole},
+			{Address: "10.0.0.2/24", Role: &netbox.Role{Value: "vip"}},
+			{Address: "10.0.0.3/24"},
+		}, nil)
+
+		gateway, err := discoverGateway(ctx, netboxMock, prefix)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(gateway.String()).To(Equal("10.0.0.1"))
+	})
+
+	It("finds the gateway in the custom field of the prefix", func() {
+		prefix.Gateway = "10.0.0.254/24"
+		netboxMock.EXPECT().GetIPAddresses(gomock.Any(), prefix).Return([]netbox.IPAddress{
+			{Address: "10.0.0.254/24", Role: gatewayRole},
+		}, nil)
+
+		gateway, err := discoverGateway(ctx, netboxMock, prefix)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(gateway.String()).To(Equal("10.0.0.254"))
+	})
+
+	It("fails without candidates", func() {
+		netboxMock.EXPECT().GetIPAddresses(gomock.Any(), prefix).Return(nil, nil)
+
+		_, err := discoverGateway(ctx, netboxMock, prefix)
+		Expect(err).To(MatchError(errGatewayNotFound))
+	})
+
+	It("fails with multiple candidates", func() {
+		prefix.Gateway = "10.0.0.254"
+		netboxMock.EXPECT().GetIPAddresses(gomock.Any(), prefix).Return([]netbox.IPAddress{
+			{Address: "10.0.0.1/24", Role: gatewayRole},
+		}, nil)
+
+		_, err := discoverGateway(ctx, netboxMock, prefix)
+		Expect(err).To(MatchError(errMultipleGateways))
+		Expect(err).To(MatchError(ContainSubstring("10.0.0.254, 10.0.0.1")))
+	})
+})
+
+var _ = Describe("getNetboxClient", func() {
+	It("passes the api version of the secret to the factory", func() {
+		secret := &corev1.Secret{Data: map[string][]byte{
+			UrlKey:        []byte("http://netbox.local"),
+			ApiTokenKey:   []byte("token"),
+			ApiVersionKey: []byte("4"),
+		}}
+		var apiVersion string
+		_, err := getNetboxClient(secret, func(_, _, v string) (netbox.Client, error) {
+			apiVersion = v
+			return nil, nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(apiVersion).To(Equal("4"))
+	})
+})
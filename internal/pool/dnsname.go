@@ -0,0 +1,42 @@
+package pool
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DNSNameData holds the fields that can be used in the DNS name pattern of a pool.
+type DNSNameData struct {
+	// Claim is the name of the IPAddressClaim.
+	Claim string
+	// Namespace is the namespace of the IPAddressClaim.
+	Namespace string
+	// Machine is the name of the Machine owning the IPAddressClaim.
+	Machine string
+	// Cluster is the name of the Cluster the IPAddressClaim belongs to.
+	Cluster string
+	// Pool is the name of the NetboxIPPool.
+	Pool string
+}
+
+// RenderDNSName renders the DNS name pattern, a Go template, with the given data. The result must be a valid DNS
+// name, which fails for example when a field used by the pattern is empty.
+func RenderDNSName(pattern string, data DNSNameData) (string, error) {
+	tmpl, err := template.New("dnsName").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid dns name pattern")
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", errors.Wrap(err, "invalid dns name pattern")
+	}
+	dnsName := strings.TrimSpace(sb.String())
+	if errs := validation.IsDNS1123Subdomain(dnsName); len(errs) != 0 {
+		return "", fmt.Errorf("dns name '%s' is not valid: %s", dnsName, strings.Join(errs, ", "))
+	}
+	return dnsName, nil
+}
@@ -0,0 +1,58 @@
+package pool
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRenderDNSName(t *testing.T) {
+	data := DNSNameData{
+		Claim:     "claim-0",
+		Namespace: "default",
+		Machine:   "machine-0",
+		Cluster:   "cluster",
+		Pool:      "pool",
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		expected string
+	}{
+		{name: "machine and cluster", pattern: "{{.Machine}}.{{.Cluster}}.example.com", expected: "machine-0.cluster.example.com"},
+		{name: "claim and namespace", pattern: "{{.Claim}}.{{.Namespace}}.example.com", expected: "claim-0.default.example.com"},
+		{name: "pool", pattern: "{{.Machine}}.{{.Pool}}", expected: "machine-0.pool"},
+		{name: "surrounding whitespace", pattern: " {{.Machine}} ", expected: "machine-0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			dnsName, err := RenderDNSName(tt.pattern, data)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(dnsName).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestRenderDNSNameInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		data    DNSNameData
+	}{
+		{name: "syntax error", pattern: "{{.Machine}", data: DNSNameData{Machine: "machine-0"}},
+		{name: "unknown field", pattern: "{{.Host}}.example.com", data: DNSNameData{Machine: "machine-0"}},
+		{name: "empty field", pattern: "{{.Machine}}.example.com", data: DNSNameData{}},
+		{name: "invalid characters", pattern: "{{.Machine}}.example.com", data: DNSNameData{Machine: "Machine_0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			_, err := RenderDNSName(tt.pattern, tt.data)
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
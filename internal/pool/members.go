@@ -0,0 +1,83 @@
+package pool
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+
+	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
+)
+
+// ForFamily returns the pool as seen by claims of the given address family. For the second family of a dual-stack
+// pool, the prefix or ip-range of DualStack replaces the one of the pool. Fallback members and excluded addresses of
+// the other family are dropped.
+func ForFamily(pool *ipamv1alpha1.NetboxIPPool, family ipaddr.IPVersion) (*ipamv1alpha1.NetboxIPPool, error) {
+	familyPool := pool.DeepCopy()
+	familyPool.Spec.DualStack = nil
+	if ipaddr.NewIPAddressString(pool.Spec.CIDR).GetIPVersion() != family {
+		dualStack := pool.Spec.DualStack
+		if dualStack == nil || ipaddr.NewIPAddressString(dualStack.CIDR).GetIPVersion() != family {
+			return nil, fmt.Errorf("pool %s has no %s addresses", pool.Name, family)
+		}
+		setReference(familyPool, *dualStack)
+	}
+
+	familyPool.Spec.Fallback = nil
+	for _, fallback := range pool.Spec.Fallback {
+		if ipaddr.NewIPAddressString(fallback.CIDR).GetIPVersion() == family {
+			familyPool.Spec.Fallback = append(familyPool.Spec.Fallback, fallback)
+		}
+	}
+
+	familyPool.Spec.Exclude = nil
+	for _, exclude := range pool.Spec.Exclude {
+		excluded, err := ParseAddressRange(exclude)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid excluded addresses")
+		}
+		if excluded.GetIPVersion() == family {
+			familyPool.Spec.Exclude = append(familyPool.Spec.Exclude, exclude)
+		}
+	}
+	return familyPool, nil
+}
+
+// Members returns the prefixes and ip-ranges of the pool, in allocation order, each as a pool of its own. The
+// pool must be limited to a single address family by ForFamily.
+func Members(pool *ipamv1alpha1.NetboxIPPool) []*ipamv1alpha1.NetboxIPPool {
+	first := pool.DeepCopy()
+	first.Spec.Fallback = nil
+	members := []*ipamv1alpha1.NetboxIPPool{first}
+	for _, fallback := range pool.Spec.Fallback {
+		member := first.DeepCopy()
+		setReference(member, fallback)
+		members = append(members, member)
+	}
+	return members
+}
+
+// AllMembers returns the prefixes and ip-ranges of the pool of all its address families.
+func AllMembers(pool *ipamv1alpha1.NetboxIPPool) ([]*ipamv1alpha1.NetboxIPPool, error) {
+	families := []ipaddr.IPVersion{ipaddr.NewIPAddressString(pool.Spec.CIDR).GetIPVersion()}
+	if pool.Spec.DualStack != nil {
+		families = append(families, ipaddr.NewIPAddressString(pool.Spec.DualStack.CIDR).GetIPVersion())
+	}
+
+	var members []*ipamv1alpha1.NetboxIPPool
+	for _, family := range families {
+		familyPool, err := ForFamily(pool, family)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, Members(familyPool)...)
+	}
+	return members, nil
+}
+
+func setReference(pool *ipamv1alpha1.NetboxIPPool, ref ipamv1alpha1.NetboxPoolReference) {
+	pool.Spec.Type = ref.Type
+	pool.Spec.CIDR = ref.CIDR
+	pool.Spec.Vrf = ref.Vrf
+	pool.Spec.Gateway = ref.Gateway
+}
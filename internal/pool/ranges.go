@@ -0,0 +1,89 @@
+package pool
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// AddressRanges is a set of address ranges, for example the addresses excluded from a pool.
+type AddressRanges []*ipaddr.SequentialRange[*ipaddr.IPAddress]
+
+// ParseAddressRanges parses a list of single addresses, CIDRs and ranges in the form start-end.
+func ParseAddressRanges(entries []string) (AddressRanges, error) {
+	ranges := make(AddressRanges, 0, len(entries))
+	for _, entry := range entries {
+		rng, err := ParseAddressRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rng)
+	}
+	if len(ranges) > 1 {
+		// Merge overlapping ranges so addresses are not counted twice.
+		ranges = ranges[0].Join(ranges[1:]...)
+	}
+	return ranges, nil
+}
+
+// ParseAddressRange parses a single address, a CIDR or a range in the form start-end.
+func ParseAddressRange(entry string) (*ipaddr.SequentialRange[*ipaddr.IPAddress], error) {
+	if lowerStr, upperStr, ok := strings.Cut(entry, "-"); ok {
+		lower, err := ipaddr.NewIPAddressString(strings.TrimSpace(lowerStr)).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid start address in range '%s'", entry))
+		}
+		upper, err := ipaddr.NewIPAddressString(strings.TrimSpace(upperStr)).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid end address in range '%s'", entry))
+		}
+		if lower.GetIPVersion() != upper.GetIPVersion() {
+			return nil, fmt.Errorf("range '%s' mixes IPv4 and IPv6 addresses", entry)
+		}
+		if lower.WithoutPrefixLen().Compare(upper.WithoutPrefixLen()) > 0 {
+			return nil, fmt.Errorf("start address of range '%s' is after its end address", entry)
+		}
+		return lower.WithoutPrefixLen().SpanWithRange(upper.WithoutPrefixLen()), nil
+	}
+
+	address, err := ipaddr.NewIPAddressString(strings.TrimSpace(entry)).ToAddress()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("invalid address '%s'", entry))
+	}
+	if address.IsPrefixed() {
+		return address.ToPrefixBlock().ToSequentialRange(), nil
+	}
+	return address.ToSequentialRange(), nil
+}
+
+// Contains returns whether the address is part of any of the ranges.
+func (r AddressRanges) Contains(address *ipaddr.IPAddress) bool {
+	address = address.WithoutPrefixLen()
+	for _, rng := range r {
+		if rng.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// CountIn returns the number of addresses of the ranges that are part of rng.
+// Counts greater than int can contain will report as math.MaxInt.
+func (r AddressRanges) CountIn(rng *ipaddr.SequentialRange[*ipaddr.IPAddress]) int {
+	count := 0
+	for _, excluded := range r {
+		intersection := excluded.Intersect(rng)
+		if intersection == nil {
+			continue
+		}
+		n := intersection.GetCount()
+		if !n.IsInt64() || n.Int64() > int64(math.MaxInt-count) {
+			return math.MaxInt
+		}
+		count += int(n.Int64())
+	}
+	return count
+}
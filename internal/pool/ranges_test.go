@@ -0,0 +1,98 @@
+package pool
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+func TestParseAddressRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []string
+		contains []string
+		excludes []string
+		count    int
+	}{
+		{
+			name:     "single addresses",
+			entries:  []string{"10.0.0.1", "10.0.0.5"},
+			contains: []string{"10.0.0.1", "10.0.0.5"},
+			excludes: []string{"10.0.0.2", "10.0.0.6"},
+			count:    2,
+		},
+		{
+			name:     "cidr",
+			entries:  []string{"10.0.0.0/29"},
+			contains: []string{"10.0.0.0", "10.0.0.7"},
+			excludes: []string{"10.0.0.8"},
+			count:    8,
+		},
+		{
+			name:     "range",
+			entries:  []string{"10.0.0.10-10.0.0.19"},
+			contains: []string{"10.0.0.10", "10.0.0.19"},
+			excludes: []string{"10.0.0.9", "10.0.0.20"},
+			count:    10,
+		},
+		{
+			name:     "overlapping entries are counted once",
+			entries:  []string{"10.0.0.0/30", "10.0.0.2-10.0.0.5", "10.0.0.5"},
+			contains: []string{"10.0.0.0", "10.0.0.5"},
+			excludes: []string{"10.0.0.6"},
+			count:    6,
+		},
+		{
+			name:     "ipv6",
+			entries:  []string{"2001:db8::1", "2001:db8::10-2001:db8::1f"},
+			contains: []string{"2001:db8::1", "2001:db8::1a"},
+			excludes: []string{"2001:db8::2"},
+			count:    17,
+		},
+	}
+
+	pool, err := ipaddr.NewIPAddressString("10.0.0.0/24").ToAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool6, err := ipaddr.NewIPAddressString("2001:db8::/64").ToAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ranges, err := ParseAddressRanges(tt.entries)
+			g.Expect(err).ToNot(HaveOccurred())
+			for _, a := range tt.contains {
+				g.Expect(ranges.Contains(ipaddr.NewIPAddressString(a).GetAddress())).To(BeTrue(), a)
+			}
+			for _, a := range tt.excludes {
+				g.Expect(ranges.Contains(ipaddr.NewIPAddressString(a).GetAddress())).To(BeFalse(), a)
+			}
+			g.Expect(ranges.CountIn(pool.ToSequentialRange()) + ranges.CountIn(pool6.ToSequentialRange())).To(Equal(tt.count))
+		})
+	}
+}
+
+func TestParseAddressRangesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{name: "invalid address", entry: "10.0.0.300"},
+		{name: "invalid range start", entry: "10.0.0.a-10.0.0.5"},
+		{name: "reversed range", entry: "10.0.0.5-10.0.0.1"},
+		{name: "mixed range", entry: "10.0.0.1-2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			_, err := ParseAddressRanges([]string{tt.entry})
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
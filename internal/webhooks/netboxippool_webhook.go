@@ -18,16 +18,20 @@ package webhooks
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 
 	poolutil "github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/pool"
 	"github.com/seancfoley/ipaddress-go/ipaddr"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/cluster-api-ipam-provider-in-cluster/api/v1alpha2"
+	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -70,6 +74,9 @@ var (
 
 // SetupWebhookWithManager will set up the manager to manage the webhooks
 func (w *NetboxIPPoolWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if w.Client == nil {
+		return errors.New("webhook needs a client to list the IPAddresses of pools")
+	}
 	err := ctrl.NewWebhookManagedBy(mgr).
 		For(&ipamv1alpha1.NetboxIPPool{}).
 		WithDefaulter(w).
@@ -102,7 +109,7 @@ func (w *NetboxIPPoolWebhook) ValidateCreate(_ context.Context, obj runtime.Obje
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
-func (w *NetboxIPPoolWebhook) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+func (w *NetboxIPPoolWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
 	oldPool, ok := oldObj.(*ipamv1alpha1.NetboxIPPool)
 	if !ok {
 		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a NetboxPool but got a %T", oldObj))
@@ -116,7 +123,7 @@ func (w *NetboxIPPoolWebhook) ValidateUpdate(_ context.Context, oldObj, newObj r
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+	return nil, w.validateImmutable(ctx, oldPool, newPool)
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
@@ -129,12 +136,7 @@ func (w *NetboxIPPoolWebhook) ValidateDelete(ctx context.Context, obj runtime.Ob
 	if _, ok := pool.GetAnnotations()[SkipValidateDeleteWebhookAnnotation]; ok {
 		return nil, nil
 	}
-	poolTypeRef := corev1.TypedLocalObjectReference{
-		APIGroup: ptr.To[string](pool.GetObjectKind().GroupVersionKind().Group),
-		Kind:     pool.GetObjectKind().GroupVersionKind().Kind,
-		Name:     pool.GetName(),
-	}
-	inUseAddresses, err := poolutil.ListAddressesInUse(ctx, w.Client, pool.GetNamespace(), poolTypeRef)
+	inUseAddresses, err := w.addressesInUse(ctx, pool)
 	if err != nil {
 		return nil, apierrors.NewInternalError(err)
 	}
@@ -144,6 +146,92 @@ func (w *NetboxIPPoolWebhook) ValidateDelete(ctx context.Context, obj runtime.Ob
 	return nil, nil
 }
 
+// validateImmutable rejects changes to the CIDR, Vrf and Type of the prefixes and ip-ranges of a pool that has
+// addresses allocated, including those of DualStack and Fallback, as these would orphan the addresses in Netbox,
+// unless the pool has the AllowMigrationAnnotation. Fallback members can be added to such a pool.
+func (w *NetboxIPPoolWebhook) validateImmutable(ctx context.Context, oldPool, newPool *ipamv1alpha1.NetboxIPPool) error {
+	if _, ok := newPool.GetAnnotations()[ipamv1alpha1.AllowMigrationAnnotation]; ok {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	if oldPool.Spec.CIDR != newPool.Spec.CIDR {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "CIDR"),
+			"CIDR can not be changed while the pool has IPAddresses allocated"))
+	}
+	if oldPool.Spec.Vrf != newPool.Spec.Vrf {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "Vrf"),
+			"Vrf can not be changed while the pool has IPAddresses allocated"))
+	}
+	if oldPool.Spec.Type != newPool.Spec.Type {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "Type"),
+			"Type can not be changed while the pool has IPAddresses allocated"))
+	}
+	allErrs = append(allErrs, validateMembersKept(oldPool, newPool)...)
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	inUseAddresses, err := w.addressesInUse(ctx, oldPool)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if len(inUseAddresses) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(v1alpha2.GroupVersion.WithKind(newPool.GetObjectKind().GroupVersionKind().Kind).GroupKind(), newPool.GetName(), allErrs)
+}
+
+// validateMembersKept returns an error for every DualStack and Fallback prefix or ip-range of the old pool that the new
+// pool no longer has. The first member is the CIDR, Vrf and Type of the pool itself, which validateImmutable checks.
+func validateMembersKept(oldPool, newPool *ipamv1alpha1.NetboxIPPool) field.ErrorList {
+	oldMembers, err := poolutil.AllMembers(oldPool)
+	if err != nil {
+		return nil
+	}
+	newMembers, err := poolutil.AllMembers(newPool)
+	if err != nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	for _, oldMember := range oldMembers[1:] {
+		kept := slices.ContainsFunc(newMembers, func(newMember *ipamv1alpha1.NetboxIPPool) bool {
+			return newMember.Spec.CIDR == oldMember.Spec.CIDR && newMember.Spec.Vrf == oldMember.Spec.Vrf &&
+				newMember.Spec.Type == oldMember.Spec.Type
+		})
+		if !kept {
+			allErrs = append(allErrs, field.Forbidden(memberPath(oldPool, oldMember),
+				fmt.Sprintf("%s %s in vrf '%s' can not be changed or removed while the pool has IPAddresses allocated",
+					oldMember.Spec.Type, oldMember.Spec.CIDR, oldMember.Spec.Vrf)))
+		}
+	}
+	return allErrs
+}
+
+// memberPath returns the path of the field of the pool that references the member.
+func memberPath(pool *ipamv1alpha1.NetboxIPPool, member *ipamv1alpha1.NetboxIPPool) *field.Path {
+	if dualStack := pool.Spec.DualStack; dualStack != nil && dualStack.CIDR == member.Spec.CIDR {
+		return field.NewPath("spec", "DualStack")
+	}
+	for i, fallback := range pool.Spec.Fallback {
+		if fallback.CIDR == member.Spec.CIDR {
+			return field.NewPath("spec", "Fallback").Index(i)
+		}
+	}
+	return field.NewPath("spec")
+}
+
+// addressesInUse returns the IPAddresses allocated from the pool.
+func (w *NetboxIPPoolWebhook) addressesInUse(ctx context.Context, pool *ipamv1alpha1.NetboxIPPool) ([]ipamv1.IPAddress, error) {
+	poolTypeRef := corev1.TypedLocalObjectReference{
+		APIGroup: ptr.To[string](pool.GetObjectKind().GroupVersionKind().Group),
+		Kind:     pool.GetObjectKind().GroupVersionKind().Kind,
+		Name:     pool.GetName(),
+	}
+	return poolutil.ListAddressesInUse(ctx, w.Client, pool.GetNamespace(), poolTypeRef)
+}
+
 func (w *NetboxIPPoolWebhook) validate(newPool *ipamv1alpha1.NetboxIPPool) (reterr error) {
 	var allErrs field.ErrorList
 	defer func() {
@@ -190,5 +278,208 @@ func (w *NetboxIPPoolWebhook) validate(newPool *ipamv1alpha1.NetboxIPPool) (rete
 		}
 	}
 
+	var dualStackCIDR *ipaddr.IPAddress
+	if dualStack := newPool.Spec.DualStack; dualStack != nil {
+		var errs field.ErrorList
+		dualStackCIDR, errs = validatePoolReference(field.NewPath("spec", "DualStack"), "DualStack", dualStack)
+		allErrs = append(allErrs, errs...)
+
+		if cidr != nil && dualStackCIDR != nil && cidr.GetIPVersion() == dualStackCIDR.GetIPVersion() {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "DualStack", "CIDR"),
+				dualStack.CIDR, "CIDR and DualStack CIDR must be of different IP families"))
+		}
+
+		if newPool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "DualStack"),
+				dualStack.CIDR, "DualStack can not be used in Prefix allocation mode"))
+		}
+	}
+
+	memberCIDRs := []*ipaddr.IPAddress{cidr, dualStackCIDR}
+	for i := range newPool.Spec.Fallback {
+		fallback := &newPool.Spec.Fallback[i]
+		path := field.NewPath("spec", "Fallback").Index(i)
+		fallbackCIDR, errs := validatePoolReference(path, "Fallback", fallback)
+		allErrs = append(allErrs, errs...)
+		if fallbackCIDR == nil {
+			continue
+		}
+		memberCIDRs = append(memberCIDRs, fallbackCIDR)
+
+		if (cidr == nil || cidr.GetIPVersion() != fallbackCIDR.GetIPVersion()) &&
+			(dualStackCIDR == nil || dualStackCIDR.GetIPVersion() != fallbackCIDR.GetIPVersion()) {
+			allErrs = append(allErrs, field.Invalid(path.Child("CIDR"),
+				fallback.CIDR, "Fallback CIDR must be of the IP family of CIDR or DualStack CIDR"))
+		}
+
+		if newPool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+			if fallback.Type != ipamv1alpha1.PrefixType {
+				allErrs = append(allErrs, field.Invalid(path.Child("Type"),
+					fallback.Type, "Prefix allocation mode requires a pool of type Prefix"))
+			}
+			prefixLen := fallbackCIDR.GetNetworkPrefixLen()
+			if prefixLen == nil || newPool.Spec.PrefixLength <= prefixLen.Len() || newPool.Spec.PrefixLength > fallbackCIDR.GetBitCount() {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "PrefixLength"),
+					newPool.Spec.PrefixLength, "PrefixLength must be longer than the prefix length of the Fallback CIDR"))
+			}
+		}
+	}
+
+	for i, exclude := range newPool.Spec.Exclude {
+		excluded, err := poolutil.ParseAddressRange(exclude)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "Exclude").Index(i),
+				exclude, "Exclude is not a valid address, CIDR or range"+" "+err.Error()))
+			continue
+		}
+
+		contained := false
+		for _, memberCIDR := range memberCIDRs {
+			if memberCIDR != nil && memberCIDR.ToPrefixBlock().ToSequentialRange().ContainsRange(excluded) {
+				contained = true
+			}
+		}
+		if cidr != nil && !contained {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "Exclude").Index(i), exclude, "CIDR must contain excluded addresses"))
+		}
+	}
+
+	if newPool.Spec.DNSNamePattern != "" {
+		// Rendering the pattern with sample data catches syntax errors, unknown fields and invalid DNS names.
+		sample := poolutil.DNSNameData{Claim: "claim", Namespace: "namespace", Machine: "machine", Cluster: "cluster", Pool: "pool"}
+		if _, err := poolutil.RenderDNSName(newPool.Spec.DNSNamePattern, sample); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "DNSNamePattern"),
+				newPool.Spec.DNSNamePattern, "DNSNamePattern is not a valid pattern"+" "+err.Error()))
+		}
+	}
+
+	if vmInterface := newPool.Spec.VMInterface; vmInterface != nil {
+		if vmInterface.MatchBy == ipamv1alpha1.CustomFieldMatchStrategy && vmInterface.CustomField == "" {
+			allErrs = append(allErrs, field.Required(field.NewPath("spec", "VMInterface", "CustomField"),
+				"CustomField is required when matching virtual machines by CustomField"))
+		}
+	}
+
+	if quarantine := newPool.Spec.Quarantine; quarantine != nil && quarantine.Period.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "Quarantine", "Period"),
+			quarantine.Period.Duration.String(), "Period must be positive"))
+	}
+
+	if resync := newPool.Spec.ResyncInterval; resync != nil && resync.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "ResyncInterval"),
+			resync.Duration.String(), "ResyncInterval can not be negative"))
+	}
+
+	if thresholds := newPool.Spec.Thresholds; thresholds != nil {
+		allErrs = append(allErrs, validateThreshold(field.NewPath("spec", "Thresholds", "Warning"), thresholds.Warning)...)
+		allErrs = append(allErrs, validateThreshold(field.NewPath("spec", "Thresholds", "Critical"), thresholds.Critical)...)
+	}
+
+	if newPool.Spec.AllocationMode == ipamv1alpha1.PrefixAllocationMode {
+		if newPool.Spec.Type != ipamv1alpha1.PrefixType {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "AllocationMode"),
+				newPool.Spec.AllocationMode, "Prefix allocation mode requires a pool of type Prefix"))
+		}
+
+		if cidr != nil {
+			prefixLen := cidr.GetNetworkPrefixLen()
+			if prefixLen == nil || newPool.Spec.PrefixLength <= prefixLen.Len() || newPool.Spec.PrefixLength > cidr.GetBitCount() {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "PrefixLength"),
+					newPool.Spec.PrefixLength, "PrefixLength must be longer than the prefix length of the CIDR"))
+			}
+		}
+
+		if newPool.Spec.Gateway != "" {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "Gateway"),
+				newPool.Spec.Gateway, "Gateway can not be used in Prefix allocation mode"))
+		}
+
+		if len(newPool.Spec.Exclude) > 0 {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "Exclude"),
+				newPool.Spec.Exclude, "Exclude can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.AdoptExisting {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "AdoptExisting"),
+				newPool.Spec.AdoptExisting, "AdoptExisting can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.DiscoverGateway {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "DiscoverGateway"),
+				newPool.Spec.DiscoverGateway, "DiscoverGateway can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.DNSNamePattern != "" {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "DNSNamePattern"),
+				newPool.Spec.DNSNamePattern, "DNSNamePattern can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.VMInterface != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "VMInterface"),
+				newPool.Spec.VMInterface, "VMInterface can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.PrimaryAddress {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "PrimaryAddress"),
+				newPool.Spec.PrimaryAddress, "PrimaryAddress can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.Status != "" {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "Status"),
+				newPool.Spec.Status, "Status can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.Quarantine != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "Quarantine"),
+				newPool.Spec.Quarantine, "Quarantine can not be used in Prefix allocation mode"))
+		}
+
+		if newPool.Spec.AllocationStrategy != "" && newPool.Spec.AllocationStrategy != ipamv1alpha1.FirstFreeAllocationStrategy {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "AllocationStrategy"),
+				newPool.Spec.AllocationStrategy, "AllocationStrategy can not be used in Prefix allocation mode"))
+		}
+	} else if newPool.Spec.PrefixLength != 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "PrefixLength"),
+			newPool.Spec.PrefixLength, "PrefixLength can only be used in Prefix allocation mode"))
+	}
+
 	return //nolint:nakedret
 }
+
+// validateThreshold validates a capacity threshold, which is either a count or a percentage of free IPs.
+func validateThreshold(path *field.Path, threshold *intstr.IntOrString) field.ErrorList {
+	if threshold == nil {
+		return nil
+	}
+	value, err := intstr.GetScaledValueFromIntOrPercent(threshold, 100, true)
+	if err != nil || value < 0 {
+		return field.ErrorList{field.Invalid(path, threshold.String(),
+			"threshold must be a non-negative count or a percentage like \"10%\"")}
+	}
+	return nil
+}
+
+// validatePoolReference validates a prefix or ip-range referenced by the pool next to its CIDR. It returns the parsed
+// CIDR of the reference, or nil if it is not valid.
+func validatePoolReference(path *field.Path, name string, ref *ipamv1alpha1.NetboxPoolReference) (*ipaddr.IPAddress, field.ErrorList) {
+	var allErrs field.ErrorList
+
+	cidr, err := ipaddr.NewIPAddressString(ref.CIDR).ToAddress()
+	if err != nil || cidr.String() != ref.CIDR {
+		allErrs = append(allErrs, field.Invalid(path.Child("CIDR"), ref.CIDR, name+" CIDR is not a valid CIDR"))
+		cidr = nil
+	}
+
+	if ref.Gateway != "" {
+		gatewayIP, err := ipaddr.NewIPAddressString(ref.Gateway).ToAddress()
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("Gateway"),
+				ref.Gateway, name+" Gateway is not a valid IP address"+" "+err.Error()))
+		} else if cidr != nil && !cidr.ToPrefixBlock().Contains(gatewayIP) {
+			allErrs = append(allErrs, field.Invalid(path.Child("Gateway"),
+				ref.Gateway, fmt.Sprintf("%s CIDR must contain %s gateway", name, name)))
+		}
+	}
+
+	return cidr, allErrs
+}
@@ -18,16 +18,23 @@ package webhooks
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
 	"k8s.io/utils/ptr"
 	ipamv1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	ipamv1alpha1 "github.com/erwin-kok/cluster-api-ipam-provider-netbox/api/v1alpha1"
 	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/index"
@@ -42,6 +49,34 @@ var _ = Describe("NetboxIPPool Webhook", func() {
 		Expect(webhook.Default(ctx, netboxIPPool)).To(Succeed())
 	})
 
+	It("is set up with the client of the manager", func() {
+		scheme := runtime.NewScheme()
+		Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
+		Expect(ipamv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		// The manager is created like in main.go, but it is not started and does not discover the API server.
+		mgr, err := ctrl.NewManager(&rest.Config{Host: "https://127.0.0.1:6443"}, ctrl.Options{
+			Scheme:  scheme,
+			Metrics: metricsserver.Options{BindAddress: "0"},
+			MapperProvider: func(*rest.Config, *http.Client) (meta.RESTMapper, error) {
+				mapper := meta.NewDefaultRESTMapper(nil)
+				for gvk := range scheme.AllKnownTypes() {
+					mapper.Add(gvk, meta.RESTScopeNamespace)
+				}
+				return mapper, nil
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(index.SetupIndexes(ctx, mgr)).To(Succeed())
+
+		Expect((&NetboxIPPoolWebhook{}).SetupWebhookWithManager(mgr)).To(MatchError(ContainSubstring("needs a client")))
+
+		webhook := &NetboxIPPoolWebhook{
+			Client: mgr.GetClient(),
+		}
+		Expect(webhook.SetupWebhookWithManager(mgr)).To(Succeed())
+	})
+
 	It("test creating NetboxIPPool", func() {
 		scheme := runtime.NewScheme()
 		Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
@@ -69,6 +104,52 @@ var _ = Describe("NetboxIPPool Webhook", func() {
 
 		_, err := webhook.ValidateCreate(ctx, namespacedPool)
 		Expect(err).ToNot(HaveOccurred(), "should allow pool without Gateway")
+
+		namespacedPool.Spec.Exclude = []string{"192.168.1.1", "192.168.1.0/28", "192.168.1.250-192.168.1.255"}
+		_, err = webhook.ValidateCreate(ctx, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow excluded addresses within the CIDR")
+
+		namespacedPool.Spec.DualStack = &ipamv1alpha1.NetboxPoolReference{
+			Type:    ipamv1alpha1.IPRangeType,
+			CIDR:    "2001:db8::10/64",
+			Vrf:     "other",
+			Gateway: "2001:db8::1",
+		}
+		namespacedPool.Spec.Exclude = append(namespacedPool.Spec.Exclude, "2001:db8::11")
+		_, err = webhook.ValidateCreate(ctx, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow a dual-stack pool")
+
+		namespacedPool.Spec.Fallback = []ipamv1alpha1.NetboxPoolReference{
+			{Type: ipamv1alpha1.PrefixType, CIDR: "192.168.2.0/24", Gateway: "192.168.2.1"},
+			{Type: ipamv1alpha1.PrefixType, CIDR: "2001:db9::/64"},
+		}
+		namespacedPool.Spec.Exclude = append(namespacedPool.Spec.Exclude, "192.168.2.2", "2001:db9::2")
+		_, err = webhook.ValidateCreate(ctx, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow fallback members")
+		namespacedPool.Spec.Fallback = nil
+		namespacedPool.Spec.DualStack = nil
+
+		namespacedPool.Spec.Exclude = nil
+		namespacedPool.Spec.DNSNamePattern = "{{.Machine}}.{{.Cluster}}.example.com"
+		_, err = webhook.ValidateCreate(ctx, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow a dns name pattern")
+		namespacedPool.Spec.DNSNamePattern = ""
+
+		namespacedPool.Spec.VMInterface = &ipamv1alpha1.VMInterfaceAssignment{
+			MatchBy:     ipamv1alpha1.CustomFieldMatchStrategy,
+			CustomField: "machine_uid",
+			Create:      true,
+		}
+		_, err = webhook.ValidateCreate(ctx, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow assigning addresses to virtual machine interfaces")
+		namespacedPool.Spec.VMInterface = nil
+
+		namespacedPool.Spec.Gateway = ""
+		namespacedPool.Spec.Type = ipamv1alpha1.PrefixType
+		namespacedPool.Spec.AllocationMode = ipamv1alpha1.PrefixAllocationMode
+		namespacedPool.Spec.PrefixLength = 28
+		_, err = webhook.ValidateCreate(ctx, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow prefix allocation mode")
 	})
 
 	It("test updating NetboxIPPool", func() {
@@ -108,7 +189,124 @@ var _ = Describe("NetboxIPPool Webhook", func() {
 		namespacedPool.Spec.Gateway = "192.168.2.1"
 
 		_, err := webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
-		Expect(err).ToNot(HaveOccurred(), "should not allow removing in use IPs from addresses field in pool")
+		Expect(err).To(HaveOccurred(), "should not allow changing the CIDR of a pool with IPs in use")
+		Expect(err.Error()).To(ContainSubstring("CIDR can not be changed while the pool has IPAddresses allocated"))
+
+		namespacedPool.Annotations = map[string]string{ipamv1alpha1.AllowMigrationAnnotation: ""}
+		_, err = webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow changing the CIDR of a pool with the migration annotation")
+
+		namespacedPool.Annotations = nil
+		Expect(fakeClient.DeleteAllOf(ctx, &ipamv1.IPAddress{}, client.InNamespace("test-namespace"))).To(Succeed())
+		_, err = webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow changing the CIDR of a pool without IPs in use")
+	})
+
+	It("test changing the Vrf and Type of NetboxIPPool with IPs in use", func() {
+		scheme := runtime.NewScheme()
+		Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
+
+		namespacedPool := &ipamv1alpha1.NetboxIPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-pool",
+				Namespace: "test-namespace",
+			},
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{
+				CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				Type:           ipamv1alpha1.PrefixType,
+				CIDR:           "192.168.1.0/24",
+			},
+		}
+
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(createIP("address00", "192.168.1.2", namespacedPool)).
+			WithIndex(&ipamv1.IPAddress{}, index.IPAddressPoolRefCombinedField, index.IPAddressByCombinedPoolRef).
+			Build()
+
+		webhook := NetboxIPPoolWebhook{
+			Client: fakeClient,
+		}
+
+		oldNamespacedPool := namespacedPool.DeepCopyObject()
+		namespacedPool.Spec.Vrf = "other-vrf"
+		namespacedPool.Spec.Type = ipamv1alpha1.IPRangeType
+
+		_, err := webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Vrf can not be changed while the pool has IPAddresses allocated"))
+		Expect(err.Error()).To(ContainSubstring("Type can not be changed while the pool has IPAddresses allocated"))
+	})
+
+	It("test changing the DualStack and Fallback of NetboxIPPool with IPs in use", func() {
+		scheme := runtime.NewScheme()
+		Expect(ipamv1.AddToScheme(scheme)).To(Succeed())
+
+		namespacedPool := &ipamv1alpha1.NetboxIPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-pool",
+				Namespace: "test-namespace",
+			},
+			Spec: ipamv1alpha1.NetboxIPPoolSpec{
+				CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				Type:           ipamv1alpha1.PrefixType,
+				CIDR:           "192.168.1.0/24",
+				DualStack:      &ipamv1alpha1.NetboxPoolReference{Type: ipamv1alpha1.PrefixType, CIDR: "fd00::/64"},
+				Fallback: []ipamv1alpha1.NetboxPoolReference{
+					{Type: ipamv1alpha1.PrefixType, CIDR: "192.168.2.0/24"},
+				},
+			},
+		}
+
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(createIP("address00", "192.168.2.2", namespacedPool)).
+			WithIndex(&ipamv1.IPAddress{}, index.IPAddressPoolRefCombinedField, index.IPAddressByCombinedPoolRef).
+			Build()
+
+		webhook := NetboxIPPoolWebhook{
+			Client: fakeClient,
+		}
+		oldNamespacedPool := namespacedPool.DeepCopy()
+
+		By("changing the vrf of the DualStack prefix")
+		namespacedPool.Spec.DualStack.Vrf = "other-vrf"
+		_, err := webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("spec.DualStack: Forbidden: Prefix fd00::/64 in vrf '' can not be changed or removed"))
+
+		By("removing the DualStack prefix")
+		namespacedPool.Spec.DualStack = nil
+		_, err = webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("spec.DualStack: Forbidden"))
+
+		By("changing the CIDR of a Fallback prefix")
+		namespacedPool = oldNamespacedPool.DeepCopy()
+		namespacedPool.Spec.Fallback[0].CIDR = "192.168.3.0/24"
+		_, err = webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("spec.Fallback[0]: Forbidden: Prefix 192.168.2.0/24 in vrf '' can not be changed or removed"))
+
+		By("removing the Fallback prefix")
+		namespacedPool.Spec.Fallback = nil
+		_, err = webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("spec.Fallback[0]: Forbidden"))
+
+		By("adding a Fallback prefix")
+		namespacedPool = oldNamespacedPool.DeepCopy()
+		namespacedPool.Spec.Fallback = append(namespacedPool.Spec.Fallback,
+			ipamv1alpha1.NetboxPoolReference{Type: ipamv1alpha1.PrefixType, CIDR: "192.168.3.0/24"})
+		_, err = webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).ToNot(HaveOccurred(), "should allow adding Fallback prefixes to a pool with IPs in use")
+
+		By("changing the DualStack prefix with the migration annotation")
+		namespacedPool = oldNamespacedPool.DeepCopy()
+		namespacedPool.Spec.DualStack = nil
+		namespacedPool.Annotations = map[string]string{ipamv1alpha1.AllowMigrationAnnotation: ""}
+		_, err = webhook.ValidateUpdate(ctx, oldNamespacedPool, namespacedPool)
+		Expect(err).ToNot(HaveOccurred())
 	})
 
 	It("test deleting with existing ip addresses", func() {
@@ -275,6 +473,299 @@ var _ = Describe("NetboxIPPool Webhook", func() {
 				"CIDR must contain gateway",
 			),
 
+			Entry("prefix allocation mode requires a Prefix pool",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.IPRangeType,
+					CIDR:           "10.0.0.10/24",
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Prefix allocation mode requires a pool of type Prefix",
+			),
+
+			Entry("prefix length must be longer than the CIDR",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   24,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"PrefixLength must be longer than the prefix length of the CIDR",
+			),
+
+			Entry("prefix length must fit the address family",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   33,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"PrefixLength must be longer than the prefix length of the CIDR",
+			),
+
+			Entry("gateway can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					Gateway:        "10.0.0.1",
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Gateway can not be used in Prefix allocation mode",
+			),
+
+			Entry("gateway discovery can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:            ipamv1alpha1.PrefixType,
+					CIDR:            "10.0.0.0/24",
+					DiscoverGateway: true,
+					AllocationMode:  ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:    28,
+					CredentialsRef:  &corev1.SecretReference{Name: "a-secret"},
+				},
+				"DiscoverGateway can not be used in Prefix allocation mode",
+			),
+
+			Entry("dns name pattern can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					DNSNamePattern: "{{.Machine}}.example.com",
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"DNSNamePattern can not be used in Prefix allocation mode",
+			),
+
+			Entry("dns name pattern must be a valid template",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					DNSNamePattern: "{{.Machine}.example.com",
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"DNSNamePattern is not a valid pattern",
+			),
+
+			Entry("dns name pattern must only use known fields",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					DNSNamePattern: "{{.Host}}.example.com",
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"DNSNamePattern is not a valid pattern",
+			),
+
+			Entry("vm interface can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					VMInterface:    &ipamv1alpha1.VMInterfaceAssignment{MatchBy: ipamv1alpha1.NameMatchStrategy},
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"VMInterface can not be used in Prefix allocation mode",
+			),
+
+			Entry("primary address can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					PrimaryAddress: true,
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"PrimaryAddress can not be used in Prefix allocation mode",
+			),
+
+			Entry("status can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					Status:         ipamv1alpha1.ReservedAddressStatus,
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Status can not be used in Prefix allocation mode",
+			),
+
+			Entry("quarantine can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					Quarantine:     &ipamv1alpha1.AddressQuarantine{Period: metav1.Duration{Duration: time.Hour}},
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Quarantine can not be used in Prefix allocation mode",
+			),
+
+			Entry("allocation strategy can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:               ipamv1alpha1.PrefixType,
+					CIDR:               "10.0.0.0/24",
+					AllocationStrategy: ipamv1alpha1.RandomAllocationStrategy,
+					AllocationMode:     ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:       28,
+					CredentialsRef:     &corev1.SecretReference{Name: "a-secret"},
+				},
+				"AllocationStrategy can not be used in Prefix allocation mode",
+			),
+
+			Entry("quarantine requires a positive period",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					Quarantine:     &ipamv1alpha1.AddressQuarantine{},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Period must be positive",
+			),
+
+			Entry("resync interval can not be negative",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					ResyncInterval: &metav1.Duration{Duration: -time.Minute},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"ResyncInterval can not be negative",
+			),
+
+			Entry("thresholds must be a count or a percentage",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					Thresholds:     &ipamv1alpha1.CapacityThresholds{Warning: ptr.To(intstr.FromString("ten"))},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"threshold must be a non-negative count or a percentage",
+			),
+
+			Entry("thresholds can not be negative",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					Thresholds:     &ipamv1alpha1.CapacityThresholds{Critical: ptr.To(intstr.FromInt32(-1))},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"threshold must be a non-negative count or a percentage",
+			),
+
+			Entry("matching virtual machines by custom field requires the custom field",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					VMInterface:    &ipamv1alpha1.VMInterfaceAssignment{MatchBy: ipamv1alpha1.CustomFieldMatchStrategy},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"CustomField is required when matching virtual machines by CustomField",
+			),
+
+			Entry("prefix length requires prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					PrefixLength:   28,
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"PrefixLength can only be used in Prefix allocation mode",
+			),
+
+			Entry("dual-stack CIDR must be valid",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					DualStack:      &ipamv1alpha1.NetboxPoolReference{Type: ipamv1alpha1.PrefixType, CIDR: "2001:db8::/644"},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"DualStack CIDR is not a valid CIDR",
+			),
+
+			Entry("dual-stack CIDR must be of the other IP family",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					DualStack:      &ipamv1alpha1.NetboxPoolReference{Type: ipamv1alpha1.PrefixType, CIDR: "10.0.1.0/24"},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"CIDR and DualStack CIDR must be of different IP families",
+			),
+
+			Entry("dual-stack gateway must be within the dual-stack CIDR",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR: "10.0.0.0/24",
+					DualStack: &ipamv1alpha1.NetboxPoolReference{
+						Type:    ipamv1alpha1.PrefixType,
+						CIDR:    "2001:db8::/64",
+						Gateway: "2001:db9::1",
+					},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"DualStack CIDR must contain DualStack gateway",
+			),
+
+			Entry("dual-stack can not be used in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					DualStack:      &ipamv1alpha1.NetboxPoolReference{Type: ipamv1alpha1.PrefixType, CIDR: "2001:db8::/64"},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"DualStack can not be used in Prefix allocation mode",
+			),
+
+			Entry("fallback CIDR must be valid",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					Fallback:       []ipamv1alpha1.NetboxPoolReference{{Type: ipamv1alpha1.PrefixType, CIDR: "10.0.1.0/33"}},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Fallback CIDR is not a valid CIDR",
+			),
+
+			Entry("fallback CIDR must be of a family of the pool",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					Fallback:       []ipamv1alpha1.NetboxPoolReference{{Type: ipamv1alpha1.PrefixType, CIDR: "2001:db8::/64"}},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Fallback CIDR must be of the IP family of CIDR or DualStack CIDR",
+			),
+
+			Entry("fallback gateway must be within the fallback CIDR",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR: "10.0.0.0/24",
+					Fallback: []ipamv1alpha1.NetboxPoolReference{
+						{Type: ipamv1alpha1.PrefixType, CIDR: "10.0.1.0/24", Gateway: "10.0.2.1"},
+					},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Fallback CIDR must contain Fallback gateway",
+			),
+
+			Entry("fallback must be a prefix in prefix allocation mode",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					Type:           ipamv1alpha1.PrefixType,
+					CIDR:           "10.0.0.0/24",
+					AllocationMode: ipamv1alpha1.PrefixAllocationMode,
+					PrefixLength:   28,
+					Fallback:       []ipamv1alpha1.NetboxPoolReference{{Type: ipamv1alpha1.IPRangeType, CIDR: "10.0.1.10/24"}},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Prefix allocation mode requires a pool of type Prefix",
+			),
+
 			Entry("IPv4 subnet and IPv6 gateway should not be allowed",
 				ipamv1alpha1.NetboxIPPoolSpec{
 					CIDR:           "10.0.0.3/30",
@@ -284,6 +775,42 @@ var _ = Describe("NetboxIPPool Webhook", func() {
 				"CIDR and gateway are mixed IPv4 and IPv6 addresses",
 			),
 
+			Entry("invalid exclusion should not be allowed",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					Exclude:        []string{"10.0.0.1", "10.0.0.300"},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"Exclude is not a valid address, CIDR or range",
+			),
+
+			Entry("excluded address must be within CIDR range",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					Exclude:        []string{"10.0.1.1"},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"CIDR must contain excluded addresses",
+			),
+
+			Entry("excluded CIDR must be within CIDR range",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					Exclude:        []string{"10.0.0.0/23"},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"CIDR must contain excluded addresses",
+			),
+
+			Entry("excluded range must be within CIDR range",
+				ipamv1alpha1.NetboxIPPoolSpec{
+					CIDR:           "10.0.0.0/24",
+					Exclude:        []string{"10.0.0.250-10.0.1.5"},
+					CredentialsRef: &corev1.SecretReference{Name: "a-secret"},
+				},
+				"CIDR must contain excluded addresses",
+			),
+
 			Entry("IPv4 subnet and IPv6 gateway should not be allowed",
 				ipamv1alpha1.NetboxIPPoolSpec{
 					CIDR:           "2001:db8::0/64",
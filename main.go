@@ -17,8 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"flag"
 	"os"
+	"time"
 
 	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -32,6 +34,7 @@ import (
 	capiflags "sigs.k8s.io/cluster-api/util/flags"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
@@ -56,6 +59,16 @@ var (
 	watchFilter            string
 	webhookPort            int
 	webhookCertDir         string
+	enableInventorySync    bool
+	inventoryClusterType   string
+	inventoryTag           string
+	quarantineInterval     time.Duration
+	claimConcurrency       int
+	allocationBatchWindow  time.Duration
+	poolResyncInterval     time.Duration
+	netboxClientMaxAge     time.Duration
+	netboxWebhookAddress   string
+	netboxWebhookSecret    string
 )
 
 func init() {
@@ -121,29 +134,84 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The clients of a Netbox are shared by the controllers, so that its version is not detected with every reconcile.
+	netboxClients := netbox.NewClientCache(netbox.NewNetBoxClientFor, netboxClientMaxAge)
+
+	var batcher *netbox.AddressBatcher
+	if allocationBatchWindow > 0 {
+		batcher = netbox.NewAddressBatcher(allocationBatchWindow)
+	}
+
 	if err = (&ipamutil.ClaimReconciler{
 		Client:           mgr.GetClient(),
 		Scheme:           mgr.GetScheme(),
 		WatchFilterValue: watchFilter,
 		Adapter: &controllers.NetboxProviderAdapter{
-			NetboxServiceFactory: func(url, apiToken string) (netbox.Client, error) {
-				return netbox.NewNetBoxClient(url, apiToken), nil
-			},
+			NetboxServiceFactory:    netboxClients.ClientFor,
+			MaxConcurrentReconciles: claimConcurrency,
+			Batcher:                 batcher,
 		},
 	}).SetupWithManager(ctx, mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "IPAddressClaim")
 		os.Exit(1)
 	}
 
+	var poolEvents chan event.GenericEvent
+	if netboxWebhookAddress != "" {
+		secret, err := os.ReadFile(netboxWebhookSecret)
+		if err != nil {
+			setupLog.Error(err, "unable to read Netbox webhook secret")
+			os.Exit(1)
+		}
+		poolEvents = make(chan event.GenericEvent)
+		if err := (&controllers.NetboxWebhookReceiver{
+			Client:      mgr.GetClient(),
+			BindAddress: netboxWebhookAddress,
+			Secret:      bytes.TrimSpace(secret),
+			Events:      poolEvents,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create Netbox webhook receiver")
+			os.Exit(1)
+		}
+	}
+
 	if err := (&controllers.NetboxIPPoolReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:               mgr.GetClient(),
+		Scheme:               mgr.GetScheme(),
+		NetboxServiceFactory: netboxClients.ClientFor,
+		Recorder:             mgr.GetEventRecorderFor("netboxippool-controller"),
+		ResyncInterval:       poolResyncInterval,
+		Events:               poolEvents,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NetboxIPPool")
 		os.Exit(1)
 	}
 
-	if err = (&webhooks.NetboxIPPoolWebhook{}).SetupWebhookWithManager(mgr); err != nil {
+	if enableInventorySync {
+		if err := (&controllers.InventoryReconciler{
+			Client:               mgr.GetClient(),
+			Scheme:               mgr.GetScheme(),
+			NetboxServiceFactory: netboxClients.ClientFor,
+			ClusterType:          inventoryClusterType,
+			Tag:                  inventoryTag,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Inventory")
+			os.Exit(1)
+		}
+	}
+
+	if err := (&controllers.QuarantineCleaner{
+		Client:               mgr.GetClient(),
+		NetboxServiceFactory: netboxClients.ClientFor,
+		Interval:             quarantineInterval,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create quarantine cleaner")
+		os.Exit(1)
+	}
+
+	if err = (&webhooks.NetboxIPPoolWebhook{
+		Client: mgr.GetClient(),
+	}).SetupWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "NetboxIPPool")
 		os.Exit(1)
 	}
@@ -179,5 +247,34 @@ func InitFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&webhookCertDir, "webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs/",
 		"Webhook cert dir, only used when webhook-port is specified.")
 	fs.StringVar(&healthProbeBindAddress, "health-addr", ":9440", "The address the health endpoint binds to.")
+
+	fs.IntVar(&claimConcurrency, "claim-concurrency", 10,
+		"Number of IPAddressClaims to process simultaneously. Claims allocating from the same prefix or ip-range are always allocated one at a time.")
+
+	fs.DurationVar(&allocationBatchWindow, "allocation-batch-window", 0,
+		"Time to wait for other IPAddressClaims of the same pool, to allocate their addresses with one request to Netbox. "+
+			"Batching is disabled if 0.")
+
+	fs.DurationVar(&poolResyncInterval, "pool-resync-interval", 10*time.Minute,
+		"Interval at which the usage of NetboxIPPools is refreshed from Netbox, unless a pool sets its own resyncInterval. "+
+			"Pools are only refreshed when they or their IPAddresses change if 0.")
+
+	fs.DurationVar(&netboxClientMaxAge, "netbox-client-max-age", 10*time.Minute,
+		"Time a Netbox client is reused by the controllers before it is created again. The version of Netbox is detected once per client.")
+
+	fs.StringVar(&netboxWebhookAddress, "netbox-webhook-bind-address", "",
+		"The address to receive Netbox webhooks on, to refresh NetboxIPPools as soon as their addresses change in Netbox. "+
+			"Netbox webhooks are not received if empty.")
+	fs.StringVar(&netboxWebhookSecret, "netbox-webhook-secret-file", "",
+		"File containing the secret of the Netbox webhooks, which is used to verify their signature.")
+
+	fs.BoolVar(&enableInventorySync, "enable-inventory-sync", false,
+		"Enable syncing Clusters and their Machines into Netbox as virtualization clusters and virtual machines.")
+	fs.StringVar(&inventoryClusterType, "inventory-cluster-type", controllers.DefaultInventoryClusterType,
+		"Slug of the Netbox cluster type of clusters created by the inventory sync.")
+	fs.StringVar(&inventoryTag, "inventory-tag", controllers.DefaultInventoryTag,
+		"Slug of the Netbox tag of virtual machines created by the inventory sync.")
+	fs.DurationVar(&quarantineInterval, "quarantine-cleanup-interval", controllers.DefaultQuarantineCleanupInterval,
+		"Interval at which quarantined addresses whose quarantine period has expired are deleted from Netbox.")
 	capiflags.AddManagerOptions(fs, &managerOptions)
 }
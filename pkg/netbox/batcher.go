@@ -0,0 +1,240 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/internal/logger"
+	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// maxBatchSize is the maximum number of addresses created with one bulk request. A full batch is allocated without
+// waiting for the end of the batch window.
+const maxBatchSize = limit
+
+// AddressBatchRequest is a request for a free address of a pool, which is batched with the requests for the same
+// pool.
+type AddressBatchRequest struct {
+	// Key identifies the pool. Requests with the same key are allocated together.
+	Key string
+	// Client is the Netbox client used to allocate the batch of the request.
+	Client Client
+	// Pool is the prefix or ip-range in Netbox the address is allocated from.
+	Pool *NetboxIPPool
+	// Vrf is the vrf the address is created in.
+	Vrf string
+	// Attributes are the attributes the address is created with.
+	Attributes IPAddressAttributes
+	// Skip returns whether a free address must not be allocated, for example because it is excluded from the pool.
+	Skip func(ipAddress *ipaddr.IPAddress) bool
+	// MaxSkipped is the maximum number of free addresses of the pool Skip returns true for.
+	MaxSkipped int
+	// Lock locks the prefix or ip-range while the batch is allocated, and returns the function that unlocks it again.
+	Lock func() func()
+}
+
+type addressRequest struct {
+	fetchRequest[AddressBatchRequest, *ipaddr.IPAddress]
+	// abandoned is set when the caller stopped waiting before the address was handed to it, and handed when the
+	// response was sent to the caller. Both are guarded by the mutex of the batcher.
+	abandoned bool
+	handed    bool
+}
+
+type addressBatch struct {
+	requests []*addressRequest
+	full     chan struct{}
+}
+
+// AddressBatcher coalesces the requests for free addresses of the same pool that arrive within a short window, and
+// creates all of them with one bulk request to Netbox.
+type AddressBatcher struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	batches map[string]*addressBatch
+}
+
+// NewAddressBatcher returns a batcher that collects the requests for the same pool during window.
+func NewAddressBatcher(window time.Duration) *AddressBatcher {
+	return &AddressBatcher{
+		window:  window,
+		batches: make(map[string]*addressBatch),
+	}
+}
+
+// CreateIPAddress creates a free address of the pool of the request in Netbox, together with the other requests for
+// the same pool. An error wrapping ErrPoolExhausted is returned if there are not enough free addresses for all
+// requests of the batch.
+func (b *AddressBatcher) CreateIPAddress(ctx context.Context, req AddressBatchRequest) (*ipaddr.IPAddress, error) {
+	request := &addressRequest{
+		fetchRequest: fetchRequest[AddressBatchRequest, *ipaddr.IPAddress]{
+			ctx:   ctx,
+			req:   req,
+			resch: make(chan fetchResponse[*ipaddr.IPAddress], 1),
+		},
+	}
+
+	b.mu.Lock()
+	batch, ok := b.batches[req.Key]
+	if !ok {
+		batch = &addressBatch{full: make(chan struct{})}
+		b.batches[req.Key] = batch
+		go b.flush(req.Key, batch)
+	}
+	batch.requests = append(batch.requests, request)
+	if len(batch.requests) == maxBatchSize {
+		delete(b.batches, req.Key)
+		close(batch.full)
+	}
+	b.mu.Unlock()
+
+	select {
+	case res := <-request.resch:
+		return res.data, res.err
+	case <-ctx.Done():
+	}
+
+	// An address handed to the caller is the caller's to keep, otherwise the batch releases the address it creates for
+	// the request.
+	b.mu.Lock()
+	handed := request.handed
+	request.abandoned = !handed
+	b.mu.Unlock()
+	if handed {
+		res := <-request.resch
+		return res.data, res.err
+	}
+	return nil, ctx.Err()
+}
+
+// flush allocates the batch once the window has passed or the batch is full.
+func (b *AddressBatcher) flush(key string, batch *addressBatch) {
+	timer := time.NewTimer(b.window)
+	select {
+	case <-timer.C:
+	case <-batch.full:
+		timer.Stop()
+	}
+
+	b.mu.Lock()
+	if b.batches[key] == batch {
+		delete(b.batches, key)
+	}
+	// Requests whose caller stopped waiting are left out, so no address is created for them.
+	var pending []*addressRequest
+	for _, request := range batch.requests {
+		if request.abandoned || request.ctx.Err() != nil {
+			continue
+		}
+		pending = append(pending, request)
+	}
+	b.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	// The batch is allocated on behalf of all its requests, so it must not be aborted when one of them is.
+	ctx := context.WithoutCancel(pending[0].ctx)
+	addresses, created, err := allocateBatch(ctx, pending)
+
+	var abandoned []IPAddress
+	b.mu.Lock()
+	for i, request := range pending {
+		if request.abandoned {
+			if err == nil && i < len(created) {
+				abandoned = append(abandoned, created[i])
+			}
+			continue
+		}
+		request.handed = true
+		if err != nil {
+			request.resch <- fetchResponse[*ipaddr.IPAddress]{err: err}
+			continue
+		}
+		if i >= len(addresses) {
+			request.resch <- fetchResponse[*ipaddr.IPAddress]{
+				err: fmt.Errorf("no free address available in %s: %w", request.req.Pool.Display, ErrPoolExhausted),
+			}
+			continue
+		}
+		request.resch <- fetchResponse[*ipaddr.IPAddress]{data: addresses[i]}
+	}
+	b.mu.Unlock()
+
+	releaseAbandoned(ctx, pending[0].req.Client, abandoned)
+}
+
+// releaseAbandoned deletes the addresses created for requests whose caller stopped waiting while the batch was
+// allocated. Nobody records these addresses, so they would otherwise be taken in Netbox forever.
+func releaseAbandoned(ctx context.Context, client Client, abandoned []IPAddress) {
+	log := logger.FromContext(ctx)
+
+	for _, ipAddress := range abandoned {
+		if err := client.DeleteIPAddress(ctx, ipAddress.Id); err != nil {
+			log.Error(err, "could not release address of abandoned request", "address", ipAddress.Address)
+		}
+	}
+}
+
+// allocateBatch creates as many free addresses as there are requests, or fewer if the pool has not enough free
+// addresses left. The addresses are returned in the order of the requests, together with the ip-addresses created in
+// Netbox.
+func allocateBatch(ctx context.Context, requests []*addressRequest) ([]*ipaddr.IPAddress, []IPAddress, error) {
+	first := requests[0].req
+	if first.Lock != nil {
+		defer first.Lock()()
+	}
+
+	// The free addresses are not reserved in Netbox, so another client of Netbox can take some of them before they are
+	// created. Netbox then rejects the whole batch, and the free addresses are fetched again.
+	for attempt := 1; ; attempt++ {
+		addresses, created, err := createFreeAddresses(ctx, requests)
+		if errors.Is(err, ErrDuplicateIPAddress) && attempt < AllocationAttempts {
+			continue
+		}
+		return addresses, created, err
+	}
+}
+
+// createFreeAddresses creates a free address for every request, in the order of the requests, or fewer if the pool
+// has not enough free addresses left.
+func createFreeAddresses(ctx context.Context, requests []*addressRequest) ([]*ipaddr.IPAddress, []IPAddress, error) {
+	first := requests[0].req
+
+	// Netbox returns the lowest free addresses first. Asking for one more address per request than can be skipped
+	// guarantees a usable address for every request, as long as the pool is not exhausted.
+	size := min(len(requests)+first.MaxSkipped+1, MaxAvailableAddresses)
+	available, err := first.Client.GetAvailableIPAddresses(ctx, first.Pool, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	var usable []*ipaddr.IPAddress
+	for _, ipAddress := range available {
+		if first.Skip != nil && first.Skip(ipAddress) {
+			continue
+		}
+		usable = append(usable, ipAddress)
+		if len(usable) == len(requests) {
+			break
+		}
+	}
+	if len(usable) == 0 {
+		return nil, nil, nil
+	}
+
+	addresses := make([]string, 0, len(usable))
+	attributes := make([]IPAddressAttributes, 0, len(usable))
+	for i, ipAddress := range usable {
+		addresses = append(addresses, ipAddress.String())
+		attributes = append(attributes, requests[i].req.Attributes)
+	}
+	created, err := first.Client.CreateIPAddresses(ctx, addresses, first.Vrf, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return usable, created, nil
+}
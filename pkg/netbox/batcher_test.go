@@ -0,0 +1,266 @@
+package netbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+	"go.uber.org/mock/gomock"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+	nbmock "github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox/mock"
+)
+
+func newPool(cidr string) *netbox.NetboxIPPool {
+	return &netbox.NetboxIPPool{
+		Id:      1,
+		Type:    netbox.PrefixPoolType,
+		Display: cidr,
+		Range:   ipaddr.NewIPAddressString(cidr).GetAddress().ToPrefixBlock().ToSequentialRange(),
+	}
+}
+
+func addresses(values ...string) []*ipaddr.IPAddress {
+	var result []*ipaddr.IPAddress
+	for _, value := range values {
+		result = append(result, ipaddr.NewIPAddressString(value).GetAddress())
+	}
+	return result
+}
+
+// createConcurrently requests count addresses of the same pool at the same time, and returns the allocated addresses
+// and the errors.
+func createConcurrently(batcher *netbox.AddressBatcher, count int, req netbox.AddressBatchRequest) ([]string, []error) {
+	var mu sync.Mutex
+	var allocated []string
+	var errs []error
+
+	var wg sync.WaitGroup
+	for range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ipAddress, err := batcher.CreateIPAddress(context.Background(), req)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			allocated = append(allocated, ipAddress.String())
+		}()
+	}
+	wg.Wait()
+	return allocated, errs
+}
+
+func TestAddressBatcherAllocatesConcurrentRequestsTogether(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+
+	gateway := ipaddr.NewIPAddressString("10.0.0.1").GetAddress()
+	locked := 0
+	netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 5).
+		Return(addresses("10.0.0.1/24", "10.0.0.2/24", "10.0.0.3/24", "10.0.0.4/24", "10.0.0.5/24"), nil)
+	netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.2/24", "10.0.0.3/24", "10.0.0.4/24"}, "vrf",
+		[]netbox.IPAddressAttributes{{Status: "active"}, {Status: "active"}, {Status: "active"}}).
+		Return(make([]netbox.IPAddress, 3), nil)
+
+	allocated, errs := createConcurrently(netbox.NewAddressBatcher(100*time.Millisecond), 3, netbox.AddressBatchRequest{
+		Key:        "pool",
+		Client:     netboxMock,
+		Pool:       pool,
+		Vrf:        "vrf",
+		Attributes: netbox.IPAddressAttributes{Status: "active"},
+		Skip: func(ipAddress *ipaddr.IPAddress) bool {
+			return gateway.Equal(ipAddress.WithoutPrefixLen())
+		},
+		MaxSkipped: 1,
+		Lock: func() func() {
+			locked++
+			return func() {}
+		},
+	})
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(allocated).To(ConsistOf("10.0.0.2/24", "10.0.0.3/24", "10.0.0.4/24"))
+	g.Expect(locked).To(Equal(1))
+}
+
+func TestAddressBatcherReportsExhaustedPool(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+
+	netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 3).Return(addresses("10.0.0.254/24"), nil)
+	netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.254/24"}, "", gomock.Any()).
+		Return(make([]netbox.IPAddress, 1), nil)
+
+	allocated, errs := createConcurrently(netbox.NewAddressBatcher(100*time.Millisecond), 2, netbox.AddressBatchRequest{
+		Key:    "pool",
+		Client: netboxMock,
+		Pool:   pool,
+	})
+	g.Expect(allocated).To(ConsistOf("10.0.0.254/24"))
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errors.Is(errs[0], netbox.ErrPoolExhausted)).To(BeTrue())
+}
+
+func TestAddressBatcherReportsNetboxErrorToAllRequests(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+
+	netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 3).Return(addresses("10.0.0.1/24", "10.0.0.2/24"), nil)
+	netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), gomock.Any(), "", gomock.Any()).Return(nil, errors.New("netbox down"))
+
+	allocated, errs := createConcurrently(netbox.NewAddressBatcher(100*time.Millisecond), 2, netbox.AddressBatchRequest{
+		Key:    "pool",
+		Client: netboxMock,
+		Pool:   pool,
+	})
+	g.Expect(allocated).To(BeEmpty())
+	g.Expect(errs).To(HaveLen(2))
+	g.Expect(errs[0]).To(MatchError("netbox down"))
+}
+
+func TestAddressBatcherRetriesAddressesTakenInTheMeantime(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+
+	gomock.InOrder(
+		netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 3).Return(addresses("10.0.0.1/24", "10.0.0.2/24"), nil),
+		netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.1/24", "10.0.0.2/24"}, "", gomock.Any()).
+			Return(nil, fmt.Errorf("could not create 2 ip-addresses: %w", netbox.ErrDuplicateIPAddress)),
+		netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 3).Return(addresses("10.0.0.2/24", "10.0.0.3/24"), nil),
+		netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.2/24", "10.0.0.3/24"}, "", gomock.Any()).
+			Return(make([]netbox.IPAddress, 2), nil),
+	)
+
+	allocated, errs := createConcurrently(netbox.NewAddressBatcher(100*time.Millisecond), 2, netbox.AddressBatchRequest{
+		Key:    "pool",
+		Client: netboxMock,
+		Pool:   pool,
+	})
+	g.Expect(errs).To(BeEmpty())
+	g.Expect(allocated).To(ConsistOf("10.0.0.2/24", "10.0.0.3/24"))
+}
+
+func TestAddressBatcherGivesUpOnAddressesTakenRepeatedly(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+
+	netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 2).Return(addresses("10.0.0.1/24"), nil).
+		Times(netbox.AllocationAttempts)
+	netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.1/24"}, "", gomock.Any()).
+		Return(nil, fmt.Errorf("could not create 1 ip-addresses: %w", netbox.ErrDuplicateIPAddress)).
+		Times(netbox.AllocationAttempts)
+
+	allocated, errs := createConcurrently(netbox.NewAddressBatcher(100*time.Millisecond), 1, netbox.AddressBatchRequest{
+		Key:    "pool",
+		Client: netboxMock,
+		Pool:   pool,
+	})
+	g.Expect(allocated).To(BeEmpty())
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errors.Is(errs[0], netbox.ErrDuplicateIPAddress)).To(BeTrue())
+}
+
+func TestAddressBatcherReleasesAddressesOfAbandonedRequests(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+	req := netbox.AddressBatchRequest{Key: "pool", Client: netboxMock, Pool: pool}
+
+	abandonedCtx, cancel := context.WithCancel(context.Background())
+	left := make(chan error)
+	kept := make(chan string)
+	netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 3).
+		DoAndReturn(func(context.Context, *netbox.NetboxIPPool, int) ([]*ipaddr.IPAddress, error) {
+			// The caller leaves while the batch is allocated.
+			cancel()
+			g.Expect(<-left).To(MatchError(context.Canceled))
+			return addresses("10.0.0.1/24", "10.0.0.2/24"), nil
+		})
+	netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.1/24", "10.0.0.2/24"}, "", gomock.Any()).
+		Return([]netbox.IPAddress{{Id: 1, Address: "10.0.0.1/24"}, {Id: 2, Address: "10.0.0.2/24"}}, nil)
+	deleted := make(chan int, 1)
+	netboxMock.EXPECT().DeleteIPAddress(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, id int) error {
+		deleted <- id
+		return nil
+	})
+
+	batcher := netbox.NewAddressBatcher(100 * time.Millisecond)
+	go func() {
+		_, err := batcher.CreateIPAddress(abandonedCtx, req)
+		left <- err
+	}()
+	go func() {
+		ipAddress, err := batcher.CreateIPAddress(context.Background(), req)
+		g.Expect(err).ToNot(HaveOccurred())
+		kept <- ipAddress.String()
+	}()
+
+	keptAddress := <-kept
+	g.Expect(keptAddress).To(BeElementOf("10.0.0.1/24", "10.0.0.2/24"))
+	if keptAddress == "10.0.0.1/24" {
+		g.Expect(<-deleted).To(Equal(2))
+	} else {
+		g.Expect(<-deleted).To(Equal(1))
+	}
+}
+
+func TestAddressBatcherLeavesOutRequestsAbandonedBeforeTheBatch(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+	req := netbox.AddressBatchRequest{Key: "pool", Client: netboxMock, Pool: pool}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := netbox.NewAddressBatcher(100*time.Millisecond).CreateIPAddress(ctx, req)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+
+	// No address is requested or created for the request.
+	time.Sleep(150 * time.Millisecond)
+}
+
+func TestAddressBatcherAllocatesDifferentPoolsSeparately(t *testing.T) {
+	g := NewWithT(t)
+	mockCtrl := gomock.NewController(t)
+	netboxMock := nbmock.NewMockClient(mockCtrl)
+	pool := newPool("10.0.0.0/24")
+
+	netboxMock.EXPECT().GetAvailableIPAddresses(gomock.Any(), pool, 2).Return(addresses("10.0.0.1/24"), nil).Times(2)
+	netboxMock.EXPECT().CreateIPAddresses(gomock.Any(), []string{"10.0.0.1/24"}, gomock.Any(), gomock.Any()).
+		Return(make([]netbox.IPAddress, 1), nil).Times(2)
+
+	batcher := netbox.NewAddressBatcher(100 * time.Millisecond)
+	results := make(chan error, 2)
+	for _, key := range []string{"pool-a", "pool-b"} {
+		go func() {
+			_, err := batcher.CreateIPAddress(context.Background(), netbox.AddressBatchRequest{
+				Key:    key,
+				Client: netboxMock,
+				Pool:   pool,
+			})
+			results <- err
+		}()
+	}
+	g.Expect(<-results).NotTo(HaveOccurred())
+	g.Expect(<-results).NotTo(HaveOccurred())
+}
@@ -0,0 +1,63 @@
+package netbox
+
+import (
+	"sync"
+	"time"
+)
+
+type clientKey struct {
+	url        string
+	apiToken   string
+	apiVersion string
+}
+
+type cachedClient struct {
+	client  Client
+	created time.Time
+}
+
+// ClientCache shares the clients of a Netbox between reconciles, so that the version of Netbox is detected once per
+// client instead of with every reconcile. A client is created again when the url, the token or the API version of the
+// secret changed, or when it is older than the maximum age, so that an upgrade of Netbox is noticed.
+type ClientCache struct {
+	factory func(url, apiToken, apiVersion string) (Client, error)
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	clients map[clientKey]cachedClient
+}
+
+// NewClientCache returns a cache of the clients created by factory, which are kept for at most maxAge.
+func NewClientCache(factory func(url, apiToken, apiVersion string) (Client, error), maxAge time.Duration) *ClientCache {
+	return &ClientCache{
+		factory: factory,
+		maxAge:  maxAge,
+		clients: make(map[clientKey]cachedClient),
+	}
+}
+
+// ClientFor returns the cached client for the Netbox at the url, or a new one if there is none yet. It can be used as
+// the factory of Netbox clients of the controllers.
+func (c *ClientCache) ClientFor(url, apiToken, apiVersion string) (Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// Clients of rotated tokens or moved Netboxes are never used again, so expired clients are dropped here.
+	for key, cached := range c.clients {
+		if now.Sub(cached.created) >= c.maxAge {
+			delete(c.clients, key)
+		}
+	}
+
+	key := clientKey{url: url, apiToken: apiToken, apiVersion: apiVersion}
+	if cached, ok := c.clients[key]; ok {
+		return cached.client, nil
+	}
+	client, err := c.factory(url, apiToken, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[key] = cachedClient{client: client, created: now}
+	return client, nil
+}
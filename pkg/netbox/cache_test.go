@@ -0,0 +1,82 @@
+package netbox_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+func TestClientCache(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxAge          time.Duration
+		second          [3]string
+		expectedCreated int
+	}{
+		{
+			name:            "reuses the client of the same secret",
+			maxAge:          time.Hour,
+			second:          [3]string{"http://netbox.local", "token", "4"},
+			expectedCreated: 1,
+		},
+		{
+			name:            "creates a client for a rotated token",
+			maxAge:          time.Hour,
+			second:          [3]string{"http://netbox.local", "rotated", "4"},
+			expectedCreated: 2,
+		},
+		{
+			name:            "creates a client for another api version",
+			maxAge:          time.Hour,
+			second:          [3]string{"http://netbox.local", "token", "3"},
+			expectedCreated: 2,
+		},
+		{
+			name:            "creates a client again after the maximum age",
+			maxAge:          0,
+			second:          [3]string{"http://netbox.local", "token", "4"},
+			expectedCreated: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			created := 0
+			cache := netbox.NewClientCache(func(url, apiToken, apiVersion string) (netbox.Client, error) {
+				created++
+				return netbox.NewNetBoxClientFor(url, apiToken, apiVersion)
+			}, tt.maxAge)
+
+			first, err := cache.ClientFor("http://netbox.local", "token", "4")
+			g.Expect(err).ToNot(HaveOccurred())
+			second, err := cache.ClientFor(tt.second[0], tt.second[1], tt.second[2])
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(created).To(Equal(tt.expectedCreated))
+			g.Expect(second == first).To(Equal(tt.expectedCreated == 1))
+		})
+	}
+}
+
+func TestClientCacheDoesNotCacheErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	created := 0
+	cache := netbox.NewClientCache(func(url, apiToken, apiVersion string) (netbox.Client, error) {
+		created++
+		if created == 1 {
+			return nil, errors.New("invalid")
+		}
+		return netbox.NewNetBoxClientFor(url, apiToken, apiVersion)
+	}, time.Hour)
+
+	_, err := cache.ClientFor("http://netbox.local", "token", "4")
+	g.Expect(err).To(MatchError("invalid"))
+	_, err = cache.ClientFor("http://netbox.local", "token", "4")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(created).To(Equal(2))
+}
@@ -3,39 +3,155 @@ package netbox
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
-	"github.com/netbox-community/go-netbox/v3"
 	"github.com/pkg/errors"
 	"github.com/seancfoley/ipaddress-go/ipaddr"
 )
 
+// ErrPoolExhausted is returned when a pool has no free addresses or prefixes left.
+var ErrPoolExhausted = errors.New("pool exhausted")
+
+// ErrDuplicateIPAddress is returned when an ip-address is created that already exists in its vrf. Netbox only rejects
+// duplicates if it enforces unique addresses in the vrf, or globally for addresses without a vrf, which it does by
+// default.
+var ErrDuplicateIPAddress = errors.New("duplicate ip-address")
+
+// AllocationAttempts is the number of times a free address is picked and created, when the picked addresses turn out
+// to be taken in Netbox in the meantime.
+const AllocationAttempts = 3
+
+// MaxAvailableAddresses is the maximum number of available addresses requested from Netbox at once, which matches the
+// default maximum page size of Netbox.
+const MaxAvailableAddresses = 1000
+
 //go:generate mockgen -destination=mock/client.go -package=nbmock . Client
 type Client interface {
 	GetPrefix(ctx context.Context, address string, vrf string) (*NetboxIPPool, error)
 	GetIPRange(ctx context.Context, address string, vrf string) (*NetboxIPPool, error)
-	NextAvailablePrefixAddress(ctx context.Context) (*ipaddr.IPAddress, error)
-	NextAvailableIPRangeAddress(ctx context.Context) (*ipaddr.IPAddress, error)
+	// GetAvailableIPAddresses returns up to limit addresses of the pool that are not yet used in Netbox.
+	GetAvailableIPAddresses(ctx context.Context, pool *NetboxIPPool, limit int) ([]*ipaddr.IPAddress, error)
+	// GetIPAddresses returns all ip-addresses in Netbox that are part of the pool.
+	GetIPAddresses(ctx context.Context, pool *NetboxIPPool) ([]IPAddress, error)
+
+	// GetIPAddress returns the ip-address in the given vrf, or nil if the address does not exist in Netbox.
+	GetIPAddress(ctx context.Context, address string, vrf string) (*IPAddress, error)
+	// FindIPAddresses returns all ip-addresses in the given vrf having the given description.
+	FindIPAddresses(ctx context.Context, description string, vrf string) ([]IPAddress, error)
+	// CreateIPAddress creates the ip-address in the given vrf. An error wrapping ErrDuplicateIPAddress is returned if
+	// the address already exists.
+	CreateIPAddress(ctx context.Context, address string, vrf string, attributes IPAddressAttributes) (*IPAddress, error)
+	// CreateIPAddresses creates all ip-addresses with one atomic request, in the given vrf and with the given
+	// attributes.
+	CreateIPAddresses(ctx context.Context, addresses []string, vrf string, attributes []IPAddressAttributes) ([]IPAddress, error)
+	// UpdateIPAddressDnsName sets the DNS name of the ip-address, an empty DNS name clears it.
+	UpdateIPAddressDnsName(ctx context.Context, id int, dnsName string) (*IPAddress, error)
+	// UpdateIPAddressAssignment assigns the ip-address to the virtual machine interface, an id of 0 unassigns it.
+	UpdateIPAddressAssignment(ctx context.Context, id int, vmInterfaceId int) (*IPAddress, error)
+	// QuarantineIPAddress marks a released ip-address with the given status and the time it was quarantined in the
+	// QuarantinedCustomField, and clears its DNS name. The other fields of the ip-address are left alone.
+	QuarantineIPAddress(ctx context.Context, id int, status string, since time.Time) (*IPAddress, error)
+	// FindQuarantinedIPAddresses returns all ip-addresses in the given vrf that are quarantined.
+	FindQuarantinedIPAddresses(ctx context.Context, vrf string) ([]IPAddress, error)
+	// GetIPAddressHost returns the device or virtual machine the ip-address is assigned to, or nil if it is not
+	// assigned.
+	GetIPAddressHost(ctx context.Context, ipAddress *IPAddress) (*Host, error)
+	// SetPrimaryIPAddress sets the primary ip-address of the given family of the host, an id of 0 clears it.
+	SetPrimaryIPAddress(ctx context.Context, host *Host, version ipaddr.IPVersion, ipAddressId int) error
+	DeleteIPAddress(ctx context.Context, id int) error
+
+	// GetChildPrefixes returns all prefixes in Netbox of the given length that are part of the pool.
+	GetChildPrefixes(ctx context.Context, pool *NetboxIPPool, prefixLength int) ([]Prefix, error)
+	// FindPrefix returns the prefix in the given vrf, or nil if the prefix does not exist in Netbox.
+	FindPrefix(ctx context.Context, prefix string, vrf string) (*Prefix, error)
+	// CreateAvailablePrefix creates the first free child prefix of the given length in the pool.
+	CreateAvailablePrefix(ctx context.Context, pool *NetboxIPPool, prefixLength int) (*Prefix, error)
+	DeletePrefix(ctx context.Context, id int) error
+
+	// FindVirtualMachine returns the virtual machine with the given name, or nil if it does not exist in Netbox.
+	FindVirtualMachine(ctx context.Context, name string) (*VirtualMachine, error)
+	// FindClusterVirtualMachine returns the virtual machine with the given name in the cluster, or nil if it does not
+	// exist in the cluster.
+	FindClusterVirtualMachine(ctx context.Context, clusterId int, name string) (*VirtualMachine, error)
+	// FindVirtualMachineByCustomField returns the virtual machine whose custom field has the given value, or nil if
+	// it does not exist in Netbox.
+	FindVirtualMachineByCustomField(ctx context.Context, customField string, value string) (*VirtualMachine, error)
+	CreateVirtualMachine(ctx context.Context, request *VirtualMachineRequest) (*VirtualMachine, error)
+	// FindVMInterface returns the interface of the virtual machine with the given name, or nil if it does not exist
+	// in Netbox.
+	FindVMInterface(ctx context.Context, virtualMachineId int, name string) (*VMInterface, error)
+	CreateVMInterface(ctx context.Context, virtualMachineId int, name string) (*VMInterface, error)
+	// GetVirtualMachines returns all virtual machines of the cluster.
+	GetVirtualMachines(ctx context.Context, clusterId int) ([]VirtualMachine, error)
+	UpdateVirtualMachine(ctx context.Context, id int, patch *VirtualMachinePatch) (*VirtualMachine, error)
+
+	// FindClusterType returns the cluster type with the given slug, or nil if it does not exist in Netbox.
+	FindClusterType(ctx context.Context, slug string) (*ClusterType, error)
+	CreateClusterType(ctx context.Context, name string, slug string) (*ClusterType, error)
+	// FindCluster returns the cluster with the given name, or nil if it does not exist in Netbox.
+	FindCluster(ctx context.Context, name string) (*Cluster, error)
+	CreateCluster(ctx context.Context, request *ClusterRequest) (*Cluster, error)
+	UpdateClusterStatus(ctx context.Context, id int, status string) (*Cluster, error)
+
+	// FindTag returns the tag with the given slug, or nil if it does not exist in Netbox.
+	FindTag(ctx context.Context, slug string) (*Tag, error)
+	CreateTag(ctx context.Context, name string, slug string) (*Tag, error)
+
+	// Version returns the version of Netbox, which is queried once per client. The returned error wraps
+	// ErrUnsupportedVersion if the client does not support the version.
+	Version(ctx context.Context) (Version, error)
+
+	// CheckPermissions verifies that Netbox can be reached with the token, and returns the permissions the provider
+	// needs that the token is missing.
+	CheckPermissions(ctx context.Context) ([]string, error)
 }
 
 type client struct {
-	api         *netbox.APIClient
 	restyClient *resty.Client
 	poolFetcher *poolFetcher
+	apiToken    string
+	// apiMajor is the major version of Netbox given for the client, or 0 if it is detected.
+	apiMajor int
+
+	versionLock sync.Mutex
+	version     *Version
 }
 
 var _ Client = &client{}
 
+// NewNetBoxClient returns a client for the Netbox at the url, for the version of Netbox detected from its status.
 func NewNetBoxClient(url, apiToken string) Client {
-	api := netbox.NewAPIClientFor(url, apiToken)
+	return newClient(url, apiToken, 0)
+}
+
+// NewNetBoxClientFor returns a client for the Netbox at the url, using the given major version of the Netbox API. An
+// empty apiVersion detects the version from the status of Netbox.
+func NewNetBoxClientFor(url, apiToken, apiVersion string) (Client, error) {
+	major, err := APIVersion(apiVersion).major()
+	if err != nil {
+		return nil, err
+	}
+	if major == 3 && isV2Token(apiToken) {
+		return nil, fmt.Errorf("v2 tokens require Netbox API version %s", APIVersion4)
+	}
+	return newClient(url, apiToken, major), nil
+}
+
+func newClient(url, apiToken string, apiMajor int) *client {
 	restyClient := resty.New().
-		SetBaseURL("http://localhost:8000/api/ipam").
-		SetAuthScheme("Token").
-		SetAuthToken("b1f2db68f235158beea51b0554fc067814221c3a")
+		SetBaseURL(strings.TrimSuffix(url, "/") + "/api").
+		SetAuthScheme(authScheme(apiToken)).
+		SetAuthToken(apiToken)
 	return &client{
-		api:         api,
 		restyClient: restyClient,
 		poolFetcher: newPoolFetcher(),
+		apiToken:    apiToken,
+		apiMajor:    apiMajor,
 	}
 }
 
@@ -50,7 +166,7 @@ func (c *client) GetPrefix(ctx context.Context, prefix string, requestedVrf stri
 
 	request.SetQueryParam("prefix", prefix)
 
-	response, err := request.Get("/prefixes")
+	response, err := request.Get("/ipam/prefixes/")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get prefix")
 	}
@@ -83,6 +199,7 @@ func (c *client) GetPrefix(ctx context.Context, prefix string, requestedVrf stri
 		Display: result.Display,
 		Vrf:     result.Vrf.Name,
 		Range:   cidr.ToSequentialRange(),
+		Gateway: customFieldString(result.CustomFields, GatewayCustomField),
 	}, nil
 }
 
@@ -97,7 +214,7 @@ func (c *client) GetIPRange(ctx context.Context, startAddress string, requestedV
 
 	request.SetQueryParam("start_address", startAddress)
 
-	response, err := request.Get("/ip-ranges")
+	response, err := request.Get("/ipam/ip-ranges/")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get ip-range")
 	}
@@ -136,31 +253,738 @@ func (c *client) GetIPRange(ctx context.Context, startAddress string, requestedV
 		Display: result.Display,
 		Vrf:     result.Vrf.Name,
 		Range:   lower.SpanWithRange(upper),
+		Gateway: customFieldString(result.CustomFields, GatewayCustomField),
 	}, nil
 }
 
-func (c *client) NextAvailablePrefixAddress(ctx context.Context) (*ipaddr.IPAddress, error) {
-	prefix := &PrefixRequest{}
-	request :=
-		c.restyClient.
+func (c *client) GetAvailableIPAddresses(ctx context.Context, pool *NetboxIPPool, limit int) ([]*ipaddr.IPAddress, error) {
+	var path string
+	switch pool.Type {
+	case PrefixPoolType:
+		path = fmt.Sprintf("/ipam/prefixes/%d/available-ips/", pool.Id)
+	case IPRangePoolType:
+		path = fmt.Sprintf("/ipam/ip-ranges/%d/available-ips/", pool.Id)
+	default:
+		return nil, fmt.Errorf("unexpected pool type: %s", pool.Type)
+	}
+
+	var available []AvailableIPAddress
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetQueryParam("limit", strconv.Itoa(limit)).
+		SetResult(&available).
+		SetContext(ctx).
+		Get(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get available ip-addresses")
+	}
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve available ip-addresses successfully. (%d)", response.StatusCode())
+	}
+
+	ipAddresses := make([]*ipaddr.IPAddress, 0, len(available))
+	for _, a := range available {
+		ipAddress, err := ipaddr.NewIPAddressString(a.Address).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid IpAddress %s", a.Address))
+		}
+		ipAddresses = append(ipAddresses, ipAddress)
+	}
+	return ipAddresses, nil
+}
+
+func (c *client) GetIPAddresses(ctx context.Context, pool *NetboxIPPool) ([]IPAddress, error) {
+	parent := pool.Range.CoverWithPrefixBlock().String()
+	ipAddresses, err := c.listIPAddresses(ctx, map[string]string{"parent": parent}, pool.Vrf)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []IPAddress
+	for _, a := range ipAddresses {
+		address, err := ipaddr.NewIPAddressString(a.Address).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid IpAddress %s", a.Address))
+		}
+		if pool.Contains(address) {
+			results = append(results, a)
+		}
+	}
+	return results, nil
+}
+
+func (c *client) GetIPAddress(ctx context.Context, address string, requestedVrf string) (*IPAddress, error) {
+	ipAddresses, err := c.listIPAddresses(ctx, map[string]string{"address": address}, requestedVrf)
+	if err != nil {
+		return nil, err
+	}
+	if len(ipAddresses) == 0 {
+		return nil, nil
+	}
+	if len(ipAddresses) != 1 {
+		return nil, fmt.Errorf("multiple ip-addresses matches '%s', there must be only one match", address)
+	}
+	return &ipAddresses[0], nil
+}
+
+func (c *client) FindIPAddresses(ctx context.Context, description string, requestedVrf string) ([]IPAddress, error) {
+	return c.listIPAddresses(ctx, map[string]string{"description": description}, requestedVrf)
+}
+
+func (c *client) FindQuarantinedIPAddresses(ctx context.Context, requestedVrf string) ([]IPAddress, error) {
+	ipAddresses, err := c.listIPAddresses(ctx, map[string]string{"cf_" + QuarantinedCustomField + "__empty": "false"}, requestedVrf)
+	if err != nil {
+		return nil, err
+	}
+	// Netbox ignores the filter of a custom field that does not exist.
+	return slices.DeleteFunc(ipAddresses, func(a IPAddress) bool {
+		_, ok := a.QuarantinedSince()
+		return !ok
+	}), nil
+}
+
+func (c *client) CreateIPAddress(ctx context.Context, address string, vrf string, attributes IPAddressAttributes) (*IPAddress, error) {
+	ipAddress := &IPAddress{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(newIPAddressRequest(address, vrf, attributes)).
+		SetResult(ipAddress).
+		SetContext(ctx).
+		Post("/ipam/ip-addresses/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ip-address")
+	}
+	if isDuplicate(response) {
+		return nil, fmt.Errorf("could not create ip-address '%s': %w", address, ErrDuplicateIPAddress)
+	}
+	if response.StatusCode() != 201 {
+		return nil, fmt.Errorf("could not create ip-address '%s' successfully. (%d)", address, response.StatusCode())
+	}
+	return ipAddress, nil
+}
+
+func (c *client) CreateIPAddresses(ctx context.Context, addresses []string, vrf string, attributes []IPAddressAttributes) ([]IPAddress, error) {
+	if len(addresses) != len(attributes) {
+		return nil, errors.New("every ip-address must have attributes")
+	}
+	body := make([]*IPAddressRequest, 0, len(addresses))
+	for i, address := range addresses {
+		body = append(body, newIPAddressRequest(address, vrf, attributes[i]))
+	}
+	var ipAddresses []IPAddress
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(body).
+		SetResult(&ipAddresses).
+		SetContext(ctx).
+		Post("/ipam/ip-addresses/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ip-addresses")
+	}
+	if isDuplicate(response) {
+		return nil, fmt.Errorf("could not create %d ip-addresses: %w", len(addresses), ErrDuplicateIPAddress)
+	}
+	if response.StatusCode() != 201 {
+		return nil, fmt.Errorf("could not create %d ip-addresses successfully. (%d)", len(addresses), response.StatusCode())
+	}
+	return ipAddresses, nil
+}
+
+// isDuplicate returns whether Netbox rejected the creation of an ip-address because the address already exists.
+func isDuplicate(response *resty.Response) bool {
+	return response.StatusCode() == 400 && strings.Contains(response.String(), "Duplicate IP address")
+}
+
+func newIPAddressRequest(address string, vrf string, attributes IPAddressAttributes) *IPAddressRequest {
+	request := &IPAddressRequest{
+		Address:     address,
+		DnsName:     attributes.DnsName,
+		Status:      attributes.Status,
+		Description: attributes.Description,
+	}
+	if vrf != "" {
+		request.Vrf = &Vrf{Name: vrf}
+	}
+	if attributes.VMInterfaceId != 0 {
+		request.AssignedObjectType = VMInterfaceObjectType
+		request.AssignedObjectId = attributes.VMInterfaceId
+	}
+	return request
+}
+
+func (c *client) UpdateIPAddressDnsName(ctx context.Context, id int, dnsName string) (*IPAddress, error) {
+	ipAddress := &IPAddress{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(&IPAddressDnsNameRequest{DnsName: dnsName}).
+		SetResult(ipAddress).
+		SetContext(ctx).
+		Patch(fmt.Sprintf("/ipam/ip-addresses/%d/", id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update ip-address")
+	}
+	if response.StatusCode() != 200 {
+		return nil, fmt.Errorf("could not update dns name of ip-address %d successfully. (%d)", id, response.StatusCode())
+	}
+	return ipAddress, nil
+}
+
+func (c *client) UpdateIPAddressAssignment(ctx context.Context, id int, vmInterfaceId int) (*IPAddress, error) {
+	body := &IPAddressAssignmentRequest{}
+	if vmInterfaceId != 0 {
+		objectType := VMInterfaceObjectType
+		body.AssignedObjectType = &objectType
+		body.AssignedObjectId = &vmInterfaceId
+	}
+	ipAddress := &IPAddress{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(body).
+		SetResult(ipAddress).
+		SetContext(ctx).
+		Patch(fmt.Sprintf("/ipam/ip-addresses/%d/", id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update ip-address")
+	}
+	if response.StatusCode() != 200 {
+		return nil, fmt.Errorf("could not update assignment of ip-address %d successfully. (%d)", id, response.StatusCode())
+	}
+	return ipAddress, nil
+}
+
+func (c *client) QuarantineIPAddress(ctx context.Context, id int, status string, since time.Time) (*IPAddress, error) {
+	ipAddress := &IPAddress{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(&IPAddressQuarantineRequest{
+			Status:       status,
+			CustomFields: map[string]any{QuarantinedCustomField: since.UTC().Format(time.RFC3339)},
+		}).
+		SetResult(ipAddress).
+		SetContext(ctx).
+		Patch(fmt.Sprintf("/ipam/ip-addresses/%d/", id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update ip-address")
+	}
+	if response.StatusCode() != 200 {
+		return nil, fmt.Errorf("could not quarantine ip-address %d successfully. (%d)", id, response.StatusCode())
+	}
+	return ipAddress, nil
+}
+
+func (c *client) GetIPAddressHost(ctx context.Context, ipAddress *IPAddress) (*Host, error) {
+	if ipAddress.AssignedObject == nil {
+		return nil, nil
+	}
+	host := &Host{}
+	var nested *NestedObject
+	switch {
+	case ipAddress.AssignedObject.Device != nil:
+		host.Type = DeviceHostType
+		nested = ipAddress.AssignedObject.Device
+	case ipAddress.AssignedObject.VirtualMachine != nil:
+		host.Type = VirtualMachineHostType
+		nested = ipAddress.AssignedObject.VirtualMachine
+	default:
+		return nil, nil
+	}
+
+	result := &HostResult{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetResult(result).
+		SetContext(ctx).
+		Get(hostPath(host.Type, nested.Id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get host")
+	}
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve host %s successfully. (%d)", nested.Display, response.StatusCode())
+	}
+
+	host.Id = result.Id
+	host.Display = result.Display
+	if result.PrimaryIp4 != nil {
+		host.PrimaryIp4 = result.PrimaryIp4.Id
+	}
+	if result.PrimaryIp6 != nil {
+		host.PrimaryIp6 = result.PrimaryIp6.Id
+	}
+	return host, nil
+}
+
+func (c *client) SetPrimaryIPAddress(ctx context.Context, host *Host, version ipaddr.IPVersion, ipAddressId int) error {
+	field := "primary_ip4"
+	if version.IsIPv6() {
+		field = "primary_ip6"
+	}
+	// A null value clears the primary ip-address.
+	body := map[string]any{field: nil}
+	if ipAddressId != 0 {
+		body[field] = ipAddressId
+	}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(body).
+		SetContext(ctx).
+		Patch(hostPath(host.Type, host.Id))
+	if err != nil {
+		return errors.Wrap(err, "failed to update host")
+	}
+	if response.StatusCode() != 200 {
+		return fmt.Errorf("could not set %s of %s successfully. (%d)", field, host.Display, response.StatusCode())
+	}
+	return nil
+}
+
+func (c *client) DeleteIPAddress(ctx context.Context, id int) error {
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetContext(ctx).
+		Delete(fmt.Sprintf("/ipam/ip-addresses/%d/", id))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete ip-address")
+	}
+	if response.StatusCode() != 204 && response.StatusCode() != 404 {
+		return fmt.Errorf("could not delete ip-address %d successfully. (%d)", id, response.StatusCode())
+	}
+	return nil
+}
+
+func (c *client) GetChildPrefixes(ctx context.Context, pool *NetboxIPPool, prefixLength int) ([]Prefix, error) {
+	if pool.Type != PrefixPoolType {
+		return nil, fmt.Errorf("unexpected pool type: %s", pool.Type)
+	}
+	return c.listPrefixes(ctx, map[string]string{
+		"within":      pool.Range.CoverWithPrefixBlock().String(),
+		"mask_length": strconv.Itoa(prefixLength),
+	}, pool.Vrf)
+}
+
+func (c *client) FindPrefix(ctx context.Context, prefix string, requestedVrf string) (*Prefix, error) {
+	prefixes, err := c.listPrefixes(ctx, map[string]string{"prefix": prefix}, requestedVrf)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+	if len(prefixes) != 1 {
+		return nil, fmt.Errorf("multiple prefixes matches '%s', there must be only one match", prefix)
+	}
+	return &prefixes[0], nil
+}
+
+func (c *client) CreateAvailablePrefix(ctx context.Context, pool *NetboxIPPool, prefixLength int) (*Prefix, error) {
+	if pool.Type != PrefixPoolType {
+		return nil, fmt.Errorf("unexpected pool type: %s", pool.Type)
+	}
+	prefix := &Prefix{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(&AvailablePrefixRequest{PrefixLength: prefixLength}).
+		SetResult(prefix).
+		SetContext(ctx).
+		Post(fmt.Sprintf("/ipam/prefixes/%d/available-prefixes/", pool.Id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create prefix")
+	}
+	// Depending on the version, Netbox responds with either status when there is no space left in the pool.
+	if response.StatusCode() == 204 || response.StatusCode() == 409 {
+		return nil, fmt.Errorf("could not create /%d prefix in %s: %w", prefixLength, pool.Display, ErrPoolExhausted)
+	}
+	if response.StatusCode() != 201 {
+		return nil, fmt.Errorf("could not create /%d prefix in %s successfully. (%d)", prefixLength, pool.Display, response.StatusCode())
+	}
+	return prefix, nil
+}
+
+func (c *client) DeletePrefix(ctx context.Context, id int) error {
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetContext(ctx).
+		Delete(fmt.Sprintf("/ipam/prefixes/%d/", id))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete prefix")
+	}
+	if response.StatusCode() != 204 && response.StatusCode() != 404 {
+		return fmt.Errorf("could not delete prefix %d successfully. (%d)", id, response.StatusCode())
+	}
+	return nil
+}
+
+func (c *client) FindVirtualMachine(ctx context.Context, name string) (*VirtualMachine, error) {
+	return c.findVirtualMachine(ctx, map[string]string{"name": name}, name)
+}
+
+func (c *client) FindClusterVirtualMachine(ctx context.Context, clusterId int, name string) (*VirtualMachine, error) {
+	return c.findVirtualMachine(ctx, map[string]string{"cluster_id": strconv.Itoa(clusterId), "name": name}, name)
+}
+
+func (c *client) FindVirtualMachineByCustomField(ctx context.Context, customField string, value string) (*VirtualMachine, error) {
+	return c.findVirtualMachine(ctx, map[string]string{"cf_" + customField: value}, value)
+}
+
+func (c *client) CreateVirtualMachine(ctx context.Context, request *VirtualMachineRequest) (*VirtualMachine, error) {
+	virtualMachine := &VirtualMachine{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(request).
+		SetResult(virtualMachine).
+		SetContext(ctx).
+		Post("/virtualization/virtual-machines/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create virtual machine")
+	}
+	if response.StatusCode() != 201 {
+		return nil, fmt.Errorf("could not create virtual machine '%s' successfully. (%d)", request.Name, response.StatusCode())
+	}
+	return virtualMachine, nil
+}
+
+func (c *client) FindVMInterface(ctx context.Context, virtualMachineId int, name string) (*VMInterface, error) {
+	interfaceList := &VMInterfaceList{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetQueryParams(map[string]string{
+			"virtual_machine_id": strconv.Itoa(virtualMachineId),
+			"name":               name,
+		}).
+		SetResult(interfaceList).
+		SetContext(ctx).
+		Get("/virtualization/interfaces/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get virtual machine interfaces")
+	}
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve virtual machine interfaces successfully. (%d)", response.StatusCode())
+	}
+	if len(interfaceList.Results) == 0 {
+		return nil, nil
+	}
+	if len(interfaceList.Results) != 1 {
+		return nil, fmt.Errorf("multiple interfaces of virtual machine %d matches '%s', there must be only one match", virtualMachineId, name)
+	}
+	return &interfaceList.Results[0], nil
+}
+
+func (c *client) CreateVMInterface(ctx context.Context, virtualMachineId int, name string) (*VMInterface, error) {
+	vmInterface := &VMInterface{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(&VMInterfaceRequest{VirtualMachine: virtualMachineId, Name: name}).
+		SetResult(vmInterface).
+		SetContext(ctx).
+		Post("/virtualization/interfaces/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create virtual machine interface")
+	}
+	if response.StatusCode() != 201 {
+		return nil, fmt.Errorf("could not create interface '%s' of virtual machine %d successfully. (%d)", name, virtualMachineId, response.StatusCode())
+	}
+	return vmInterface, nil
+}
+
+func (c *client) GetVirtualMachines(ctx context.Context, clusterId int) ([]VirtualMachine, error) {
+	var results []VirtualMachine
+	offset := 0
+	for {
+		virtualMachineList := &VirtualMachineList{}
+		response, err := c.restyClient.
 			R().
 			SetHeader("Accept", "application/json").
-			SetResult(prefix).
-			SetContext(ctx)
-	response, err := request.Post("/prefixes/5/available-ips/")
+			SetQueryParams(map[string]string{
+				"cluster_id": strconv.Itoa(clusterId),
+				"limit":      strconv.Itoa(limit),
+				"offset":     strconv.Itoa(offset),
+			}).
+			SetResult(virtualMachineList).
+			SetContext(ctx).
+			Get("/virtualization/virtual-machines/")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get virtual machines")
+		}
+		if isFailure(response) {
+			return nil, fmt.Errorf("could not retrieve virtual machines successfully. (%d)", response.StatusCode())
+		}
+		results = append(results, virtualMachineList.Results...)
+		offset += limit
+		if len(virtualMachineList.Results) == 0 || offset >= virtualMachineList.Count {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (c *client) UpdateVirtualMachine(ctx context.Context, id int, patch *VirtualMachinePatch) (*VirtualMachine, error) {
+	virtualMachine := &VirtualMachine{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(patch).
+		SetResult(virtualMachine).
+		SetContext(ctx).
+		Patch(fmt.Sprintf("/virtualization/virtual-machines/%d/", id))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get prefix")
+		return nil, errors.Wrap(err, "failed to update virtual machine")
+	}
+	if response.StatusCode() != 200 {
+		return nil, fmt.Errorf("could not update virtual machine %d successfully. (%d)", id, response.StatusCode())
+	}
+	return virtualMachine, nil
+}
+
+func (c *client) FindClusterType(ctx context.Context, slug string) (*ClusterType, error) {
+	clusterTypeList := &ClusterTypeList{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetQueryParam("slug", slug).
+		SetResult(clusterTypeList).
+		SetContext(ctx).
+		Get("/virtualization/cluster-types/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cluster types")
+	}
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve cluster types successfully. (%d)", response.StatusCode())
+	}
+	if len(clusterTypeList.Results) == 0 {
+		return nil, nil
+	}
+	return &clusterTypeList.Results[0], nil
+}
+
+func (c *client) CreateClusterType(ctx context.Context, name string, slug string) (*ClusterType, error) {
+	clusterType := &ClusterType{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(&ClusterType{Name: name, Slug: slug}).
+		SetResult(clusterType).
+		SetContext(ctx).
+		Post("/virtualization/cluster-types/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cluster type")
 	}
 	if response.StatusCode() != 201 {
-		return nil, errors.Wrap(err, fmt.Sprintf("could not create next available address. (%d)", response.StatusCode()))
+		return nil, fmt.Errorf("could not create cluster type '%s' successfully. (%d)", slug, response.StatusCode())
 	}
-	ipAddress, err := ipaddr.NewIPAddressString(prefix.Address).ToAddress()
+	return clusterType, nil
+}
+
+func (c *client) FindCluster(ctx context.Context, name string) (*Cluster, error) {
+	clusterList := &ClusterList{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetQueryParam("name", name).
+		SetResult(clusterList).
+		SetContext(ctx).
+		Get("/virtualization/clusters/")
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("invalid IpAddress %s", prefix.Address))
+		return nil, errors.Wrap(err, "failed to get clusters")
 	}
-	return ipAddress, nil
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve clusters successfully. (%d)", response.StatusCode())
+	}
+	if len(clusterList.Results) == 0 {
+		return nil, nil
+	}
+	if len(clusterList.Results) != 1 {
+		return nil, fmt.Errorf("multiple clusters matches '%s', there must be only one match", name)
+	}
+	return &clusterList.Results[0], nil
+}
+
+func (c *client) CreateCluster(ctx context.Context, request *ClusterRequest) (*Cluster, error) {
+	cluster := &Cluster{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(request).
+		SetResult(cluster).
+		SetContext(ctx).
+		Post("/virtualization/clusters/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cluster")
+	}
+	if response.StatusCode() != 201 {
+		return nil, fmt.Errorf("could not create cluster '%s' successfully. (%d)", request.Name, response.StatusCode())
+	}
+	return cluster, nil
 }
 
-func (c *client) NextAvailableIPRangeAddress(ctx context.Context) (*ipaddr.IPAddress, error) {
-	return nil, nil
+func (c *client) UpdateClusterStatus(ctx context.Context, id int, status string) (*Cluster, error) {
+	cluster := &Cluster{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(&ClusterStatusRequest{Status: status}).
+		SetResult(cluster).
+		SetContext(ctx).
+		Patch(fmt.Sprintf("/virtualization/clusters/%d/", id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update cluster")
+	}
+	if response.StatusCode() != 200 {
+		return nil, fmt.Errorf("could not update cluster %d successfully. (%d)", id, response.StatusCode())
+	}
+	return cluster, nil
+}
+
+func (c *client) FindTag(ctx context.Context, slug string) (*Tag, error) {
+	tagList := &TagList{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetQueryParam("slug", slug).
+		SetResult(tagList).
+		SetContext(ctx).
+		Get("/extras/tags/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tags")
+	}
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve tags successfully. (%d)", response.StatusCode())
+	}
+	if len(tagList.Results) == 0 {
+		return nil, nil
+	}
+	return &tagList.Results[0], nil
+}
+
+func (c *client) CreateTag(ctx context.Context, name string, slug string) (*Tag, error) {
+	tag := &Tag{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetBody(&Tag{Name: name, Slug: slug}).
+		SetResult(tag).
+		SetContext(ctx).
+		Post("/extras/tags/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create tag")
+	}
+	if response.StatusCode() != 201 {
+		return nil, fmt.Errorf("could not create tag '%s' successfully. (%d)", slug, response.StatusCode())
+	}
+	return tag, nil
+}
+
+func (c *client) findVirtualMachine(ctx context.Context, query map[string]string, value string) (*VirtualMachine, error) {
+	virtualMachineList := &VirtualMachineList{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetQueryParams(query).
+		SetResult(virtualMachineList).
+		SetContext(ctx).
+		Get("/virtualization/virtual-machines/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get virtual machines")
+	}
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve virtual machines successfully. (%d)", response.StatusCode())
+	}
+	if len(virtualMachineList.Results) == 0 {
+		return nil, nil
+	}
+	if len(virtualMachineList.Results) != 1 {
+		return nil, fmt.Errorf("multiple virtual machines matches '%s', there must be only one match", value)
+	}
+	return &virtualMachineList.Results[0], nil
+}
+
+func hostPath(hostType HostType, id int) string {
+	if hostType == DeviceHostType {
+		return fmt.Sprintf("/dcim/devices/%d/", id)
+	}
+	return fmt.Sprintf("/virtualization/virtual-machines/%d/", id)
+}
+
+func (c *client) listIPAddresses(ctx context.Context, query map[string]string, requestedVrf string) ([]IPAddress, error) {
+	var results []IPAddress
+	offset := 0
+	for {
+		addressList := &IPAddressList{}
+		response, err := c.restyClient.
+			R().
+			SetHeader("Accept", "application/json").
+			SetQueryParams(query).
+			SetQueryParams(map[string]string{
+				"limit":  strconv.Itoa(limit),
+				"offset": strconv.Itoa(offset),
+			}).
+			SetResult(addressList).
+			SetContext(ctx).
+			Get("/ipam/ip-addresses/")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get ip-addresses")
+		}
+		if isFailure(response) {
+			return nil, fmt.Errorf("could not retrieve ip-addresses successfully. (%d)", response.StatusCode())
+		}
+		for _, a := range addressList.Results {
+			// An empty vrf denotes the Global vrf, so addresses in other vrfs do not match.
+			if a.Vrf.Name == requestedVrf {
+				results = append(results, a)
+			}
+		}
+		offset += limit
+		if len(addressList.Results) == 0 || offset >= addressList.Count {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (c *client) listPrefixes(ctx context.Context, query map[string]string, requestedVrf string) ([]Prefix, error) {
+	var results []Prefix
+	offset := 0
+	for {
+		prefixList := &PrefixList{}
+		response, err := c.restyClient.
+			R().
+			SetHeader("Accept", "application/json").
+			SetQueryParams(query).
+			SetQueryParams(map[string]string{
+				"limit":  strconv.Itoa(limit),
+				"offset": strconv.Itoa(offset),
+			}).
+			SetResult(prefixList).
+			SetContext(ctx).
+			Get("/ipam/prefixes/")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get prefixes")
+		}
+		if isFailure(response) {
+			return nil, fmt.Errorf("could not retrieve prefixes successfully. (%d)", response.StatusCode())
+		}
+		for _, p := range prefixList.Results {
+			// An empty vrf denotes the Global vrf, so prefixes in other vrfs do not match.
+			if p.Vrf.Name == requestedVrf {
+				results = append(results, p)
+			}
+		}
+		offset += limit
+		if len(prefixList.Results) == 0 || offset >= prefixList.Count {
+			break
+		}
+	}
+	return results, nil
 }
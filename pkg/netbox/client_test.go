@@ -0,0 +1,107 @@
+package netbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+func TestCreateIPAddressReportsDuplicates(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		duplicate bool
+	}{
+		{
+			name:      "duplicate in global table",
+			status:    http.StatusBadRequest,
+			body:      `{"address": ["Duplicate IP address found in global table: 10.0.0.2/24"]}`,
+			duplicate: true,
+		},
+		{
+			name:      "duplicate in vrf",
+			status:    http.StatusBadRequest,
+			body:      `{"address": ["Duplicate IP address found in VRF vrf: 10.0.0.2/24"]}`,
+			duplicate: true,
+		},
+		{
+			name:   "other validation error",
+			status: http.StatusBadRequest,
+			body:   `{"vrf": ["Related object not found using the provided attributes: {'name': 'vrf'}"]}`,
+		},
+		{
+			name:   "server error",
+			status: http.StatusInternalServerError,
+			body:   `{"error": "Duplicate IP address"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := netbox.NewNetBoxClient(server.URL, "token")
+			_, err := client.CreateIPAddress(context.Background(), "10.0.0.2/24", "vrf", netbox.IPAddressAttributes{})
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(errors.Is(err, netbox.ErrDuplicateIPAddress)).To(Equal(tt.duplicate))
+
+			_, err = client.CreateIPAddresses(context.Background(), []string{"10.0.0.2/24"}, "vrf", []netbox.IPAddressAttributes{{}})
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(errors.Is(err, netbox.ErrDuplicateIPAddress)).To(Equal(tt.duplicate))
+		})
+	}
+}
+
+func TestQuarantineIPAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodPatch:
+			g.Expect(json.NewDecoder(r.Body).Decode(&request)).To(Succeed())
+			_, _ = w.Write([]byte(`{"id": 2, "address": "10.0.0.2/24", "description": "kept",
+				"custom_fields": {"quarantined_since": "2026-01-02T03:04:05Z"}}`))
+		default:
+			g.Expect(r.URL.Query().Get("cf_quarantined_since__empty")).To(Equal("false"))
+			_, _ = w.Write([]byte(`{"count": 2, "results": [
+				{"id": 2, "address": "10.0.0.2/24", "custom_fields": {"quarantined_since": "2026-01-02T03:04:05Z"}},
+				{"id": 3, "address": "10.0.0.3/24", "custom_fields": {}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	client := netbox.NewNetBoxClient(server.URL, "token")
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ipAddress, err := client.QuarantineIPAddress(context.Background(), 2, "deprecated", since)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(request).To(Equal(map[string]any{
+		"status":        "deprecated",
+		"dns_name":      "",
+		"custom_fields": map[string]any{netbox.QuarantinedCustomField: "2026-01-02T03:04:05Z"},
+	}))
+	g.Expect(ipAddress.Description).To(Equal("kept"))
+	quarantinedSince, ok := ipAddress.QuarantinedSince()
+	g.Expect(ok).To(BeTrue())
+	g.Expect(quarantinedSince).To(Equal(since))
+
+	quarantined, err := client.FindQuarantinedIPAddresses(context.Background(), "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(quarantined).To(HaveLen(1))
+	g.Expect(quarantined[0].Id).To(Equal(2))
+}
@@ -13,17 +13,65 @@ var (
 	IPRangePoolType = PoolType("IPRange")
 )
 
+const (
+	// GatewayRole is the role of the ip-address that is the gateway of a prefix or ip-range.
+	GatewayRole = "gateway"
+
+	// GatewayCustomField is the custom field of a prefix or ip-range containing its gateway.
+	GatewayCustomField = "gateway"
+
+	// VMInterfaceObjectType is the type of the object an ip-address is assigned to, when it is assigned to an
+	// interface of a virtual machine.
+	VMInterfaceObjectType = "virtualization.vminterface"
+
+	// ActiveStatus and DecommissioningStatus are the statuses of clusters and virtual machines that are in use, or
+	// that are being removed.
+	ActiveStatus          = "active"
+	DecommissioningStatus = "decommissioning"
+
+	// QuarantinedCustomField is the custom field of released ip-addresses that are kept in Netbox for a quarantine
+	// period. It holds the time the ip-address was quarantined, in RFC 3339 format. Pools with a quarantine require
+	// a text custom field of this name for ip-addresses in Netbox.
+	QuarantinedCustomField = "quarantined_since"
+)
+
+type HostType string
+
+var (
+	DeviceHostType         = HostType("Device")
+	VirtualMachineHostType = HostType("VirtualMachine")
+)
+
+// Host is a device or virtual machine in Netbox, with the ids of its primary ip-addresses, or 0 if it has none.
+type Host struct {
+	Type       HostType
+	Id         int
+	Display    string
+	PrimaryIp4 int
+	PrimaryIp6 int
+}
+
+// PrimaryIp returns the id of the primary ip-address of the given family, or 0 if the host has none.
+func (h *Host) PrimaryIp(version ipaddr.IPVersion) int {
+	if version.IsIPv6() {
+		return h.PrimaryIp6
+	}
+	return h.PrimaryIp4
+}
+
 type NetboxIPPool struct {
 	Id      int
 	Type    PoolType
 	Display string
 	Vrf     string
 	Range   *ipaddr.SequentialRange[*ipaddr.IPAddress]
+	// Gateway is the value of the GatewayCustomField of the prefix or ip-range, if any.
+	Gateway string
 	inuse   int
 }
 
 func (p *NetboxIPPool) Contains(address *ipaddr.IPAddress) bool {
-	return p.Range.Contains(address)
+	return p.Range.Contains(address.WithoutPrefixLen())
 }
 
 func (p *NetboxIPPool) Total() int {
@@ -42,3 +90,10 @@ func (p *NetboxIPPool) String() string {
 	return fmt.Sprintf("%s %s (%d): total %d, inuse: %d, available: %d ",
 		p.Type, p.Display, p.Id, p.Total(), p.InUse(), p.Available())
 }
+
+func customFieldString(customFields map[string]any, name string) string {
+	if value, ok := customFields[name].(string); ok {
+		return value
+	}
+	return ""
+}
@@ -11,11 +11,11 @@ package nbmock
 
 import (
 	context "context"
-	reflect "reflect"
-
 	netbox "github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
 	ipaddr "github.com/seancfoley/ipaddress-go/ipaddr"
 	gomock "go.uber.org/mock/gomock"
+	reflect "reflect"
+	time "time"
 )
 
 // MockClient is a mock of Client interface.
@@ -41,18 +41,392 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
-// GatherStatistics mocks base method.
-func (m *MockClient) GatherStatistics(arg0 context.Context, arg1 []*netbox.NetboxIPPool) error {
+// CheckPermissions mocks base method.
+func (m *MockClient) CheckPermissions(arg0 context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckPermissions", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckPermissions indicates an expected call of CheckPermissions.
+func (mr *MockClientMockRecorder) CheckPermissions(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckPermissions", reflect.TypeOf((*MockClient)(nil).CheckPermissions), arg0)
+}
+
+// CreateAvailablePrefix mocks base method.
+func (m *MockClient) CreateAvailablePrefix(arg0 context.Context, arg1 *netbox.NetboxIPPool, arg2 int) (*netbox.Prefix, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAvailablePrefix", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.Prefix)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateAvailablePrefix indicates an expected call of CreateAvailablePrefix.
+func (mr *MockClientMockRecorder) CreateAvailablePrefix(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAvailablePrefix", reflect.TypeOf((*MockClient)(nil).CreateAvailablePrefix), arg0, arg1, arg2)
+}
+
+// CreateCluster mocks base method.
+func (m *MockClient) CreateCluster(arg0 context.Context, arg1 *netbox.ClusterRequest) (*netbox.Cluster, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateCluster", arg0, arg1)
+	ret0, _ := ret[0].(*netbox.Cluster)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateCluster indicates an expected call of CreateCluster.
+func (mr *MockClientMockRecorder) CreateCluster(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCluster", reflect.TypeOf((*MockClient)(nil).CreateCluster), arg0, arg1)
+}
+
+// CreateClusterType mocks base method.
+func (m *MockClient) CreateClusterType(arg0 context.Context, arg1, arg2 string) (*netbox.ClusterType, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateClusterType", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.ClusterType)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateClusterType indicates an expected call of CreateClusterType.
+func (mr *MockClientMockRecorder) CreateClusterType(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateClusterType", reflect.TypeOf((*MockClient)(nil).CreateClusterType), arg0, arg1, arg2)
+}
+
+// CreateIPAddress mocks base method.
+func (m *MockClient) CreateIPAddress(arg0 context.Context, arg1, arg2 string, arg3 netbox.IPAddressAttributes) (*netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIPAddress", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateIPAddress indicates an expected call of CreateIPAddress.
+func (mr *MockClientMockRecorder) CreateIPAddress(arg0, arg1, arg2, arg3 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIPAddress", reflect.TypeOf((*MockClient)(nil).CreateIPAddress), arg0, arg1, arg2, arg3)
+}
+
+// CreateIPAddresses mocks base method.
+func (m *MockClient) CreateIPAddresses(arg0 context.Context, arg1 []string, arg2 string, arg3 []netbox.IPAddressAttributes) ([]netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIPAddresses", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateIPAddresses indicates an expected call of CreateIPAddresses.
+func (mr *MockClientMockRecorder) CreateIPAddresses(arg0, arg1, arg2, arg3 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIPAddresses", reflect.TypeOf((*MockClient)(nil).CreateIPAddresses), arg0, arg1, arg2, arg3)
+}
+
+// CreateTag mocks base method.
+func (m *MockClient) CreateTag(arg0 context.Context, arg1, arg2 string) (*netbox.Tag, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTag", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.Tag)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateTag indicates an expected call of CreateTag.
+func (mr *MockClientMockRecorder) CreateTag(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTag", reflect.TypeOf((*MockClient)(nil).CreateTag), arg0, arg1, arg2)
+}
+
+// CreateVMInterface mocks base method.
+func (m *MockClient) CreateVMInterface(arg0 context.Context, arg1 int, arg2 string) (*netbox.VMInterface, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateVMInterface", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.VMInterface)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateVMInterface indicates an expected call of CreateVMInterface.
+func (mr *MockClientMockRecorder) CreateVMInterface(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateVMInterface", reflect.TypeOf((*MockClient)(nil).CreateVMInterface), arg0, arg1, arg2)
+}
+
+// CreateVirtualMachine mocks base method.
+func (m *MockClient) CreateVirtualMachine(arg0 context.Context, arg1 *netbox.VirtualMachineRequest) (*netbox.VirtualMachine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateVirtualMachine", arg0, arg1)
+	ret0, _ := ret[0].(*netbox.VirtualMachine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateVirtualMachine indicates an expected call of CreateVirtualMachine.
+func (mr *MockClientMockRecorder) CreateVirtualMachine(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateVirtualMachine", reflect.TypeOf((*MockClient)(nil).CreateVirtualMachine), arg0, arg1)
+}
+
+// DeleteIPAddress mocks base method.
+func (m *MockClient) DeleteIPAddress(arg0 context.Context, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIPAddress", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIPAddress indicates an expected call of DeleteIPAddress.
+func (mr *MockClientMockRecorder) DeleteIPAddress(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIPAddress", reflect.TypeOf((*MockClient)(nil).DeleteIPAddress), arg0, arg1)
+}
+
+// DeletePrefix mocks base method.
+func (m *MockClient) DeletePrefix(arg0 context.Context, arg1 int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GatherStatistics", arg0, arg1)
+	ret := m.ctrl.Call(m, "DeletePrefix", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// GatherStatistics indicates an expected call of GatherStatistics.
-func (mr *MockClientMockRecorder) GatherStatistics(arg0, arg1 any) *gomock.Call {
+// DeletePrefix indicates an expected call of DeletePrefix.
+func (mr *MockClientMockRecorder) DeletePrefix(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePrefix", reflect.TypeOf((*MockClient)(nil).DeletePrefix), arg0, arg1)
+}
+
+// FindCluster mocks base method.
+func (m *MockClient) FindCluster(arg0 context.Context, arg1 string) (*netbox.Cluster, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindCluster", arg0, arg1)
+	ret0, _ := ret[0].(*netbox.Cluster)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindCluster indicates an expected call of FindCluster.
+func (mr *MockClientMockRecorder) FindCluster(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GatherStatistics", reflect.TypeOf((*MockClient)(nil).GatherStatistics), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindCluster", reflect.TypeOf((*MockClient)(nil).FindCluster), arg0, arg1)
+}
+
+// FindClusterType mocks base method.
+func (m *MockClient) FindClusterType(arg0 context.Context, arg1 string) (*netbox.ClusterType, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindClusterType", arg0, arg1)
+	ret0, _ := ret[0].(*netbox.ClusterType)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindClusterType indicates an expected call of FindClusterType.
+func (mr *MockClientMockRecorder) FindClusterType(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindClusterType", reflect.TypeOf((*MockClient)(nil).FindClusterType), arg0, arg1)
+}
+
+// FindClusterVirtualMachine mocks base method.
+func (m *MockClient) FindClusterVirtualMachine(arg0 context.Context, arg1 int, arg2 string) (*netbox.VirtualMachine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindClusterVirtualMachine", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.VirtualMachine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindClusterVirtualMachine indicates an expected call of FindClusterVirtualMachine.
+func (mr *MockClientMockRecorder) FindClusterVirtualMachine(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindClusterVirtualMachine", reflect.TypeOf((*MockClient)(nil).FindClusterVirtualMachine), arg0, arg1, arg2)
+}
+
+// FindIPAddresses mocks base method.
+func (m *MockClient) FindIPAddresses(arg0 context.Context, arg1, arg2 string) ([]netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindIPAddresses", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindIPAddresses indicates an expected call of FindIPAddresses.
+func (mr *MockClientMockRecorder) FindIPAddresses(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindIPAddresses", reflect.TypeOf((*MockClient)(nil).FindIPAddresses), arg0, arg1, arg2)
+}
+
+// FindPrefix mocks base method.
+func (m *MockClient) FindPrefix(arg0 context.Context, arg1, arg2 string) (*netbox.Prefix, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindPrefix", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.Prefix)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindPrefix indicates an expected call of FindPrefix.
+func (mr *MockClientMockRecorder) FindPrefix(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindPrefix", reflect.TypeOf((*MockClient)(nil).FindPrefix), arg0, arg1, arg2)
+}
+
+// FindQuarantinedIPAddresses mocks base method.
+func (m *MockClient) FindQuarantinedIPAddresses(arg0 context.Context, arg1 string) ([]netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindQuarantinedIPAddresses", arg0, arg1)
+	ret0, _ := ret[0].([]netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindQuarantinedIPAddresses indicates an expected call of FindQuarantinedIPAddresses.
+func (mr *MockClientMockRecorder) FindQuarantinedIPAddresses(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindQuarantinedIPAddresses", reflect.TypeOf((*MockClient)(nil).FindQuarantinedIPAddresses), arg0, arg1)
+}
+
+// FindTag mocks base method.
+func (m *MockClient) FindTag(arg0 context.Context, arg1 string) (*netbox.Tag, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindTag", arg0, arg1)
+	ret0, _ := ret[0].(*netbox.Tag)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindTag indicates an expected call of FindTag.
+func (mr *MockClientMockRecorder) FindTag(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindTag", reflect.TypeOf((*MockClient)(nil).FindTag), arg0, arg1)
+}
+
+// FindVMInterface mocks base method.
+func (m *MockClient) FindVMInterface(arg0 context.Context, arg1 int, arg2 string) (*netbox.VMInterface, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindVMInterface", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.VMInterface)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindVMInterface indicates an expected call of FindVMInterface.
+func (mr *MockClientMockRecorder) FindVMInterface(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindVMInterface", reflect.TypeOf((*MockClient)(nil).FindVMInterface), arg0, arg1, arg2)
+}
+
+// FindVirtualMachine mocks base method.
+func (m *MockClient) FindVirtualMachine(arg0 context.Context, arg1 string) (*netbox.VirtualMachine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindVirtualMachine", arg0, arg1)
+	ret0, _ := ret[0].(*netbox.VirtualMachine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindVirtualMachine indicates an expected call of FindVirtualMachine.
+func (mr *MockClientMockRecorder) FindVirtualMachine(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindVirtualMachine", reflect.TypeOf((*MockClient)(nil).FindVirtualMachine), arg0, arg1)
+}
+
+// FindVirtualMachineByCustomField mocks base method.
+func (m *MockClient) FindVirtualMachineByCustomField(arg0 context.Context, arg1, arg2 string) (*netbox.VirtualMachine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindVirtualMachineByCustomField", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.VirtualMachine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindVirtualMachineByCustomField indicates an expected call of FindVirtualMachineByCustomField.
+func (mr *MockClientMockRecorder) FindVirtualMachineByCustomField(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindVirtualMachineByCustomField", reflect.TypeOf((*MockClient)(nil).FindVirtualMachineByCustomField), arg0, arg1, arg2)
+}
+
+// GetAvailableIPAddresses mocks base method.
+func (m *MockClient) GetAvailableIPAddresses(arg0 context.Context, arg1 *netbox.NetboxIPPool, arg2 int) ([]*ipaddr.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAvailableIPAddresses", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*ipaddr.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAvailableIPAddresses indicates an expected call of GetAvailableIPAddresses.
+func (mr *MockClientMockRecorder) GetAvailableIPAddresses(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAvailableIPAddresses", reflect.TypeOf((*MockClient)(nil).GetAvailableIPAddresses), arg0, arg1, arg2)
+}
+
+// GetChildPrefixes mocks base method.
+func (m *MockClient) GetChildPrefixes(arg0 context.Context, arg1 *netbox.NetboxIPPool, arg2 int) ([]netbox.Prefix, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChildPrefixes", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]netbox.Prefix)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetChildPrefixes indicates an expected call of GetChildPrefixes.
+func (mr *MockClientMockRecorder) GetChildPrefixes(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChildPrefixes", reflect.TypeOf((*MockClient)(nil).GetChildPrefixes), arg0, arg1, arg2)
+}
+
+// GetIPAddress mocks base method.
+func (m *MockClient) GetIPAddress(arg0 context.Context, arg1, arg2 string) (*netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIPAddress", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIPAddress indicates an expected call of GetIPAddress.
+func (mr *MockClientMockRecorder) GetIPAddress(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIPAddress", reflect.TypeOf((*MockClient)(nil).GetIPAddress), arg0, arg1, arg2)
+}
+
+// GetIPAddressHost mocks base method.
+func (m *MockClient) GetIPAddressHost(arg0 context.Context, arg1 *netbox.IPAddress) (*netbox.Host, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIPAddressHost", arg0, arg1)
+	ret0, _ := ret[0].(*netbox.Host)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIPAddressHost indicates an expected call of GetIPAddressHost.
+func (mr *MockClientMockRecorder) GetIPAddressHost(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIPAddressHost", reflect.TypeOf((*MockClient)(nil).GetIPAddressHost), arg0, arg1)
+}
+
+// GetIPAddresses mocks base method.
+func (m *MockClient) GetIPAddresses(arg0 context.Context, arg1 *netbox.NetboxIPPool) ([]netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIPAddresses", arg0, arg1)
+	ret0, _ := ret[0].([]netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIPAddresses indicates an expected call of GetIPAddresses.
+func (mr *MockClientMockRecorder) GetIPAddresses(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIPAddresses", reflect.TypeOf((*MockClient)(nil).GetIPAddresses), arg0, arg1)
 }
 
 // GetIPRange mocks base method.
@@ -85,32 +459,121 @@ func (mr *MockClientMockRecorder) GetPrefix(arg0, arg1, arg2 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrefix", reflect.TypeOf((*MockClient)(nil).GetPrefix), arg0, arg1, arg2)
 }
 
-// NextAvailableIPRangeAddress mocks base method.
-func (m *MockClient) NextAvailableIPRangeAddress(arg0 context.Context) (*ipaddr.IPAddress, error) {
+// GetVirtualMachines mocks base method.
+func (m *MockClient) GetVirtualMachines(arg0 context.Context, arg1 int) ([]netbox.VirtualMachine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVirtualMachines", arg0, arg1)
+	ret0, _ := ret[0].([]netbox.VirtualMachine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVirtualMachines indicates an expected call of GetVirtualMachines.
+func (mr *MockClientMockRecorder) GetVirtualMachines(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVirtualMachines", reflect.TypeOf((*MockClient)(nil).GetVirtualMachines), arg0, arg1)
+}
+
+// QuarantineIPAddress mocks base method.
+func (m *MockClient) QuarantineIPAddress(arg0 context.Context, arg1 int, arg2 string, arg3 time.Time) (*netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QuarantineIPAddress", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QuarantineIPAddress indicates an expected call of QuarantineIPAddress.
+func (mr *MockClientMockRecorder) QuarantineIPAddress(arg0, arg1, arg2, arg3 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QuarantineIPAddress", reflect.TypeOf((*MockClient)(nil).QuarantineIPAddress), arg0, arg1, arg2, arg3)
+}
+
+// SetPrimaryIPAddress mocks base method.
+func (m *MockClient) SetPrimaryIPAddress(arg0 context.Context, arg1 *netbox.Host, arg2 ipaddr.IPVersion, arg3 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPrimaryIPAddress", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPrimaryIPAddress indicates an expected call of SetPrimaryIPAddress.
+func (mr *MockClientMockRecorder) SetPrimaryIPAddress(arg0, arg1, arg2, arg3 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPrimaryIPAddress", reflect.TypeOf((*MockClient)(nil).SetPrimaryIPAddress), arg0, arg1, arg2, arg3)
+}
+
+// UpdateClusterStatus mocks base method.
+func (m *MockClient) UpdateClusterStatus(arg0 context.Context, arg1 int, arg2 string) (*netbox.Cluster, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateClusterStatus", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.Cluster)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateClusterStatus indicates an expected call of UpdateClusterStatus.
+func (mr *MockClientMockRecorder) UpdateClusterStatus(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateClusterStatus", reflect.TypeOf((*MockClient)(nil).UpdateClusterStatus), arg0, arg1, arg2)
+}
+
+// UpdateIPAddressAssignment mocks base method.
+func (m *MockClient) UpdateIPAddressAssignment(arg0 context.Context, arg1, arg2 int) (*netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateIPAddressAssignment", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateIPAddressAssignment indicates an expected call of UpdateIPAddressAssignment.
+func (mr *MockClientMockRecorder) UpdateIPAddressAssignment(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateIPAddressAssignment", reflect.TypeOf((*MockClient)(nil).UpdateIPAddressAssignment), arg0, arg1, arg2)
+}
+
+// UpdateIPAddressDnsName mocks base method.
+func (m *MockClient) UpdateIPAddressDnsName(arg0 context.Context, arg1 int, arg2 string) (*netbox.IPAddress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateIPAddressDnsName", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.IPAddress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateIPAddressDnsName indicates an expected call of UpdateIPAddressDnsName.
+func (mr *MockClientMockRecorder) UpdateIPAddressDnsName(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateIPAddressDnsName", reflect.TypeOf((*MockClient)(nil).UpdateIPAddressDnsName), arg0, arg1, arg2)
+}
+
+// UpdateVirtualMachine mocks base method.
+func (m *MockClient) UpdateVirtualMachine(arg0 context.Context, arg1 int, arg2 *netbox.VirtualMachinePatch) (*netbox.VirtualMachine, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NextAvailableIPRangeAddress", arg0)
-	ret0, _ := ret[0].(*ipaddr.IPAddress)
+	ret := m.ctrl.Call(m, "UpdateVirtualMachine", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*netbox.VirtualMachine)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// NextAvailableIPRangeAddress indicates an expected call of NextAvailableIPRangeAddress.
-func (mr *MockClientMockRecorder) NextAvailableIPRangeAddress(arg0 any) *gomock.Call {
+// UpdateVirtualMachine indicates an expected call of UpdateVirtualMachine.
+func (mr *MockClientMockRecorder) UpdateVirtualMachine(arg0, arg1, arg2 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextAvailableIPRangeAddress", reflect.TypeOf((*MockClient)(nil).NextAvailableIPRangeAddress), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateVirtualMachine", reflect.TypeOf((*MockClient)(nil).UpdateVirtualMachine), arg0, arg1, arg2)
 }
 
-// NextAvailablePrefixAddress mocks base method.
-func (m *MockClient) NextAvailablePrefixAddress(arg0 context.Context) (*ipaddr.IPAddress, error) {
+// Version mocks base method.
+func (m *MockClient) Version(arg0 context.Context) (netbox.Version, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NextAvailablePrefixAddress", arg0)
-	ret0, _ := ret[0].(*ipaddr.IPAddress)
+	ret := m.ctrl.Call(m, "Version", arg0)
+	ret0, _ := ret[0].(netbox.Version)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// NextAvailablePrefixAddress indicates an expected call of NextAvailablePrefixAddress.
-func (mr *MockClientMockRecorder) NextAvailablePrefixAddress(arg0 any) *gomock.Call {
+// Version indicates an expected call of Version.
+func (mr *MockClientMockRecorder) Version(arg0 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextAvailablePrefixAddress", reflect.TypeOf((*MockClient)(nil).NextAvailablePrefixAddress), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Version", reflect.TypeOf((*MockClient)(nil).Version), arg0)
 }
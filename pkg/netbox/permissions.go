@@ -0,0 +1,95 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// viewPermissions are the Netbox permissions to view objects that the provider needs, with the endpoint of the objects.
+var viewPermissions = []struct {
+	permission string
+	path       string
+}{
+	{"ipam.view_prefix", "/ipam/prefixes/"},
+	{"ipam.view_iprange", "/ipam/ip-ranges/"},
+	{"ipam.view_ipaddress", "/ipam/ip-addresses/"},
+	{"ipam.view_vrf", "/ipam/vrfs/"},
+	{"tenancy.view_tenant", "/tenancy/tenants/"},
+}
+
+func (c *client) CheckPermissions(ctx context.Context) ([]string, error) {
+	// Netbox is reachable with the token when its status can be retrieved. The status is shared with Version, so that
+	// it is retrieved once per client. A version that can not be parsed is reported by Version, not here.
+	c.versionLock.Lock()
+	version, err := c.detectVersion(ctx)
+	c.versionLock.Unlock()
+	if err != nil && !errors.Is(err, ErrUnsupportedVersion) {
+		return nil, err
+	}
+
+	var missing []string
+	for _, view := range viewPermissions {
+		response, err := c.restyClient.
+			R().
+			SetHeader("Accept", "application/json").
+			SetQueryParam("limit", "1").
+			SetContext(ctx).
+			Get(view.path)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to get %s", view.path))
+		}
+		if response.StatusCode() == http.StatusForbidden {
+			missing = append(missing, view.permission)
+		} else if isFailure(response) {
+			return nil, fmt.Errorf("could not retrieve %s successfully. (%d)", view.path, response.StatusCode())
+		}
+	}
+
+	// Netbox only lists the methods the token is permitted to use in the actions of an OPTIONS response.
+	metadata := &EndpointMetadata{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetResult(metadata).
+		SetContext(ctx).
+		Options("/ipam/ip-addresses/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get ip-address metadata")
+	}
+	if isFailure(response) {
+		return nil, fmt.Errorf("could not retrieve ip-address metadata successfully. (%d)", response.StatusCode())
+	}
+	if _, ok := metadata.Actions[http.MethodPost]; !ok {
+		missing = append(missing, "ipam.add_ipaddress")
+	}
+
+	// Deleting can not be checked without deleting an object, but a token that is not write enabled can not delete.
+	// Tokens of other users are not visible, so the check is skipped when the token is not found.
+	key := tokenKey(c.apiToken)
+	tokenList := &TokenList{}
+	response, err = c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetQueryParam("key", key).
+		SetResult(tokenList).
+		SetContext(ctx).
+		Get("/users/tokens/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tokens")
+	}
+	if !isFailure(response) {
+		var token *Token
+		if c.apiVersion(version).Major >= 4 {
+			token = findV4Token(tokenList.Results, c.apiToken)
+		} else {
+			token = findV3Token(tokenList.Results, key)
+		}
+		if token != nil && !token.WriteEnabled {
+			missing = append(missing, "write enabled token")
+		}
+	}
+	return missing, nil
+}
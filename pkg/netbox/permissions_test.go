@@ -0,0 +1,141 @@
+package netbox_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+// permissionsServer is a fake Netbox that forbids the given endpoints and reports whether the token is write enabled.
+func permissionsServer(forbidden map[string]bool, canAdd bool, writeEnabled bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case forbidden[r.URL.Path]:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"detail": "You do not have permission to perform this action."}`))
+		case r.URL.Path == "/api/status/":
+			_, _ = w.Write([]byte(`{"netbox-version": "3.7.8"}`))
+		case r.URL.Path == "/api/users/tokens/":
+			if r.URL.Query().Get("key") != "token" {
+				_, _ = w.Write([]byte(`{"count": 0, "results": []}`))
+				return
+			}
+			if writeEnabled {
+				_, _ = w.Write([]byte(`{"count": 1, "results": [{"id": 1, "key": "token", "write_enabled": true}]}`))
+			} else {
+				_, _ = w.Write([]byte(`{"count": 1, "results": [{"id": 1, "key": "token", "write_enabled": false}]}`))
+			}
+		case r.Method == http.MethodOptions:
+			if canAdd {
+				_, _ = w.Write([]byte(`{"name": "IP Address List", "actions": {"POST": {}}}`))
+			} else {
+				_, _ = w.Write([]byte(`{"name": "IP Address List"}`))
+			}
+		default:
+			_, _ = w.Write([]byte(`{"count": 0, "results": []}`))
+		}
+	}))
+}
+
+func TestCheckPermissions(t *testing.T) {
+	tests := []struct {
+		name            string
+		forbidden       map[string]bool
+		canAdd          bool
+		writeEnabled    bool
+		expectedMissing []string
+		expectedErr     string
+	}{
+		{
+			name:         "all permissions",
+			canAdd:       true,
+			writeEnabled: true,
+		},
+		{
+			name:            "read-only token",
+			writeEnabled:    false,
+			expectedMissing: []string{"ipam.add_ipaddress", "write enabled token"},
+		},
+		{
+			name:            "missing view permissions",
+			forbidden:       map[string]bool{"/api/ipam/vrfs/": true, "/api/tenancy/tenants/": true},
+			canAdd:          true,
+			writeEnabled:    true,
+			expectedMissing: []string{"ipam.view_vrf", "tenancy.view_tenant"},
+		},
+		{
+			name:        "unreachable",
+			forbidden:   map[string]bool{"/api/status/": true},
+			expectedErr: "could not retrieve the status of Netbox successfully. (403)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server := permissionsServer(tt.forbidden, tt.canAdd, tt.writeEnabled)
+			defer server.Close()
+
+			missing, err := netbox.NewNetBoxClient(server.URL, "token").CheckPermissions(context.Background())
+			if tt.expectedErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.expectedErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(missing).To(Equal(tt.expectedMissing))
+		})
+	}
+}
+
+func TestCheckPermissionsSharesTheStatusWithVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	statusRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/status/":
+			statusRequests++
+			_, _ = w.Write([]byte(`{"netbox-version": "3.7.8"}`))
+		case r.Method == http.MethodOptions:
+			_, _ = w.Write([]byte(`{"name": "IP Address List", "actions": {"POST": {}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"count": 0, "results": []}`))
+		}
+	}))
+	defer server.Close()
+
+	client := netbox.NewNetBoxClient(server.URL, "token")
+	_, err := client.CheckPermissions(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = client.Version(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(statusRequests).To(Equal(1), "the status is queried once per client")
+}
+
+func TestCheckPermissionsOfAnUnparseableVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/status/":
+			_, _ = w.Write([]byte(`{"netbox-version": "unknown"}`))
+		case r.Method == http.MethodOptions:
+			_, _ = w.Write([]byte(`{"name": "IP Address List", "actions": {"POST": {}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"count": 0, "results": []}`))
+		}
+	}))
+	defer server.Close()
+
+	missing, err := netbox.NewNetBoxClient(server.URL, "token").CheckPermissions(context.Background())
+	g.Expect(err).ToNot(HaveOccurred(), "the version is reported by Version")
+	g.Expect(missing).To(BeEmpty())
+}
@@ -0,0 +1,66 @@
+package netbox
+
+import "strings"
+
+// The tokens of Netbox 3 and 4. Netbox 4 no longer returns the keys of v1 tokens, unless ALLOW_TOKEN_RETRIEVAL is
+// enabled. Netbox 4.5 added v2 tokens, of the form nbt_<key>.<secret>, which are sent as Bearer token and are listed in
+// /users/tokens/ with their key and version 2, but never with their secret.
+
+// v2TokenPrefix is the prefix of the v2 tokens of Netbox 4.
+const v2TokenPrefix = "nbt_"
+
+// isV2Token returns whether the token is a v2 token of Netbox 4.
+func isV2Token(apiToken string) bool {
+	return strings.HasPrefix(apiToken, v2TokenPrefix)
+}
+
+// authScheme returns the scheme of the Authorization header for the token.
+func authScheme(apiToken string) string {
+	if isV2Token(apiToken) {
+		return "Bearer"
+	}
+	return "Token"
+}
+
+// tokenKey returns the key that identifies the token in /users/tokens/. The secret of a v2 token is never returned by
+// Netbox, only its key is.
+func tokenKey(apiToken string) string {
+	if isV2Token(apiToken) {
+		key, _, _ := strings.Cut(strings.TrimPrefix(apiToken, v2TokenPrefix), ".")
+		return key
+	}
+	return apiToken
+}
+
+// findV4Token returns the token of the client in the tokens Netbox 4 listed for its key, or nil if it is not listed.
+func findV4Token(tokens []Token, apiToken string) *Token {
+	key := tokenKey(apiToken)
+	if isV2Token(apiToken) {
+		for i := range tokens {
+			if tokens[i].Version == 2 && tokens[i].Key == key {
+				return &tokens[i]
+			}
+		}
+		return nil
+	}
+	for i := range tokens {
+		if tokens[i].Version < 2 && tokens[i].Key == key {
+			return &tokens[i]
+		}
+	}
+	// Without the key, the token can only be identified by Netbox filtering the tokens on the key.
+	if len(tokens) == 1 && tokens[0].Version < 2 && tokens[0].Key == "" {
+		return &tokens[0]
+	}
+	return nil
+}
+
+// findV3Token returns the token with the key in the tokens Netbox 3 listed, or nil if it is not listed.
+func findV3Token(tokens []Token, key string) *Token {
+	for i := range tokens {
+		if tokens[i].Key == key {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package netbox_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+func TestCheckPermissionsSelectsTheTokensByAPIVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		apiVersion      string
+		expectedMissing []string
+	}{
+		{
+			name:            "Netbox 4 selected by the api version",
+			apiVersion:      "4",
+			expectedMissing: []string{"write enabled token"},
+		},
+		{
+			name:       "version not detected",
+			apiVersion: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			// A Netbox 4 that does not report a version the client can parse.
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.URL.Path == "/api/status/":
+					_, _ = w.Write([]byte(`{"netbox-version": "custom"}`))
+				case r.Method == http.MethodOptions:
+					_, _ = w.Write([]byte(`{"actions": {"POST": {}}}`))
+				case r.URL.Path == "/api/users/tokens/":
+					_, _ = w.Write([]byte(netboxPayloads["4"]["tokens"]))
+				default:
+					_, _ = w.Write([]byte(`{"count": 0, "results": []}`))
+				}
+			}))
+			defer server.Close()
+
+			client, err := netbox.NewNetBoxClientFor(server.URL, "0123456789abcdef", tt.apiVersion)
+			g.Expect(err).ToNot(HaveOccurred())
+			missing, err := client.CheckPermissions(context.Background())
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(missing).To(Equal(tt.expectedMissing))
+		})
+	}
+}
+
+func TestV2TokensRequireNetbox45(t *testing.T) {
+	g := NewWithT(t)
+
+	server := (&fakeNetbox{version: "4.2.3", authorization: "Bearer nbt_abc123.s3cr3t"}).serve()
+	defer server.Close()
+	client, err := netbox.NewNetBoxClientFor(server.URL, "nbt_abc123.s3cr3t", "4")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = client.Version(context.Background())
+	g.Expect(errors.Is(err, netbox.ErrUnsupportedVersion)).To(BeTrue())
+	g.Expect(err).To(MatchError(ContainSubstring("v2 tokens require Netbox 4.5 or newer")))
+}
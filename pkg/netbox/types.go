@@ -1,12 +1,94 @@
 package netbox
 
+import "time"
+
 type Vrf struct {
 	Name string `json:"name,omitempty"`
 }
 
+type Role struct {
+	Value string `json:"value,omitempty"`
+	Label string `json:"label,omitempty"`
+}
+
+type Status struct {
+	Value string `json:"value,omitempty"`
+	Label string `json:"label,omitempty"`
+}
+
 type IPAddress struct {
-	Address string `json:"address,omitempty"`
-	Vrf     Vrf    `json:"vrf,omitempty"`
+	Id                 int             `json:"id,omitempty"`
+	Display            string          `json:"display,omitempty"`
+	Address            string          `json:"address,omitempty"`
+	Vrf                Vrf             `json:"vrf,omitempty"`
+	Role               *Role           `json:"role,omitempty"`
+	DnsName            string          `json:"dns_name,omitempty"`
+	AssignedObjectType string          `json:"assigned_object_type,omitempty"`
+	AssignedObjectId   *int            `json:"assigned_object_id,omitempty"`
+	AssignedObject     *AssignedObject `json:"assigned_object,omitempty"`
+	Description        string          `json:"description,omitempty"`
+	Status             *Status         `json:"status,omitempty"`
+	LastUpdated        *time.Time      `json:"last_updated,omitempty"`
+	CustomFields       map[string]any  `json:"custom_fields,omitempty"`
+}
+
+// QuarantinedSince returns the time the ip-address was quarantined, and whether it is quarantined.
+func (a *IPAddress) QuarantinedSince() (time.Time, bool) {
+	since, err := time.Parse(time.RFC3339, customFieldString(a.CustomFields, QuarantinedCustomField))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+// AssignedObject is the interface an ip-address is assigned to, with the device or virtual machine it belongs to.
+type AssignedObject struct {
+	Id             int           `json:"id,omitempty"`
+	Display        string        `json:"display,omitempty"`
+	Device         *NestedObject `json:"device,omitempty"`
+	VirtualMachine *NestedObject `json:"virtual_machine,omitempty"`
+}
+
+type NestedObject struct {
+	Id      int    `json:"id,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type IPAddressRequest struct {
+	Address            string `json:"address"`
+	Vrf                *Vrf   `json:"vrf,omitempty"`
+	DnsName            string `json:"dns_name,omitempty"`
+	AssignedObjectType string `json:"assigned_object_type,omitempty"`
+	AssignedObjectId   int    `json:"assigned_object_id,omitempty"`
+	Description        string `json:"description,omitempty"`
+	Status             string `json:"status,omitempty"`
+}
+
+// IPAddressAttributes are the optional attributes of an ip-address created in Netbox.
+type IPAddressAttributes struct {
+	DnsName string
+	// Status is the status of the ip-address, or empty for the default status of Netbox.
+	Status string
+	// VMInterfaceId is the id of the virtual machine interface the ip-address is assigned to, or 0 if it is not
+	// assigned.
+	VMInterfaceId int
+	// Description is the description of the ip-address, or empty for no description.
+	Description string
+}
+
+type IPAddressDnsNameRequest struct {
+	DnsName string `json:"dns_name"`
+}
+
+type IPAddressQuarantineRequest struct {
+	Status       string         `json:"status"`
+	DnsName      string         `json:"dns_name"`
+	CustomFields map[string]any `json:"custom_fields"`
+}
+
+type IPAddressAssignmentRequest struct {
+	AssignedObjectType *string `json:"assigned_object_type"`
+	AssignedObjectId   *int    `json:"assigned_object_id"`
 }
 
 type IPAddressList struct {
@@ -15,11 +97,12 @@ type IPAddressList struct {
 }
 
 type IPRange struct {
-	Id           int    `json:"id,omitempty"`
-	Display      string `json:"display,omitempty"`
-	StartAddress string `json:"start_address,omitempty"`
-	EndAddress   string `json:"end_address,omitempty"`
-	Vrf          Vrf    `json:"vrf,omitempty"`
+	Id           int            `json:"id,omitempty"`
+	Display      string         `json:"display,omitempty"`
+	StartAddress string         `json:"start_address,omitempty"`
+	EndAddress   string         `json:"end_address,omitempty"`
+	Vrf          Vrf            `json:"vrf,omitempty"`
+	CustomFields map[string]any `json:"custom_fields,omitempty"`
 }
 
 type IPRangeList struct {
@@ -28,10 +111,11 @@ type IPRangeList struct {
 }
 
 type Prefix struct {
-	Id      int    `json:"id,omitempty"`
-	Display string `json:"display,omitempty"`
-	Prefix  string `json:"prefix,omitempty"`
-	Vrf     Vrf    `json:"vrf,omitempty"`
+	Id           int            `json:"id,omitempty"`
+	Display      string         `json:"display,omitempty"`
+	Prefix       string         `json:"prefix,omitempty"`
+	Vrf          Vrf            `json:"vrf,omitempty"`
+	CustomFields map[string]any `json:"custom_fields,omitempty"`
 }
 
 type PrefixList struct {
@@ -39,9 +123,148 @@ type PrefixList struct {
 	Results []Prefix `json:"results,omitempty"`
 }
 
-type PrefixRequest struct {
+type AvailablePrefixRequest struct {
+	PrefixLength int `json:"prefix_length"`
+}
+
+type AvailableIPAddress struct {
+	Family  int    `json:"family,omitempty"`
+	Address string `json:"address,omitempty"`
+	Vrf     *Vrf   `json:"vrf,omitempty"`
+}
+
+type ClusterType struct {
+	Id   int    `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Slug string `json:"slug,omitempty"`
+}
+
+type ClusterTypeList struct {
+	Count   int           `json:"count,omitempty"`
+	Results []ClusterType `json:"results,omitempty"`
+}
+
+type Cluster struct {
+	Id      int     `json:"id,omitempty"`
+	Display string  `json:"display,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	Status  *Status `json:"status,omitempty"`
+}
+
+type ClusterRequest struct {
+	Name   string       `json:"name"`
+	Type   *ClusterType `json:"type"`
+	Status string       `json:"status,omitempty"`
+}
+
+type ClusterStatusRequest struct {
+	Status string `json:"status"`
+}
+
+type ClusterList struct {
+	Count   int       `json:"count,omitempty"`
+	Results []Cluster `json:"results,omitempty"`
+}
+
+type VirtualMachine struct {
+	Id           int            `json:"id,omitempty"`
+	Display      string         `json:"display,omitempty"`
+	Name         string         `json:"name,omitempty"`
+	Status       *Status        `json:"status,omitempty"`
+	Cluster      *Cluster       `json:"cluster,omitempty"`
+	PrimaryIp4   *IPAddress     `json:"primary_ip4,omitempty"`
+	PrimaryIp6   *IPAddress     `json:"primary_ip6,omitempty"`
+	Tags         []Tag          `json:"tags,omitempty"`
+	CustomFields map[string]any `json:"custom_fields,omitempty"`
+}
+
+// HasTag returns whether the virtual machine is tagged with the tag with the given slug.
+func (v *VirtualMachine) HasTag(slug string) bool {
+	for _, tag := range v.Tags {
+		if tag.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+type VirtualMachineRequest struct {
+	Name    string   `json:"name"`
+	Status  string   `json:"status,omitempty"`
+	Cluster *Cluster `json:"cluster,omitempty"`
+	// Tags are the tags of the virtual machine, referenced by their Id.
+	Tags         []Tag          `json:"tags,omitempty"`
+	CustomFields map[string]any `json:"custom_fields,omitempty"`
+}
+
+// VirtualMachinePatch holds the fields of a virtual machine that are updated, fields that are not set are left alone.
+type VirtualMachinePatch struct {
+	Status     string `json:"status,omitempty"`
+	PrimaryIp4 *int   `json:"primary_ip4,omitempty"`
+	PrimaryIp6 *int   `json:"primary_ip6,omitempty"`
+}
+
+type VirtualMachineList struct {
+	Count   int              `json:"count,omitempty"`
+	Results []VirtualMachine `json:"results,omitempty"`
+}
+
+type VMInterface struct {
 	Id      int    `json:"id,omitempty"`
 	Display string `json:"display,omitempty"`
-	Address string `json:"address,omitempty"`
-	Vrf     Vrf    `json:"vrf,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+type VMInterfaceRequest struct {
+	VirtualMachine int    `json:"virtual_machine"`
+	Name           string `json:"name"`
+}
+
+type VMInterfaceList struct {
+	Count   int           `json:"count,omitempty"`
+	Results []VMInterface `json:"results,omitempty"`
+}
+
+type Tag struct {
+	Id   int    `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Slug string `json:"slug,omitempty"`
+}
+
+type TagList struct {
+	Count   int   `json:"count,omitempty"`
+	Results []Tag `json:"results,omitempty"`
+}
+
+type HostResult struct {
+	Id         int        `json:"id,omitempty"`
+	Display    string     `json:"display,omitempty"`
+	PrimaryIp4 *IPAddress `json:"primary_ip4,omitempty"`
+	PrimaryIp6 *IPAddress `json:"primary_ip6,omitempty"`
+}
+
+type Token struct {
+	Id int `json:"id,omitempty"`
+	// Version is 2 for the v2 tokens of Netbox 4.5, and not set for v1 tokens.
+	Version int `json:"version,omitempty"`
+	// Key is the key of a v1 token, which Netbox 4 only returns if token retrieval is allowed, or the key of a v2
+	// token.
+	Key          string `json:"key,omitempty"`
+	WriteEnabled bool   `json:"write_enabled"`
+}
+
+type TokenList struct {
+	Count   int     `json:"count,omitempty"`
+	Results []Token `json:"results,omitempty"`
+}
+
+// EndpointMetadata is the response of an OPTIONS request. Actions only holds the methods the token is permitted to
+// use on the endpoint.
+type EndpointMetadata struct {
+	Actions map[string]any `json:"actions,omitempty"`
+}
+
+// NetboxStatus is the response of the status endpoint of Netbox.
+type NetboxStatus struct {
+	NetboxVersion string `json:"netbox-version,omitempty"`
 }
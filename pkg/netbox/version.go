@@ -0,0 +1,147 @@
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// The version of Netbox is detected to reject the versions the client does not support, and to select how tokens are
+// handled, which is the only part of the API the client handles differently for Netbox 3 and 4, see tokens.go.
+//
+// The client does not decode separate Netbox 3 and Netbox 4 shapes. It only reads and writes the fields whose name and
+// type are the same in both versions: of nested objects it reads the id, display and name, which are returned by the
+// nested serializers of Netbox 3 and the brief serializers of Netbox 4 alike, and it matches vrfs on their name. Fields
+// whose shape differs between the versions are not supported, like the site of Netbox 3 prefixes that became the scope
+// of prefixes in Netbox 4.2. Reading or writing such a field requires selecting its shape with apiVersion first.
+
+// ErrUnsupportedVersion is returned when the version of Netbox is not supported by the client.
+var ErrUnsupportedVersion = errors.New("unsupported Netbox version")
+
+var (
+	// MinimumVersion is the oldest version of Netbox supported by the client.
+	MinimumVersion = Version{Major: 3, Minor: 5}
+	// MaximumMajorVersion is the newest major version of Netbox supported by the client.
+	MaximumMajorVersion = 4
+)
+
+// APIVersion selects the major version of the Netbox API of a client.
+type APIVersion string
+
+var (
+	// AutoAPIVersion detects the version of Netbox from its status.
+	AutoAPIVersion = APIVersion("")
+	APIVersion3    = APIVersion("3")
+	APIVersion4    = APIVersion("4")
+)
+
+// major returns the major version of Netbox of the API version, or 0 when it is detected.
+func (v APIVersion) major() (int, error) {
+	switch v {
+	case AutoAPIVersion:
+		return 0, nil
+	case APIVersion3:
+		return 3, nil
+	case APIVersion4:
+		return 4, nil
+	}
+	return 0, fmt.Errorf("unknown Netbox API version '%s', must be %s or %s", v, APIVersion3, APIVersion4)
+}
+
+// versionPattern matches the version Netbox reports, like "3.7.8", "4.1.0-beta1" or "v4.0-dev".
+var versionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// Version is the version of a Netbox instance.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses the version reported by Netbox. Pre-release suffixes are ignored. A version that can not be
+// parsed is not supported, so the returned error wraps ErrUnsupportedVersion.
+func ParseVersion(value string) (Version, error) {
+	match := versionPattern.FindStringSubmatch(value)
+	if match == nil {
+		return Version{}, fmt.Errorf("%w '%s', it can not be parsed", ErrUnsupportedVersion, value)
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	patch, _ := strconv.Atoi(match[3])
+	return Version{Major: major, Minor: minor, Patch: patch}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns whether the version is the given version or newer.
+func (v Version) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+// CheckSupported returns an error wrapping ErrUnsupportedVersion if the client does not support the version.
+func (v Version) CheckSupported() error {
+	if !v.AtLeast(MinimumVersion.Major, MinimumVersion.Minor) || v.Major > MaximumMajorVersion {
+		return fmt.Errorf("%w %s, supported are %d.%d up to %d.x", ErrUnsupportedVersion, v,
+			MinimumVersion.Major, MinimumVersion.Minor, MaximumMajorVersion)
+	}
+	return nil
+}
+
+func (c *client) Version(ctx context.Context) (Version, error) {
+	c.versionLock.Lock()
+	defer c.versionLock.Unlock()
+
+	version, err := c.detectVersion(ctx)
+	if err != nil {
+		return Version{}, err
+	}
+	if c.apiMajor != 0 && version.Major != c.apiMajor {
+		return version, fmt.Errorf("%w %s, the client is configured for Netbox API version %d", ErrUnsupportedVersion,
+			version, c.apiMajor)
+	}
+	if isV2Token(c.apiToken) && !version.AtLeast(4, 5) {
+		return version, fmt.Errorf("%w %s, v2 tokens require Netbox 4.5 or newer", ErrUnsupportedVersion, version)
+	}
+	return version, version.CheckSupported()
+}
+
+// detectVersion returns the version of Netbox from its status. The status is only retrieved once per client, the
+// version is kept for later calls. The caller must hold the versionLock.
+func (c *client) detectVersion(ctx context.Context) (Version, error) {
+	if c.version != nil {
+		return *c.version, nil
+	}
+	status := &NetboxStatus{}
+	response, err := c.restyClient.
+		R().
+		SetHeader("Accept", "application/json").
+		SetResult(status).
+		SetContext(ctx).
+		Get("/status/")
+	if err != nil {
+		return Version{}, errors.Wrap(err, "failed to get status")
+	}
+	if isFailure(response) {
+		return Version{}, fmt.Errorf("could not retrieve the status of Netbox successfully. (%d)", response.StatusCode())
+	}
+	version, err := ParseVersion(status.NetboxVersion)
+	if err != nil {
+		return Version{}, err
+	}
+	c.version = &version
+	return version, nil
+}
+
+// apiVersion returns the version of Netbox whose requests and responses the client uses. It is the detected version,
+// unless the client is configured for another major version, which Version reports as unsupported.
+func (c *client) apiVersion(detected Version) Version {
+	if c.apiMajor != 0 && detected.Major != c.apiMajor {
+		return Version{Major: c.apiMajor}
+	}
+	return detected
+}
@@ -0,0 +1,313 @@
+package netbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    netbox.Version
+		expectedErr bool
+	}{
+		{value: "3.7.8", expected: netbox.Version{Major: 3, Minor: 7, Patch: 8}},
+		{value: "4.1.0-beta1", expected: netbox.Version{Major: 4, Minor: 1}},
+		{value: "v4.0-dev", expected: netbox.Version{Major: 4}},
+		{value: "", expectedErr: true},
+		{value: "latest", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			g := NewWithT(t)
+			version, err := netbox.ParseVersion(tt.value)
+			if tt.expectedErr {
+				g.Expect(errors.Is(err, netbox.ErrUnsupportedVersion)).To(BeTrue())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(version).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestVersionCheckSupported(t *testing.T) {
+	tests := []struct {
+		version   netbox.Version
+		supported bool
+	}{
+		{version: netbox.Version{Major: 2, Minor: 11}, supported: false},
+		{version: netbox.Version{Major: 3, Minor: 4, Patch: 10}, supported: false},
+		{version: netbox.Version{Major: 3, Minor: 5}, supported: true},
+		{version: netbox.Version{Major: 4, Minor: 2, Patch: 1}, supported: true},
+		{version: netbox.Version{Major: 5}, supported: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version.String(), func(t *testing.T) {
+			g := NewWithT(t)
+			err := tt.version.CheckSupported()
+			if tt.supported {
+				g.Expect(err).ToNot(HaveOccurred())
+			} else {
+				g.Expect(errors.Is(err, netbox.ErrUnsupportedVersion)).To(BeTrue())
+			}
+		})
+	}
+}
+
+func TestClientVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	requests := 0
+	version := "3.7.8"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.URL.Path).To(Equal("/api/status/"))
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"django-version": "4.2.11", "netbox-version": "` + version + `"}`))
+	}))
+	defer server.Close()
+
+	client := netbox.NewNetBoxClient(server.URL, "token")
+	for range 2 {
+		v, err := client.Version(context.Background())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(v).To(Equal(netbox.Version{Major: 3, Minor: 7, Patch: 8}))
+	}
+	g.Expect(requests).To(Equal(1), "the version is queried once per client")
+
+	version = "2.11.12"
+	v, err := netbox.NewNetBoxClient(server.URL, "token").Version(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported Netbox version 2.11.12")))
+	g.Expect(v.String()).To(Equal("2.11.12"))
+}
+
+// fakeNetbox is a fake Netbox of the given version, serving the payloads of that version. It only accepts requests
+// with the given Authorization header.
+type fakeNetbox struct {
+	version       string
+	authorization string
+	// tokenKey is the key the token of the client is listed with in /users/tokens/.
+	tokenKey string
+}
+
+// v3 and v4 payloads of the same prefix, ip-address and token. Netbox 4 nests brief objects with more fields, has a
+// scope on prefixes, and does not return the keys of v1 tokens. The tokens are listed for the key of the token of the
+// client, %s is replaced by that key.
+var netboxPayloads = map[string]map[string]string{
+	"3": {
+		"prefixes": `{"count": 1, "results": [{"id": 7, "display": "10.0.0.0/24", "prefix": "10.0.0.0/24",
+			"site": {"id": 1, "url": "http://netbox/api/dcim/sites/1/", "display": "site", "name": "site", "slug": "site"},
+			"vrf": {"id": 2, "url": "http://netbox/api/ipam/vrfs/2/", "display": "vrf", "name": "vrf", "rd": null},
+			"custom_fields": {"gateway": "10.0.0.1"}}]}`,
+		"available-ips": `[{"family": 4, "address": "10.0.0.2/24", "vrf": {"id": 2, "url": "http://netbox/api/ipam/vrfs/2/", "display": "vrf", "name": "vrf", "rd": null}}]`,
+		"ip-address": `{"id": 11, "display": "10.0.0.2/24", "address": "10.0.0.2/24",
+			"vrf": {"id": 2, "url": "http://netbox/api/ipam/vrfs/2/", "display": "vrf", "name": "vrf", "rd": null},
+			"status": {"value": "active", "label": "Active"}, "dns_name": "node.example.com"}`,
+		"tokens": `{"count": 1, "results": [{"id": 1, "display": "%s", "key": "%s", "write_enabled": false}]}`,
+	},
+	"4": {
+		"prefixes": `{"count": 1, "results": [{"id": 7, "display": "10.0.0.0/24", "prefix": "10.0.0.0/24",
+			"scope_type": "dcim.site", "scope_id": 1,
+			"scope": {"id": 1, "url": "http://netbox/api/dcim/sites/1/", "display": "site", "name": "site", "slug": "site", "description": ""},
+			"vrf": {"id": 2, "url": "http://netbox/api/ipam/vrfs/2/", "display": "vrf", "name": "vrf", "rd": null, "description": ""},
+			"custom_fields": {"gateway": "10.0.0.1"}}]}`,
+		"available-ips": `[{"family": 4, "address": "10.0.0.2/24", "vrf": {"id": 2, "url": "http://netbox/api/ipam/vrfs/2/", "display": "vrf", "name": "vrf", "rd": null, "description": ""}}]`,
+		"ip-address": `{"id": 11, "display": "10.0.0.2/24", "address": "10.0.0.2/24",
+			"vrf": {"id": 2, "url": "http://netbox/api/ipam/vrfs/2/", "display": "vrf", "name": "vrf", "rd": null, "description": ""},
+			"tenant": null, "status": {"value": "active", "label": "Active"}, "dns_name": "node.example.com"}`,
+		"tokens": `{"count": 1, "results": [{"id": 1, "user": {"id": 1, "username": "admin"}, "expires": null,
+			"write_enabled": false}]}`,
+		"v2-tokens": `{"count": 1, "results": [{"id": 1, "version": 2, "display": "%s", "key": "%s", "enabled": true,
+			"write_enabled": false}]}`,
+	},
+}
+
+// Error payloads of Netbox, which are the same in Netbox 3 and 4: validation errors are keyed by the field, other errors
+// have a detail.
+const (
+	duplicatePayload = `{"address": ["Duplicate IP address found in VRF vrf: 10.0.0.3/24"]}`
+	forbiddenPayload = `{"detail": "You do not have permission to perform this action."}`
+	exhaustedPayload = `{"detail": "Insufficient space is available to accommodate the requested prefix size(s)"}`
+)
+
+func (f *fakeNetbox) serve() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != f.authorization {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"detail": "Invalid token"}`))
+			return
+		}
+		payloads := netboxPayloads[f.version[:1]]
+		switch {
+		case r.URL.Path == "/api/status/":
+			_, _ = w.Write([]byte(`{"netbox-version": "` + f.version + `"}`))
+		case r.URL.Path == "/api/ipam/prefixes/" && r.URL.Query().Get("prefix") == "10.0.0.0/24":
+			_, _ = w.Write([]byte(payloads["prefixes"]))
+		case r.URL.Path == "/api/ipam/prefixes/7/available-ips/":
+			_, _ = w.Write([]byte(payloads["available-ips"]))
+		case r.URL.Path == "/api/ipam/prefixes/7/available-prefixes/" && r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(exhaustedPayload))
+		case r.URL.Path == "/api/ipam/ip-addresses/11/" && r.Method == http.MethodPatch:
+			request := map[string]string{}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(strings.ReplaceAll(payloads["ip-address"], "node.example.com", request["dns_name"])))
+		case r.URL.Path == "/api/ipam/ip-addresses/11/" && r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/api/ipam/ip-addresses/12/" && r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(forbiddenPayload))
+		case r.URL.Path == "/api/ipam/ip-addresses/" && r.Method == http.MethodPost:
+			request := map[string]any{}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if request["address"] == "10.0.0.3/24" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(duplicatePayload))
+				return
+			}
+			if request["address"] != "10.0.0.2/24" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(payloads["ip-address"]))
+		case r.URL.Path == "/api/ipam/ip-addresses/" && r.Method == http.MethodOptions:
+			_, _ = w.Write([]byte(`{"actions": {"POST": {}}}`))
+		case r.URL.Path == "/api/users/tokens/":
+			if r.URL.Query().Get("key") != f.tokenKey {
+				_, _ = w.Write([]byte(`{"count": 0, "results": []}`))
+				return
+			}
+			tokens := payloads["tokens"]
+			if strings.HasPrefix(f.authorization, "Bearer ") {
+				tokens = payloads["v2-tokens"]
+			}
+			_, _ = w.Write([]byte(strings.ReplaceAll(tokens, "%s", f.tokenKey)))
+		default:
+			_, _ = w.Write([]byte(`{"count": 0, "results": []}`))
+		}
+	}))
+}
+
+func TestClientVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		netbox     fakeNetbox
+		apiToken   string
+		apiVersion string
+		expected   netbox.Version
+	}{
+		{
+			name:     "Netbox 3",
+			netbox:   fakeNetbox{version: "3.7.8", authorization: "Token 0123456789abcdef", tokenKey: "0123456789abcdef"},
+			apiToken: "0123456789abcdef",
+			expected: netbox.Version{Major: 3, Minor: 7, Patch: 8},
+		},
+		{
+			name:       "Netbox 3 selected by the api version",
+			netbox:     fakeNetbox{version: "3.7.8", authorization: "Token 0123456789abcdef", tokenKey: "0123456789abcdef"},
+			apiToken:   "0123456789abcdef",
+			apiVersion: "3",
+			expected:   netbox.Version{Major: 3, Minor: 7, Patch: 8},
+		},
+		{
+			name:     "Netbox 4 with a v1 token",
+			netbox:   fakeNetbox{version: "4.2.3", authorization: "Token 0123456789abcdef", tokenKey: "0123456789abcdef"},
+			apiToken: "0123456789abcdef",
+			expected: netbox.Version{Major: 4, Minor: 2, Patch: 3},
+		},
+		{
+			name:       "Netbox 4 with a v2 token",
+			netbox:     fakeNetbox{version: "4.5.0", authorization: "Bearer nbt_abc123.s3cr3t", tokenKey: "abc123"},
+			apiToken:   "nbt_abc123.s3cr3t",
+			apiVersion: "4",
+			expected:   netbox.Version{Major: 4, Minor: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server := tt.netbox.serve()
+			defer server.Close()
+			ctx := context.Background()
+
+			client, err := netbox.NewNetBoxClientFor(server.URL, tt.apiToken, tt.apiVersion)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			version, err := client.Version(ctx)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(version).To(Equal(tt.expected))
+
+			pool, err := client.GetPrefix(ctx, "10.0.0.0/24", "vrf")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pool.Id).To(Equal(7))
+			g.Expect(pool.Vrf).To(Equal("vrf"))
+			g.Expect(pool.Gateway).To(Equal("10.0.0.1"))
+
+			available, err := client.GetAvailableIPAddresses(ctx, pool, 1)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(available).To(HaveLen(1))
+			g.Expect(available[0].String()).To(Equal("10.0.0.2/24"))
+
+			ipAddress, err := client.CreateIPAddress(ctx, "10.0.0.2/24", "vrf", netbox.IPAddressAttributes{DnsName: "node.example.com"})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ipAddress.Id).To(Equal(11))
+			g.Expect(ipAddress.Vrf.Name).To(Equal("vrf"))
+			g.Expect(ipAddress.Status.Value).To(Equal("active"))
+
+			updated, err := client.UpdateIPAddressDnsName(ctx, ipAddress.Id, "other.example.com")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(updated.DnsName).To(Equal("other.example.com"))
+			g.Expect(client.DeleteIPAddress(ctx, ipAddress.Id)).To(Succeed())
+
+			_, err = client.CreateIPAddress(ctx, "10.0.0.3/24", "vrf", netbox.IPAddressAttributes{})
+			g.Expect(errors.Is(err, netbox.ErrDuplicateIPAddress)).To(BeTrue())
+			_, err = client.CreateAvailablePrefix(ctx, pool, 28)
+			g.Expect(errors.Is(err, netbox.ErrPoolExhausted)).To(BeTrue())
+			err = client.DeleteIPAddress(ctx, 12)
+			g.Expect(err).To(MatchError(ContainSubstring("could not delete ip-address 12 successfully. (403)")))
+
+			missing, err := client.CheckPermissions(ctx)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(missing).To(Equal([]string{"write enabled token"}))
+		})
+	}
+}
+
+func TestNewNetBoxClientFor(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := netbox.NewNetBoxClientFor("http://netbox.local", "token", "5")
+	g.Expect(err).To(MatchError(ContainSubstring("unknown Netbox API version '5'")))
+
+	_, err = netbox.NewNetBoxClientFor("http://netbox.local", "nbt_abc123.s3cr3t", "3")
+	g.Expect(err).To(MatchError(ContainSubstring("v2 tokens require Netbox API version 4")))
+
+	server := (&fakeNetbox{version: "3.7.8", authorization: "Token token"}).serve()
+	defer server.Close()
+	client, err := netbox.NewNetBoxClientFor(server.URL, "token", "4")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = client.Version(context.Background())
+	g.Expect(errors.Is(err, netbox.ErrUnsupportedVersion)).To(BeTrue())
+	g.Expect(err).To(MatchError(ContainSubstring("configured for Netbox API version 4")))
+}
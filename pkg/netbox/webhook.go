@@ -0,0 +1,95 @@
+package netbox
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/seancfoley/ipaddress-go/ipaddr"
+)
+
+// WebhookSignatureHeader is the header of a Netbox webhook request that holds the signature of the payload.
+const WebhookSignatureHeader = "X-Hook-Signature"
+
+type WebhookModel string
+
+var (
+	IPAddressWebhookModel = WebhookModel("ipaddress")
+	PrefixWebhookModel    = WebhookModel("prefix")
+	IPRangeWebhookModel   = WebhookModel("iprange")
+)
+
+// WebhookPayload is the body of a webhook request sent by Netbox when an object changes.
+type WebhookPayload struct {
+	Event     string            `json:"event"`
+	Model     WebhookModel      `json:"model"`
+	Data      *WebhookObject    `json:"data,omitempty"`
+	Snapshots *WebhookSnapshots `json:"snapshots,omitempty"`
+}
+
+// WebhookSnapshots holds the object of a webhook before and after the change.
+type WebhookSnapshots struct {
+	Prechange  *WebhookObject `json:"prechange,omitempty"`
+	Postchange *WebhookObject `json:"postchange,omitempty"`
+}
+
+// WebhookObject holds the fields of a changed ip-address, prefix or ip-range that identify the addresses it covers.
+type WebhookObject struct {
+	Address      string `json:"address,omitempty"`
+	Prefix       string `json:"prefix,omitempty"`
+	StartAddress string `json:"start_address,omitempty"`
+	EndAddress   string `json:"end_address,omitempty"`
+}
+
+// VerifyWebhookSignature returns whether the signature is the hex encoded HMAC-SHA512 of the body with the secret of
+// the webhook, as Netbox computes it.
+func VerifyWebhookSignature(secret []byte, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha512.New, secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// ParseWebhookPayload parses the body of a Netbox webhook request.
+func ParseWebhookPayload(body []byte) (*WebhookPayload, error) {
+	payload := &WebhookPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, errors.Wrap(err, "could not parse webhook payload")
+	}
+	return payload, nil
+}
+
+// Range returns the addresses covered by the object of the given model, or nil if the object has none.
+func (o *WebhookObject) Range(model WebhookModel) (*ipaddr.SequentialRange[*ipaddr.IPAddress], error) {
+	switch model {
+	case IPAddressWebhookModel:
+		address, err := ipaddr.NewIPAddressString(o.Address).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse address '%s'", o.Address))
+		}
+		return address.WithoutPrefixLen().ToSequentialRange(), nil
+	case PrefixWebhookModel:
+		prefix, err := ipaddr.NewIPAddressString(o.Prefix).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse prefix '%s'", o.Prefix))
+		}
+		return prefix.ToPrefixBlock().ToSequentialRange(), nil
+	case IPRangeWebhookModel:
+		lower, err := ipaddr.NewIPAddressString(o.StartAddress).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse startAddress '%s'", o.StartAddress))
+		}
+		upper, err := ipaddr.NewIPAddressString(o.EndAddress).ToAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse endAddress '%s'", o.EndAddress))
+		}
+		return lower.WithoutPrefixLen().SpanWithRange(upper.WithoutPrefixLen()), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+package netbox_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/erwin-kok/cluster-api-ipam-provider-netbox/pkg/netbox"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"event":"created"}`)
+	mac := hmac.New(sha512.New, secret)
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		valid     bool
+	}{
+		{name: "valid signature", body: body, signature: signature, valid: true},
+		{name: "changed body", body: []byte(`{"event":"deleted"}`), signature: signature, valid: false},
+		{name: "missing signature", body: body, signature: "", valid: false},
+		{name: "malformed signature", body: body, signature: "not-hex", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(netbox.VerifyWebhookSignature(secret, tt.body, tt.signature)).To(Equal(tt.valid))
+		})
+	}
+}
+
+func TestWebhookObjectRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    netbox.WebhookModel
+		object   netbox.WebhookObject
+		expected string
+	}{
+		{name: "ip-address", model: netbox.IPAddressWebhookModel, object: netbox.WebhookObject{Address: "10.0.0.5/24"},
+			expected: "10.0.0.5 -> 10.0.0.5"},
+		{name: "prefix", model: netbox.PrefixWebhookModel, object: netbox.WebhookObject{Prefix: "10.0.0.0/30"},
+			expected: "10.0.0.0 -> 10.0.0.3"},
+		{name: "ip-range", model: netbox.IPRangeWebhookModel,
+			object:   netbox.WebhookObject{StartAddress: "10.0.0.10/24", EndAddress: "10.0.0.20/24"},
+			expected: "10.0.0.10 -> 10.0.0.20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			rng, err := tt.object.Range(tt.model)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(rng.String()).To(Equal(tt.expected))
+		})
+	}
+}